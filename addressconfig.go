@@ -8,6 +8,7 @@ package main
 import (
 	"bytes"
 	"log"
+	"math"
 	"net"
 	"sort"
 
@@ -57,18 +58,22 @@ func cleanupAddressesOnDisconnectedInterfaces(family winipcfg.AddressFamily, add
 	}
 }
 
-func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *tun.NativeTun) error {
-	luid := winipcfg.LUID(tun.LUID())
-
-	estimatedRouteCount := 0
-	for _, peer := range conf.Peers {
-		estimatedRouteCount += len(peer.AllowedIPs)
+// tableMetric offsets a route metric by the numeric routing table. Windows has no policy routing tables, so
+// Table = <n> instead installs the tunnel's routes n metric points behind those of the main table, letting
+// routes of other interfaces to the same destinations take precedence.
+func tableMetric(table, metric uint32) uint32 {
+	if metric > math.MaxUint32-table {
+		return math.MaxUint32
 	}
-	routes := make([]winipcfg.RouteData, 0, estimatedRouteCount)
-	addresses := make([]net.IPNet, len(conf.Interface.Addresses))
+	return table + metric
+}
+
+// routesForConfig builds the deduplicated routes for the extra routes and the peers' allowed IPs, skipping
+// those of a family without an interface address. Duplicates differing only by metric keep the lowest metric, as Windows keys
+// routes by destination and next hop alone.
+func routesForConfig(config *conf.Config) (routes []*winipcfg.RouteData, foundDefault4, foundDefault6 bool) {
 	var haveV4Address, haveV6Address bool
-	for i, addr := range conf.Interface.Addresses {
-		addresses[i] = addr.IPNet()
+	for _, addr := range config.Interface.Addresses {
 		if addr.Bits() == 32 {
 			haveV4Address = true
 		} else if addr.Bits() == 128 {
@@ -76,70 +81,86 @@ func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *t
 		}
 	}
 
-	foundDefault4 := false
-	foundDefault6 := false
-	for _, peer := range conf.Peers {
-		for _, allowedip := range peer.AllowedIPs {
-			allowedip.MaskSelf()
-			if (allowedip.Bits() == 32 && !haveV4Address) || (allowedip.Bits() == 128 && !haveV6Address) {
-				continue
-			}
-			route := winipcfg.RouteData{
-				Destination: allowedip.IPNet(),
-				Metric:      0,
+	estimatedRouteCount := len(config.Interface.Routes)
+	for _, peer := range config.Peers {
+		estimatedRouteCount += len(peer.AllowedIPs)
+	}
+	allRoutes := make([]winipcfg.RouteData, 0, estimatedRouteCount)
+	addRoute := func(destination conf.IPCidr, metric uint32) {
+		destination.MaskSelf()
+		if (destination.Bits() == 32 && !haveV4Address) || (destination.Bits() == 128 && !haveV6Address) {
+			return
+		}
+		route := winipcfg.RouteData{
+			Destination: destination.IPNet(),
+			Metric:      metric,
+		}
+		if destination.Bits() == 32 {
+			if destination.Cidr == 0 {
+				foundDefault4 = true
 			}
-			if allowedip.Bits() == 32 {
-				if allowedip.Cidr == 0 {
-					foundDefault4 = true
-				}
-				route.NextHop = net.IPv4zero
-			} else if allowedip.Bits() == 128 {
-				if allowedip.Cidr == 0 {
-					foundDefault6 = true
-				}
-				route.NextHop = net.IPv6zero
+			route.NextHop = net.IPv4zero
+		} else if destination.Bits() == 128 {
+			if destination.Cidr == 0 {
+				foundDefault6 = true
 			}
-			routes = append(routes, route)
+			route.NextHop = net.IPv6zero
 		}
+		allRoutes = append(allRoutes, route)
 	}
-
-	err := luid.SetIPAddressesForFamily(family, addresses)
-	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
-		cleanupAddressesOnDisconnectedInterfaces(family, addresses)
-		err = luid.SetIPAddressesForFamily(family, addresses)
+	for _, route := range config.Interface.Routes {
+		addRoute(route, tableMetric(config.Interface.Table, 0))
 	}
-	if err != nil {
-		return err
+	for _, peer := range config.Peers {
+		for _, allowedip := range peer.AllowedIPs {
+			addRoute(allowedip, tableMetric(config.Interface.Table, peer.RouteMetric))
+		}
 	}
 
-	deduplicatedRoutes := make([]*winipcfg.RouteData, 0, len(routes))
-	sort.Slice(routes, func(i, j int) bool {
-		if routes[i].Metric != routes[j].Metric {
-			return routes[i].Metric < routes[j].Metric
-		}
-		if c := bytes.Compare(routes[i].NextHop, routes[j].NextHop); c != 0 {
+	routes = make([]*winipcfg.RouteData, 0, len(allRoutes))
+	sort.Slice(allRoutes, func(i, j int) bool {
+		if c := bytes.Compare(allRoutes[i].NextHop, allRoutes[j].NextHop); c != 0 {
 			return c < 0
 		}
-		if c := bytes.Compare(routes[i].Destination.IP, routes[j].Destination.IP); c != 0 {
+		if c := bytes.Compare(allRoutes[i].Destination.IP, allRoutes[j].Destination.IP); c != 0 {
 			return c < 0
 		}
-		if c := bytes.Compare(routes[i].Destination.Mask, routes[j].Destination.Mask); c != 0 {
+		if c := bytes.Compare(allRoutes[i].Destination.Mask, allRoutes[j].Destination.Mask); c != 0 {
 			return c < 0
 		}
-		return false
+		return allRoutes[i].Metric < allRoutes[j].Metric
 	})
-	for i := 0; i < len(routes); i++ {
-		if i > 0 && routes[i].Metric == routes[i-1].Metric &&
-			bytes.Equal(routes[i].NextHop, routes[i-1].NextHop) &&
-			bytes.Equal(routes[i].Destination.IP, routes[i-1].Destination.IP) &&
-			bytes.Equal(routes[i].Destination.Mask, routes[i-1].Destination.Mask) {
+	for i := 0; i < len(allRoutes); i++ {
+		if i > 0 && bytes.Equal(allRoutes[i].NextHop, allRoutes[i-1].NextHop) &&
+			bytes.Equal(allRoutes[i].Destination.IP, allRoutes[i-1].Destination.IP) &&
+			bytes.Equal(allRoutes[i].Destination.Mask, allRoutes[i-1].Destination.Mask) {
 			continue
 		}
-		deduplicatedRoutes = append(deduplicatedRoutes, &routes[i])
+		routes = append(routes, &allRoutes[i])
+	}
+	return
+}
+
+func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *tun.NativeTun) error {
+	luid := winipcfg.LUID(tun.LUID())
+
+	addresses := make([]net.IPNet, len(conf.Interface.Addresses))
+	for i, addr := range conf.Interface.Addresses {
+		addresses[i] = addr.IPNet()
+	}
+	routes, foundDefault4, foundDefault6 := routesForConfig(conf)
+
+	err := luid.SetIPAddressesForFamily(family, addresses)
+	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+		cleanupAddressesOnDisconnectedInterfaces(family, addresses)
+		err = luid.SetIPAddressesForFamily(family, addresses)
+	}
+	if err != nil {
+		return err
 	}
 
 	if !conf.Interface.TableOff {
-		err = luid.SetRoutesForFamily(family, deduplicatedRoutes)
+		err = luid.SetRoutesForFamily(family, routes)
 		if err != nil {
 			return err
 		}
@@ -153,38 +174,37 @@ func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *t
 		ipif.NLMTU = uint32(conf.Interface.MTU)
 		tun.ForceMTU(int(ipif.NLMTU))
 	}
-	if family == windows.AF_INET {
-		if foundDefault4 {
-			ipif.UseAutomaticMetric = false
-			ipif.Metric = 0
-		}
-	} else if family == windows.AF_INET6 {
-		if foundDefault6 {
-			ipif.UseAutomaticMetric = false
-			ipif.Metric = 0
-		}
+	if conf.Interface.InterfaceMetric > 0 {
+		ipif.UseAutomaticMetric = false
+		ipif.Metric = conf.Interface.InterfaceMetric
+	} else if conf.Interface.Table == 0 && ((family == windows.AF_INET && foundDefault4) || (family == windows.AF_INET6 && foundDefault6)) {
+		ipif.UseAutomaticMetric = false
+		ipif.Metric = 0
+	}
+	if family == windows.AF_INET6 {
 		ipif.DadTransmits = 0
 		ipif.RouterDiscoveryBehavior = winipcfg.RouterDiscoveryDisabled
 	}
 	return ipif.Set()
 }
 
+// firewallRestrictions decides which firewall rules enableFirewall installs. The
+// full kill-switch is used whenever the peers capture the default route of
+// either family with routes that take precedence, while DNS leak blocking is
+// also available to split tunnels that opt in.
+func firewallRestrictions(conf *conf.Config) (doNotRestrict bool, blockDNSLeaks bool) {
+	doNotRestrict = conf.RoutesPreempted() || !conf.DefaultRouteCapture().Any()
+	blockDNSLeaks = len(conf.Interface.DNS) > 0 && (!doNotRestrict || conf.Interface.BlockDNSLeaks)
+	return
+}
+
 func enableFirewall(conf *conf.Config, tun *tun.NativeTun) error {
-	doNotRestrict := true
-	if len(conf.Peers) == 1 && !conf.Interface.TableOff {
-	nextallowedip:
-		for _, allowedip := range conf.Peers[0].AllowedIPs {
-			if allowedip.Cidr == 0 {
-				for _, b := range allowedip.IP {
-					if b != 0 {
-						continue nextallowedip
-					}
-				}
-				doNotRestrict = false
-				break
-			}
-		}
+	capture := conf.DefaultRouteCapture()
+	log.Printf("Default route capture: IPv4=%v, IPv6=%v", capture.IPv4, capture.IPv6)
+	doNotRestrict, blockDNSLeaks := firewallRestrictions(conf)
+	if conf.Interface.BlockDNSLeaks && len(conf.Interface.DNS) == 0 {
+		log.Println("Warning: not blocking DNS leaks, because no DNS servers are configured")
 	}
 	log.Println("Enabling firewall rules")
-	return firewall.EnableFirewall(tun.LUID(), doNotRestrict, conf.Interface.DNS)
+	return firewall.EnableFirewall(tun.LUID(), doNotRestrict, blockDNSLeaks, conf.Interface.DNS)
 }
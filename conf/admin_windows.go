@@ -34,3 +34,27 @@ func AdminBool(name string) bool {
 	}
 	return val != 0
 }
+
+func AdminString(name string) string {
+	key, err := openAdminKey()
+	if err != nil {
+		return ""
+	}
+	val, _, err := key.GetStringValue(name)
+	if err != nil {
+		return ""
+	}
+	return val
+}
+
+func AdminInteger(name string) uint64 {
+	key, err := openAdminKey()
+	if err != nil {
+		return 0
+	}
+	val, _, err := key.GetIntegerValue(name)
+	if err != nil {
+		return 0
+	}
+	return val
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
 	"time"
 
@@ -33,6 +34,50 @@ type Endpoint struct {
 }
 
 type Key [KeyLength]byte
+
+// JunkPackets is a set of junk packet parameters, corresponding to Jc, Jmin and Jmax.
+type JunkPackets struct {
+	Count   uint16
+	MinSize uint16
+	MaxSize uint16
+}
+
+// Proxy is a local SOCKS5 and HTTP CONNECT proxy through which other applications can use the tunnel without
+// routing all of their traffic through it. Credentials are optional.
+type Proxy struct {
+	Address  netip.AddrPort // a loopback address, or the zero value to not run a proxy
+	Username string
+	Password string
+}
+
+// Metrics is where a tunnel serves its metrics in the OpenMetrics text format over HTTP, which is nowhere for the
+// zero value.
+type Metrics struct {
+	Address netip.AddrPort // a loopback address
+	Pipe    bool           // the metrics pipe of the tunnel, services.MetricsPipePathOfTunnel
+}
+
+// LogRedaction is what the log of a tunnel hides beyond keys and passwords, which it always hides. Each level
+// hides what the ones before it do.
+type LogRedaction uint8
+
+const (
+	LogRedactSecrets LogRedaction = iota
+	LogRedactAddresses
+	LogRedactHostnames
+)
+
+// LogLevel is the least severe kind of device log lines that the log of a tunnel keeps. LogLevelDefault leaves
+// it to the LogLevel admin registry value, or else info, which drops the verbose lines.
+type LogLevel uint8
+
+const (
+	LogLevelDefault LogLevel = iota
+	LogLevelVerbose
+	LogLevelInfo
+	LogLevelError
+)
+
 type HandshakeTime time.Duration
 type Bytes uint64
 
@@ -54,6 +99,23 @@ type Interface struct {
 	PreDown    string
 	PostDown   string
 	TableOff   bool
+	Table      uint32 // numeric routing table, 0 for the main table
+	Routes     []IPCidr
+
+	DNSDomains    []string
+	BlockDNSLeaks bool
+
+	InterfaceMetric uint32 // 0 lets Windows pick, unless the default route is captured
+
+	HandshakeWatchdog    []time.Duration // times without a handshake for pending traffic escalating to re-resolve, rebind, rotate junk, fail
+	AlternateJunkPackets []JunkPackets
+
+	Proxy   Proxy
+	Metrics Metrics
+
+	LogRedaction      LogRedaction
+	LogRedactPatterns []string // regular expressions to hide in the log, in addition to what LogRedaction hides
+	LogLevel          LogLevel
 
 	JunkPacketCount            uint16
 	JunkPacketMinSize          uint16
@@ -86,6 +148,7 @@ type Peer struct {
 	AllowedIPs          []IPCidr
 	Endpoint            Endpoint
 	PersistentKeepalive string // "a", "a-b", or empty/"0"/"off"
+	RouteMetric         uint32
 
 	RxBytes           Bytes
 	TxBytes           Bytes
@@ -118,20 +181,28 @@ func (r *IPCidr) MaskSelf() {
 	}
 }
 
+// IntersectsWith reports whether the two configurations share an address or have a network of an address or
+// allowed IP in common, in which case they cannot run side by side.
 func (conf *Config) IntersectsWith(other *Config) bool {
 	type hashableIPCidr struct {
 		ip   string
 		cidr byte
 	}
+	masked := func(a IPCidr) IPCidr {
+		// MaskSelf writes through the slice, which is shared with the configuration.
+		a.IP = append(net.IP(nil), a.IP...)
+		a.MaskSelf()
+		return a
+	}
 	allRoutes := make(map[hashableIPCidr]bool, len(conf.Interface.Addresses)*2+len(conf.Peers)*3)
 	for _, a := range conf.Interface.Addresses {
 		allRoutes[hashableIPCidr{string(a.IP), byte(len(a.IP) * 8)}] = true
-		a.MaskSelf()
+		a = masked(a)
 		allRoutes[hashableIPCidr{string(a.IP), a.Cidr}] = true
 	}
 	for i := range conf.Peers {
 		for _, a := range conf.Peers[i].AllowedIPs {
-			a.MaskSelf()
+			a = masked(a)
 			allRoutes[hashableIPCidr{string(a.IP), a.Cidr}] = true
 		}
 	}
@@ -139,14 +210,14 @@ func (conf *Config) IntersectsWith(other *Config) bool {
 		if allRoutes[hashableIPCidr{string(a.IP), byte(len(a.IP) * 8)}] {
 			return true
 		}
-		a.MaskSelf()
+		a = masked(a)
 		if allRoutes[hashableIPCidr{string(a.IP), a.Cidr}] {
 			return true
 		}
 	}
 	for i := range other.Peers {
 		for _, a := range other.Peers[i].AllowedIPs {
-			a.MaskSelf()
+			a = masked(a)
 			if allRoutes[hashableIPCidr{string(a.IP), a.Cidr}] {
 				return true
 			}
@@ -262,6 +333,54 @@ func (b Bytes) String() string {
 	return l18n.Sprintf("%.2f\u00a0TiB", float64(b)/(1024*1024*1024)/1024)
 }
 
+func (j JunkPackets) String() string {
+	return fmt.Sprintf("%d, %d, %d", j.Count, j.MinSize, j.MaxSize)
+}
+
+func (m *Metrics) IsEmpty() bool {
+	return !m.Pipe && !m.Address.IsValid()
+}
+
+func (m *Metrics) String() string {
+	if m.Pipe {
+		return "pipe"
+	}
+	return m.Address.String()
+}
+
+func (r LogRedaction) String() string {
+	switch r {
+	case LogRedactAddresses:
+		return "addresses"
+	case LogRedactHostnames:
+		return "hostnames"
+	}
+	return "secrets"
+}
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogLevelVerbose:
+		return "verbose"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelError:
+		return "error"
+	}
+	return "default"
+}
+
+func (p *Proxy) IsEmpty() bool {
+	return !p.Address.IsValid()
+}
+
+func (p *Proxy) String() string {
+	if len(p.Username) == 0 {
+		return p.Address.String()
+	}
+	return p.Username + ":" + p.Password + "@" + p.Address.String()
+}
+
 func (conf *Config) DeduplicateNetworkEntries() {
 	m := make(map[string]bool, len(conf.Interface.Addresses))
 	i := 0
@@ -289,6 +408,19 @@ func (conf *Config) DeduplicateNetworkEntries() {
 	}
 	conf.Interface.DNS = conf.Interface.DNS[:i]
 
+	m = make(map[string]bool, len(conf.Interface.Routes))
+	i = 0
+	for _, route := range conf.Interface.Routes {
+		s := route.String()
+		if m[s] {
+			continue
+		}
+		m[s] = true
+		conf.Interface.Routes[i] = route
+		i++
+	}
+	conf.Interface.Routes = conf.Interface.Routes[:i]
+
 	for _, peer := range conf.Peers {
 		m = make(map[string]bool, len(peer.AllowedIPs))
 		i = 0
@@ -307,6 +439,10 @@ func (conf *Config) DeduplicateNetworkEntries() {
 
 func (conf *Config) Redact() {
 	conf.Interface.PrivateKey = Key{}
+	if len(conf.Interface.Proxy.Username) > 0 {
+		// A placeholder rather than nothing, as credentials without a password do not parse.
+		conf.Interface.Proxy.Password = "redacted"
+	}
 	for i := range conf.Peers {
 		conf.Peers[i].PublicKey = Key{}
 		conf.Peers[i].PresharedKey = Key{}
@@ -0,0 +1,64 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"testing"
+)
+
+func TestIntersectsWith(t *testing.T) {
+	config := func(address string, allowedIPs ...string) *Config {
+		conf := &Config{Name: "test"}
+		a, err := parseIPCidr(address)
+		if !noError(t, err) {
+			t.FailNow()
+		}
+		conf.Interface.Addresses = []IPCidr{*a}
+		peer := Peer{}
+		for _, s := range allowedIPs {
+			a, err := parseIPCidr(s)
+			if !noError(t, err) {
+				t.FailNow()
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, *a)
+		}
+		conf.Peers = []Peer{peer}
+		return conf
+	}
+	tests := []struct {
+		name string
+		a, b *Config
+		want bool
+	}{
+		{"disjoint", config("10.0.0.2/24", "10.0.0.0/24"), config("10.1.0.2/24", "10.1.0.0/24"), false},
+		{"same address", config("10.0.0.2/32", "192.168.0.0/24"), config("10.0.0.2/32", "192.168.1.0/24"), true},
+		{"same address network", config("10.0.0.2/24"), config("10.0.0.3/24"), true},
+		{"address in allowed IPs", config("10.0.0.2/24", "10.1.0.0/24"), config("10.1.0.2/24", "10.2.0.0/24"), true},
+		{"same allowed IPs", config("10.0.0.2/32", "0.0.0.0/0"), config("10.1.0.2/32", "0.0.0.0/0"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			equal(t, tt.want, tt.a.IntersectsWith(tt.b))
+			equal(t, tt.want, tt.b.IntersectsWith(tt.a))
+		})
+	}
+}
+
+func TestIntersectsWithLeavesConfigsAlone(t *testing.T) {
+	a, err := FromWgQuick(testInput, "a")
+	if !noError(t, err) {
+		return
+	}
+	b, err := FromWgQuick(testInput, "b")
+	if !noError(t, err) {
+		return
+	}
+	before := a.ToWgQuick()
+	a.IntersectsWith(b)
+	b.IntersectsWith(a)
+	equal(t, before, a.ToWgQuick())
+	equal(t, before, b.ToWgQuick())
+}
@@ -0,0 +1,103 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+// RouteCapture describes whether the allowed IPs of a configuration cover the
+// whole address space of one address family, and if so, how.
+type RouteCapture int
+
+const (
+	// RouteCaptureNone means that traffic not matching an allowed IP still
+	// leaves through the physical default route.
+	RouteCaptureNone RouteCapture = iota
+	// RouteCaptureDefault means that a peer has 0.0.0.0/0 or ::/0.
+	RouteCaptureDefault
+	// RouteCaptureSplit means that the peers have both halves of the address
+	// space, 0.0.0.0/1 and 128.0.0.0/1 or ::/1 and 8000::/1, which override the
+	// default route without replacing it.
+	RouteCaptureSplit
+)
+
+func (c RouteCapture) Captured() bool {
+	return c != RouteCaptureNone
+}
+
+func (c RouteCapture) String() string {
+	switch c {
+	case RouteCaptureDefault:
+		return "default"
+	case RouteCaptureSplit:
+		return "split"
+	default:
+		return "none"
+	}
+}
+
+// DefaultRouteCapture is the outcome of analyzing a configuration's allowed
+// IPs for each address family.
+type DefaultRouteCapture struct {
+	IPv4 RouteCapture
+	IPv6 RouteCapture
+}
+
+// Any returns true if either address family's default route is captured.
+func (c DefaultRouteCapture) Any() bool {
+	return c.IPv4.Captured() || c.IPv6.Captured()
+}
+
+// DefaultRouteCapture determines, across all peers and extra routes, whether
+// the configuration captures the default route of each address family. It does not consider
+// the routing table, so that callers can decide for themselves, with RoutesPreempted, whether
+// routes that do not take precedence still matter.
+func (conf *Config) DefaultRouteCapture() DefaultRouteCapture {
+	type halves struct {
+		whole, low, high bool
+	}
+	var v4, v6 halves
+	consider := func(route IPCidr) {
+		if route.Cidr > 1 || len(route.IP) == 0 {
+			return
+		}
+		ip := route.IP
+		family := &v6
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			family = &v4
+		}
+		if route.Cidr == 0 {
+			family.whole = true
+		} else if ip[0]&0x80 == 0 {
+			family.low = true
+		} else {
+			family.high = true
+		}
+	}
+	for _, route := range conf.Interface.Routes {
+		consider(route)
+	}
+	for i := range conf.Peers {
+		for _, allowedip := range conf.Peers[i].AllowedIPs {
+			consider(allowedip)
+		}
+	}
+	capture := func(h halves) RouteCapture {
+		if h.whole {
+			return RouteCaptureDefault
+		} else if h.low && h.high {
+			return RouteCaptureSplit
+		}
+		return RouteCaptureNone
+	}
+	return DefaultRouteCapture{capture(v4), capture(v6)}
+}
+
+// RoutesPreempted reports whether the routes of other interfaces take precedence
+// over those of the configuration, which is so with Table = off, which installs
+// none, and with a numeric Table, which installs them behind those of the main
+// table. A default route captured by such a configuration carries no traffic.
+func (conf *Config) RoutesPreempted() bool {
+	return conf.Interface.TableOff || conf.Interface.Table != 0
+}
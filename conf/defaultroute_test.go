@@ -0,0 +1,61 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"testing"
+)
+
+func TestDefaultRouteCapture(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowedIPs [][]string
+		want       DefaultRouteCapture
+	}{
+		{"no peers", nil, DefaultRouteCapture{RouteCaptureNone, RouteCaptureNone}},
+		{"split tunnel", [][]string{{"10.0.0.0/8", "fd00::/8"}}, DefaultRouteCapture{RouteCaptureNone, RouteCaptureNone}},
+		{"ipv4 default", [][]string{{"0.0.0.0/0"}}, DefaultRouteCapture{RouteCaptureDefault, RouteCaptureNone}},
+		{"ipv6 default", [][]string{{"::/0"}}, DefaultRouteCapture{RouteCaptureNone, RouteCaptureDefault}},
+		{"both defaults", [][]string{{"0.0.0.0/0", "::/0"}}, DefaultRouteCapture{RouteCaptureDefault, RouteCaptureDefault}},
+		{"ipv4 halves", [][]string{{"0.0.0.0/1", "128.0.0.0/1"}}, DefaultRouteCapture{RouteCaptureSplit, RouteCaptureNone}},
+		{"ipv6 halves", [][]string{{"::/1", "8000::/1"}}, DefaultRouteCapture{RouteCaptureNone, RouteCaptureSplit}},
+		{"ipv4 lower half only", [][]string{{"0.0.0.0/1"}}, DefaultRouteCapture{RouteCaptureNone, RouteCaptureNone}},
+		{"halves across peers", [][]string{{"0.0.0.0/1", "::/1"}, {"128.0.0.0/1", "8000::/1"}}, DefaultRouteCapture{RouteCaptureSplit, RouteCaptureSplit}},
+		{"default on second peer", [][]string{{"10.0.0.0/8"}, {"0.0.0.0/0"}}, DefaultRouteCapture{RouteCaptureDefault, RouteCaptureNone}},
+		{"default wins over halves", [][]string{{"0.0.0.0/1", "128.0.0.0/1", "0.0.0.0/0"}}, DefaultRouteCapture{RouteCaptureDefault, RouteCaptureNone}},
+		{"unmasked default", [][]string{{"1.2.3.4/0"}}, DefaultRouteCapture{RouteCaptureDefault, RouteCaptureNone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{Name: "test"}
+			for _, allowedIPs := range tt.allowedIPs {
+				peer := Peer{}
+				for _, s := range allowedIPs {
+					a, err := parseIPCidr(s)
+					if !noError(t, err) {
+						return
+					}
+					peer.AllowedIPs = append(peer.AllowedIPs, *a)
+				}
+				conf.Peers = append(conf.Peers, peer)
+			}
+			equal(t, tt.want, conf.DefaultRouteCapture())
+			equal(t, tt.want.IPv4.Captured() || tt.want.IPv6.Captured(), conf.DefaultRouteCapture().Any())
+		})
+	}
+}
+
+func TestDefaultRouteCaptureExtraRoutes(t *testing.T) {
+	conf := &Config{Name: "test"}
+	for _, s := range []string{"0.0.0.0/1", "128.0.0.0/1", "::/0"} {
+		a, err := parseIPCidr(s)
+		if !noError(t, err) {
+			return
+		}
+		conf.Interface.Routes = append(conf.Interface.Routes, *a)
+	}
+	equal(t, DefaultRouteCapture{RouteCaptureSplit, RouteCaptureDefault}, conf.DefaultRouteCapture())
+}
@@ -18,6 +18,10 @@ import (
 
 //sys	internetGetConnectedState(flags *uint32, reserved uint32) (connected bool) = wininet.InternetGetConnectedState
 
+// ResolveAttempted, if not nil, is told of every attempt at resolving the hostname of an endpoint, which began
+// at start, so that the tunnel service can trace how long resolving took.
+var ResolveAttempted func(name string, start time.Time, err error)
+
 func resolveHostname(name string) (resolvedIPString string, err error) {
 	maxTries := 10
 	systemJustBooted := windows.DurationSinceBoot() <= time.Minute*4
@@ -28,7 +32,11 @@ func resolveHostname(name string) (resolvedIPString string, err error) {
 		if i > 0 {
 			time.Sleep(time.Second * 4)
 		}
+		start := time.Now()
 		resolvedIPString, err = resolveHostnameOnce(name)
+		if ResolveAttempted != nil {
+			ResolveAttempted(name, start, err)
+		}
 		if err == nil {
 			return
 		}
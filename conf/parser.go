@@ -13,6 +13,8 @@ import (
 	"io"
 	"math"
 	"net"
+	"net/netip"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -83,6 +85,7 @@ func parseIPCidr(s string) (ipcidr *IPCidr, err error) {
 			return
 		}
 		if cidr > 32 && maybeV4 != nil {
+			err = &ParseError{l18n.Sprintf("Invalid network prefix length"), s}
 			return
 		}
 	} else {
@@ -139,6 +142,134 @@ func parseMTU(s string) (uint16, error) {
 	return uint16(m), nil
 }
 
+func parseMetric(s string, min uint32) (uint32, error) {
+	m, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if m < int(min) || m > 9999 {
+		return 0, &ParseError{l18n.Sprintf("Invalid metric"), s}
+	}
+	return uint32(m), nil
+}
+
+// maxWatchdogStages is the number of escalation steps of the handshake watchdog.
+const maxWatchdogStages = 4
+
+func parseHandshakeWatchdog(s string) ([]time.Duration, error) {
+	if s == "off" {
+		return nil, nil
+	}
+	stages, err := splitList(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(stages) > maxWatchdogStages {
+		return nil, &ParseError{l18n.Sprintf("Too many handshake watchdog stages"), s}
+	}
+	thresholds := make([]time.Duration, 0, len(stages))
+	for _, stage := range stages {
+		seconds, err := strconv.ParseUint(stage, 10, 16)
+		if err != nil || seconds == 0 {
+			return nil, &ParseError{l18n.Sprintf("Invalid handshake watchdog threshold"), stage}
+		}
+		threshold := time.Duration(seconds) * time.Second
+		if len(thresholds) > 0 && threshold <= thresholds[len(thresholds)-1] {
+			return nil, &ParseError{l18n.Sprintf("Handshake watchdog thresholds must be ascending"), s}
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+func parseLogRedaction(s string) (LogRedaction, error) {
+	for _, r := range []LogRedaction{LogRedactSecrets, LogRedactAddresses, LogRedactHostnames} {
+		if strings.EqualFold(s, r.String()) {
+			return r, nil
+		}
+	}
+	return 0, &ParseError{l18n.Sprintf("Invalid log redaction"), s}
+}
+
+func parseLogRedactPattern(s string) (string, error) {
+	if _, err := regexp.Compile(s); err != nil || len(s) == 0 {
+		return "", &ParseError{l18n.Sprintf("Invalid log redaction pattern"), s}
+	}
+	return s, nil
+}
+
+// ParseLogLevel parses the name of a log level, as in the LogLevel key.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for _, level := range []LogLevel{LogLevelVerbose, LogLevelInfo, LogLevelError} {
+		if strings.EqualFold(s, level.String()) {
+			return level, nil
+		}
+	}
+	return LogLevelDefault, &ParseError{l18n.Sprintf("Invalid log level"), s}
+}
+
+// parseProxy parses [username:password@]address:port, where the password may contain '@' but the username
+// may not contain ':'.
+func parseProxy(s string) (*Proxy, error) {
+	proxy := &Proxy{}
+	address := s
+	if at := strings.LastIndexByte(s, '@'); at >= 0 {
+		credentials := s[:at]
+		address = s[at+1:]
+		colon := strings.IndexByte(credentials, ':')
+		if colon <= 0 || colon == len(credentials)-1 {
+			return nil, &ParseError{l18n.Sprintf("Proxy credentials must be a username and password"), address}
+		}
+		proxy.Username, proxy.Password = credentials[:colon], credentials[colon+1:]
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || addrPort.Port() == 0 {
+		return nil, &ParseError{l18n.Sprintf("Invalid proxy address"), address}
+	}
+	if !addrPort.Addr().IsLoopback() {
+		return nil, &ParseError{l18n.Sprintf("Proxy address must be a loopback address"), address}
+	}
+	proxy.Address = netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+	return proxy, nil
+}
+
+// parseMetrics parses "pipe" or a loopback address:port.
+func parseMetrics(s string) (*Metrics, error) {
+	if strings.EqualFold(s, "pipe") {
+		return &Metrics{Pipe: true}, nil
+	}
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil || addrPort.Port() == 0 {
+		return nil, &ParseError{l18n.Sprintf("Invalid metrics address"), s}
+	}
+	if !addrPort.Addr().IsLoopback() {
+		return nil, &ParseError{l18n.Sprintf("Metrics address must be a loopback address"), s}
+	}
+	return &Metrics{Address: netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())}, nil
+}
+
+func parseJunkPackets(s string) (*JunkPackets, error) {
+	values, err := splitList(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 3 {
+		return nil, &ParseError{l18n.Sprintf("Junk packets must be count, minimum size and maximum size"), s}
+	}
+	var numbers [3]uint16
+	for i, value := range values {
+		numbers[i], err = parseUint16(value, "junk packet parameter")
+		if err != nil {
+			return nil, err
+		}
+	}
+	junk := &JunkPackets{numbers[0], numbers[1], numbers[2]}
+	if junk.Count == 0 || junk.MinSize > junk.MaxSize {
+		return nil, &ParseError{l18n.Sprintf("Invalid junk packets"), s}
+	}
+	return junk, nil
+}
+
 func parsePort(s string) (uint16, error) {
 	m, err := strconv.Atoi(s)
 	if err != nil {
@@ -178,14 +309,44 @@ func parsePersistentKeepalive(s string) (string, error) {
 	return s, nil
 }
 
-func parseTableOff(s string) (bool, error) {
+func parseTable(s string) (off bool, table uint32, err error) {
 	if s == "off" {
-		return true, nil
+		return true, 0, nil
 	} else if s == "auto" || s == "main" {
+		return false, 0, nil
+	}
+	t, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return false, 0, err
+	}
+	return false, uint32(t), nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "on", "true", "yes", "1":
+		return true, nil
+	case "off", "false", "no", "0":
 		return false, nil
 	}
-	_, err := strconv.ParseUint(s, 10, 32)
-	return false, err
+	return false, &ParseError{l18n.Sprintf("Invalid boolean value"), s}
+}
+
+func parseDNSDomain(s string) (string, error) {
+	domain := strings.TrimPrefix(s, "~")
+	if domain == "." {
+		return domain, nil
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	if len(domain) == 0 || len(domain) > 253 {
+		return "", &ParseError{l18n.Sprintf("Invalid DNS routing domain"), s}
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 || strings.Trim(label, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+			return "", &ParseError{l18n.Sprintf("Invalid DNS routing domain"), s}
+		}
+	}
+	return strings.ToLower(domain), nil
 }
 
 func parseKeyBase64(s string) (*Key, error) {
@@ -409,6 +570,24 @@ func FromWgQuick(s string, name string) (*Config, error) {
 					return nil, err
 				}
 				conf.Interface.MTU = m
+			case "handshakewatchdog":
+				thresholds, err := parseHandshakeWatchdog(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.HandshakeWatchdog = thresholds
+			case "alternatejunkpackets":
+				junk, err := parseJunkPackets(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.AlternateJunkPackets = append(conf.Interface.AlternateJunkPackets, *junk)
+			case "interfacemetric":
+				m, err := parseMetric(val, 1)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.InterfaceMetric = m
 			case "address":
 				addresses, err := splitList(val)
 				if err != nil {
@@ -434,6 +613,18 @@ func FromWgQuick(s string, name string) (*Config, error) {
 						conf.Interface.DNS = append(conf.Interface.DNS, a)
 					}
 				}
+			case "dnsdomains":
+				domains, err := splitList(val)
+				if err != nil {
+					return nil, err
+				}
+				for _, domain := range domains {
+					d, err := parseDNSDomain(domain)
+					if err != nil {
+						return nil, err
+					}
+					conf.Interface.DNSDomains = append(conf.Interface.DNSDomains, d)
+				}
 			case "preup":
 				conf.Interface.PreUp = val
 			case "postup":
@@ -443,11 +634,60 @@ func FromWgQuick(s string, name string) (*Config, error) {
 			case "postdown":
 				conf.Interface.PostDown = val
 			case "table":
-				tableOff, err := parseTableOff(val)
+				tableOff, table, err := parseTable(val)
 				if err != nil {
 					return nil, err
 				}
 				conf.Interface.TableOff = tableOff
+				conf.Interface.Table = table
+			case "routes":
+				routes, err := splitList(val)
+				if err != nil {
+					return nil, err
+				}
+				for _, route := range routes {
+					r, err := parseIPCidr(route)
+					if err != nil {
+						return nil, err
+					}
+					conf.Interface.Routes = append(conf.Interface.Routes, *r)
+				}
+			case "blockdnsleaks":
+				blockDNSLeaks, err := parseBool(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.BlockDNSLeaks = blockDNSLeaks
+			case "proxy":
+				proxy, err := parseProxy(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.Proxy = *proxy
+			case "metrics":
+				metrics, err := parseMetrics(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.Metrics = *metrics
+			case "logredaction":
+				redaction, err := parseLogRedaction(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.LogRedaction = redaction
+			case "logredactpattern":
+				pattern, err := parseLogRedactPattern(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.LogRedactPatterns = append(conf.Interface.LogRedactPatterns, pattern)
+			case "loglevel":
+				level, err := ParseLogLevel(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.LogLevel = level
 			default:
 				return nil, &ParseError{l18n.Sprintf("Invalid key for [Interface] section"), key}
 			}
@@ -489,6 +729,12 @@ func FromWgQuick(s string, name string) (*Config, error) {
 					return nil, err
 				}
 				peer.Endpoint = *e
+			case "routemetric":
+				m, err := parseMetric(val, 0)
+				if err != nil {
+					return nil, err
+				}
+				peer.RouteMetric = m
 			default:
 				return nil, &ParseError{l18n.Sprintf("Invalid key for [Peer] section"), key}
 			}
@@ -499,6 +745,9 @@ func FromWgQuick(s string, name string) (*Config, error) {
 	if !sawPrivateKey {
 		return nil, &ParseError{l18n.Sprintf("An interface must have a private key"), l18n.Sprintf("[none specified]")}
 	}
+	if len(conf.Interface.DNSDomains) > 0 && len(conf.Interface.DNS) == 0 {
+		return nil, &ParseError{l18n.Sprintf("DNS routing domains require DNS servers"), l18n.Sprintf("[none specified]")}
+	}
 	for _, p := range conf.Peers {
 		if p.PublicKey.IsZero() {
 			return nil, &ParseError{l18n.Sprintf("All peers must have public keys"), l18n.Sprintf("[none specified]")}
@@ -533,12 +782,24 @@ func FromUAPI(reader io.Reader, existingConfig *Config) (*Config, error) {
 			Addresses:                  existingConfig.Interface.Addresses,
 			DNS:                        existingConfig.Interface.DNS,
 			DNSSearch:                  existingConfig.Interface.DNSSearch,
+			DNSDomains:                 existingConfig.Interface.DNSDomains,
 			MTU:                        existingConfig.Interface.MTU,
 			PreUp:                      existingConfig.Interface.PreUp,
 			PostUp:                     existingConfig.Interface.PostUp,
 			PreDown:                    existingConfig.Interface.PreDown,
 			PostDown:                   existingConfig.Interface.PostDown,
 			TableOff:                   existingConfig.Interface.TableOff,
+			Table:                      existingConfig.Interface.Table,
+			Routes:                     existingConfig.Interface.Routes,
+			BlockDNSLeaks:              existingConfig.Interface.BlockDNSLeaks,
+			InterfaceMetric:            existingConfig.Interface.InterfaceMetric,
+			HandshakeWatchdog:          existingConfig.Interface.HandshakeWatchdog,
+			AlternateJunkPackets:       existingConfig.Interface.AlternateJunkPackets,
+			Proxy:                      existingConfig.Interface.Proxy,
+			Metrics:                    existingConfig.Interface.Metrics,
+			LogRedaction:               existingConfig.Interface.LogRedaction,
+			LogRedactPatterns:          existingConfig.Interface.LogRedactPatterns,
+			LogLevel:                   existingConfig.Interface.LogLevel,
 			JunkPacketCount:            existingConfig.Interface.JunkPacketCount,
 			JunkPacketMinSize:          existingConfig.Interface.JunkPacketMinSize,
 			JunkPacketMaxSize:          existingConfig.Interface.JunkPacketMaxSize,
@@ -772,5 +1033,14 @@ func FromUAPI(reader io.Reader, existingConfig *Config) (*Config, error) {
 	}
 	conf.maybeAddPeer(peer)
 
+	for i := range conf.Peers {
+		for j := range existingConfig.Peers {
+			if conf.Peers[i].PublicKey == existingConfig.Peers[j].PublicKey {
+				conf.Peers[i].RouteMetric = existingConfig.Peers[j].RouteMetric
+				break
+			}
+		}
+	}
+
 	return &conf, nil
 }
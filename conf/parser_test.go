@@ -7,9 +7,12 @@ package conf
 
 import (
 	"net"
+	"net/netip"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 const testInput = `
@@ -128,3 +131,241 @@ func TestParseEndpoint(t *testing.T) {
 		t.Error("Error was expected")
 	}
 }
+
+func TestFromWgQuickBlockDNSLeaks(t *testing.T) {
+	conf, err := FromWgQuick(testInput, "test")
+	if noError(t, err) {
+		equal(t, false, conf.Interface.BlockDNSLeaks)
+	}
+	conf, err = FromWgQuick(testInput[:strings.Index(testInput, "[Peer]")]+"BlockDNSLeaks = on\n", "test")
+	if noError(t, err) {
+		equal(t, true, conf.Interface.BlockDNSLeaks)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "BlockDNSLeaks = on\n"))
+	}
+	_, err = FromWgQuick(testInput[:strings.Index(testInput, "[Peer]")]+"BlockDNSLeaks = maybe\n", "test")
+	if err == nil {
+		t.Error("Error was expected")
+	}
+}
+
+func TestFromWgQuickDNSDomains(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	conf, err := FromWgQuick(iface+"DNS = 10.64.0.1\nDNSDomains = ~corp.example, Lab.Example., ~.\n", "test")
+	if noError(t, err) {
+		equal(t, []string{"corp.example", "lab.example", "."}, conf.Interface.DNSDomains)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "DNSDomains = ~corp.example, ~lab.example, ~.\n"))
+	}
+	_, err = FromWgQuick(iface+"DNSDomains = ~corp.example\n", "test")
+	if err == nil {
+		t.Error("Error was expected for routing domains without DNS servers")
+	}
+	for _, invalid := range []string{"~", "corp..example", "corp example", "~corp/example"} {
+		_, err = FromWgQuick(iface+"DNS = 10.64.0.1\nDNSDomains = "+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromWgQuickMetrics(t *testing.T) {
+	conf, err := FromWgQuick(strings.Replace(testInput, "[Peer]", "InterfaceMetric = 5\n\n[Peer]\nRouteMetric = 0", 1)+"\nRouteMetric = 25\n", "test")
+	if noError(t, err) {
+		equal(t, uint32(5), conf.Interface.InterfaceMetric)
+		lenTest(t, conf.Peers, 3)
+		equal(t, uint32(0), conf.Peers[0].RouteMetric)
+		equal(t, uint32(25), conf.Peers[2].RouteMetric)
+		output := conf.ToWgQuick()
+		equal(t, true, strings.Contains(output, "InterfaceMetric = 5\n"))
+		equal(t, 1, strings.Count(output, "RouteMetric = 25\n"))
+	}
+	for _, invalid := range []string{"InterfaceMetric = 0", "InterfaceMetric = 10000", "InterfaceMetric = -1", "InterfaceMetric = x"} {
+		_, err = FromWgQuick(strings.Replace(testInput, "[Peer]", invalid+"\n\n[Peer]", 1), "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+	for _, invalid := range []string{"RouteMetric = 10000", "RouteMetric = -1"} {
+		_, err = FromWgQuick(testInput+"\n"+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromWgQuickTable(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	for _, tt := range []struct {
+		table    string
+		tableOff bool
+		number   uint32
+		output   string
+	}{
+		{"off", true, 0, "Table = off\n"},
+		{"auto", false, 0, ""},
+		{"main", false, 0, ""},
+		{"51820", false, 51820, "Table = 51820\n"},
+	} {
+		conf, err := FromWgQuick(iface+"Table = "+tt.table+"\n", "test")
+		if noError(t, err) {
+			equal(t, tt.tableOff, conf.Interface.TableOff)
+			equal(t, tt.number, conf.Interface.Table)
+			if len(tt.output) > 0 {
+				equal(t, true, strings.Contains(conf.ToWgQuick(), tt.output))
+			} else {
+				equal(t, false, strings.Contains(conf.ToWgQuick(), "Table = "))
+			}
+		}
+	}
+	for _, invalid := range []string{"-1", "4294967296", "table"} {
+		_, err := FromWgQuick(iface+"Table = "+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromWgQuickRoutes(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	conf, err := FromWgQuick(iface+"Routes = 10.0.0.0/8, fd00::/8\nRoutes = 192.168.5.0/24, 10.0.0.0/8\n", "test")
+	if noError(t, err) {
+		conf.DeduplicateNetworkEntries()
+		lenTest(t, conf.Interface.Routes, 3)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "Routes = 10.0.0.0/8, fd00::/8, 192.168.5.0/24\n"))
+	}
+	_, err = FromWgQuick(iface+"Routes = 10.0.0.0/33\n", "test")
+	if err == nil {
+		t.Error("Error was expected for an invalid route")
+	}
+}
+
+func TestFromWgQuickMetricsListener(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	conf, err := FromWgQuick(iface+"Metrics = 127.0.0.1:9586\n", "test")
+	if noError(t, err) {
+		equal(t, netip.MustParseAddrPort("127.0.0.1:9586"), conf.Interface.Metrics.Address)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "Metrics = 127.0.0.1:9586\n"))
+	}
+	conf, err = FromWgQuick(iface+"Metrics = Pipe\n", "test")
+	if noError(t, err) {
+		equal(t, true, conf.Interface.Metrics.Pipe)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "Metrics = pipe\n"))
+	}
+	for _, invalid := range []string{"Metrics = 192.0.2.1:9586", "Metrics = 127.0.0.1", "Metrics = [::1]:0", "Metrics = on"} {
+		_, err = FromWgQuick(iface+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromWgQuickLogRedaction(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	conf, err := FromWgQuick(iface, "test")
+	if noError(t, err) {
+		equal(t, LogRedactSecrets, conf.Interface.LogRedaction)
+		equal(t, false, strings.Contains(conf.ToWgQuick(), "LogRedaction"))
+	}
+	conf, err = FromWgQuick(iface+"LogRedaction = Addresses\nLogRedactPattern = office-\\d+\nLogRedactPattern = a,b\n", "test")
+	if noError(t, err) {
+		equal(t, LogRedactAddresses, conf.Interface.LogRedaction)
+		equal(t, []string{`office-\d+`, "a,b"}, conf.Interface.LogRedactPatterns)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "LogRedaction = addresses\nLogRedactPattern = office-\\d+\nLogRedactPattern = a,b\n"))
+	}
+	for _, invalid := range []string{"LogRedaction = everything", "LogRedactPattern = (", "LogRedactPattern ="} {
+		_, err = FromWgQuick(iface+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromWgQuickLogLevel(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	conf, err := FromWgQuick(iface, "test")
+	if noError(t, err) {
+		equal(t, LogLevelDefault, conf.Interface.LogLevel)
+		equal(t, false, strings.Contains(conf.ToWgQuick(), "LogLevel"))
+	}
+	conf, err = FromWgQuick(iface+"LogLevel = Verbose\n", "test")
+	if noError(t, err) {
+		equal(t, LogLevelVerbose, conf.Interface.LogLevel)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "LogLevel = verbose\n"))
+	}
+	for _, invalid := range []string{"LogLevel = default", "LogLevel = debug", "LogLevel ="} {
+		_, err = FromWgQuick(iface+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromWgQuickHandshakeWatchdog(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	conf, err := FromWgQuick(iface+"HandshakeWatchdog = 120, 240, 360, 600\nAlternateJunkPackets = 4, 40, 70\nAlternateJunkPackets = 8,100,500\n", "test")
+	if noError(t, err) {
+		equal(t, []time.Duration{2 * time.Minute, 4 * time.Minute, 6 * time.Minute, 10 * time.Minute}, conf.Interface.HandshakeWatchdog)
+		equal(t, []JunkPackets{{4, 40, 70}, {8, 100, 500}}, conf.Interface.AlternateJunkPackets)
+		output := conf.ToWgQuick()
+		equal(t, true, strings.Contains(output, "HandshakeWatchdog = 120, 240, 360, 600\nAlternateJunkPackets = 4, 40, 70\nAlternateJunkPackets = 8, 100, 500\n"))
+		equal(t, "jc=8\njmin=100\njmax=500\n", conf.Interface.AlternateJunkPackets[1].ToUAPI())
+	}
+	conf, err = FromWgQuick(iface+"HandshakeWatchdog = off\n", "test")
+	if noError(t, err) {
+		lenTest(t, conf.Interface.HandshakeWatchdog, 0)
+	}
+	for _, invalid := range []string{
+		"HandshakeWatchdog = 120, 60",
+		"HandshakeWatchdog = 0",
+		"HandshakeWatchdog = 1, 2, 3, 4, 5",
+		"HandshakeWatchdog = 70000",
+		"AlternateJunkPackets = 4, 40",
+		"AlternateJunkPackets = 0, 40, 70",
+		"AlternateJunkPackets = 4, 70, 40",
+	} {
+		_, err = FromWgQuick(iface+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromWgQuickProxy(t *testing.T) {
+	iface := testInput[:strings.Index(testInput, "[Peer]")]
+	conf, err := FromWgQuick(iface+"Proxy = 127.0.0.1:1080\n", "test")
+	if noError(t, err) {
+		equal(t, netip.MustParseAddrPort("127.0.0.1:1080"), conf.Interface.Proxy.Address)
+		equal(t, "", conf.Interface.Proxy.Username)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "Proxy = 127.0.0.1:1080\n"))
+	}
+	conf, err = FromWgQuick(iface+"Proxy = alice:s3cr:et@w@[::1]:8080\n", "test")
+	if noError(t, err) {
+		equal(t, netip.MustParseAddrPort("[::1]:8080"), conf.Interface.Proxy.Address)
+		equal(t, "alice", conf.Interface.Proxy.Username)
+		equal(t, "s3cr:et@w", conf.Interface.Proxy.Password)
+		equal(t, true, strings.Contains(conf.ToWgQuick(), "Proxy = alice:s3cr:et@w@[::1]:8080\n"))
+		conf.Redact()
+		equal(t, "redacted", conf.Interface.Proxy.Password)
+		_, err = parseProxy(conf.Interface.Proxy.String())
+		noError(t, err)
+	}
+	conf, err = FromWgQuick(iface, "test")
+	if noError(t, err) {
+		equal(t, true, conf.Interface.Proxy.IsEmpty())
+		equal(t, false, strings.Contains(conf.ToWgQuick(), "Proxy"))
+	}
+	for _, invalid := range []string{
+		"Proxy = 192.168.1.2:1080",
+		"Proxy = 0.0.0.0:1080",
+		"Proxy = 127.0.0.1",
+		"Proxy = 127.0.0.1:0",
+		"Proxy = localhost:1080",
+		"Proxy = alice@127.0.0.1:1080",
+		"Proxy = :secret@127.0.0.1:1080",
+		"Proxy = alice:@127.0.0.1:1080",
+	} {
+		_, err = FromWgQuick(iface+invalid+"\n", "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
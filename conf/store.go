@@ -16,6 +16,8 @@ import (
 
 const configFileSuffix = ".conf.dpapi"
 const configFileUnencryptedSuffix = ".conf"
+const failureFileSuffix = ".failure.json"
+const startupTraceFileSuffix = ".startup.json"
 
 func ListConfigNames() ([]string, error) {
 	configFileDir, err := tunnelConfigurationsDirectory()
@@ -136,9 +138,94 @@ func DeleteName(name string) error {
 	if err != nil {
 		return err
 	}
-	return os.Remove(filepath.Join(configFileDir, name+configFileSuffix))
+	err = os.Remove(filepath.Join(configFileDir, name+configFileSuffix))
+	if err != nil {
+		return err
+	}
+	for _, suffix := range []string{failureFileSuffix, startupTraceFileSuffix} {
+		err = os.Remove(filepath.Join(configFileDir, name+suffix))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (config *Config) Delete() error {
 	return DeleteName(config.Name)
 }
+
+// statePath returns where the state of the tunnel whose configuration is at configPath, such as its last
+// failure, is kept under suffix, which is next to the configuration and skipped by ListConfigNames.
+func statePath(configPath, suffix string) (string, error) {
+	name, err := NameFromPath(configPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), name+suffix), nil
+}
+
+// saveState replaces the state of the tunnel under suffix, or removes it if state is nil. Unlike the
+// configuration, it is readable by administrators, for the UI to show.
+func saveState(configPath, suffix string, state []byte) error {
+	path, err := statePath(configPath, suffix)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	temp := path + ".tmp"
+	err = os.WriteFile(temp, state, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp, path)
+	if err != nil {
+		os.Remove(temp)
+	}
+	return err
+}
+
+func loadStateOfName(name, suffix string) ([]byte, error) {
+	if !TunnelNameIsValid(name) {
+		return nil, errors.New("Tunnel name is not valid")
+	}
+	configFileDir, err := tunnelConfigurationsDirectory()
+	if err != nil {
+		return nil, err
+	}
+	state, err := os.ReadFile(filepath.Join(configFileDir, name+suffix))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return state, err
+}
+
+// SaveFailure keeps the serialized last failure of the tunnel whose configuration is at configPath, or removes
+// it if failure is nil.
+func SaveFailure(configPath string, failure []byte) error {
+	return saveState(configPath, failureFileSuffix, failure)
+}
+
+// LoadFailureOfName returns the serialized last failure of the tunnel, or nil if it has not failed since it last
+// stopped cleanly.
+func LoadFailureOfName(name string) ([]byte, error) {
+	return loadStateOfName(name, failureFileSuffix)
+}
+
+// SaveStartupTrace keeps the serialized trace of the latest startup of the tunnel whose configuration is at
+// configPath.
+func SaveStartupTrace(configPath string, trace []byte) error {
+	return saveState(configPath, startupTraceFileSuffix, trace)
+}
+
+// LoadStartupTraceOfName returns the serialized trace of the latest startup of the tunnel, or nil if it has
+// never started.
+func LoadStartupTraceOfName(name string) ([]byte, error) {
+	return loadStateOfName(name, startupTraceFileSuffix)
+}
@@ -69,11 +69,29 @@ func TestStorage(t *testing.T) {
 		t.Error("Second loaded config is not the same as second saved config")
 	}
 
+	path, err := c.Path()
+	if err != nil {
+		t.Errorf("Unable to determine path of config: %s", err.Error())
+	}
+	err = SaveFailure(path, []byte(`{"id":"create-wintun"}`))
+	if err != nil {
+		t.Errorf("Unable to save failure: %s", err.Error())
+	}
+	failure, err := LoadFailureOfName("golangTest")
+	if err != nil || string(failure) != `{"id":"create-wintun"}` {
+		t.Errorf("Loaded failure %q, %v", failure, err)
+	}
+
 	err = DeleteName("golangTest")
 	if err != nil {
 		t.Errorf("Unable to delete config: %s", err.Error())
 	}
 
+	failure, err = LoadFailureOfName("golangTest")
+	if err != nil || failure != nil {
+		t.Errorf("Failure of deleted config is %q, %v", failure, err)
+	}
+
 	configs, err = ListConfigNames()
 	if err != nil {
 		t.Errorf("Unable to list configs: %s", err.Error())
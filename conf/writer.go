@@ -7,7 +7,9 @@ package conf
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // boolToUAPI converts awg-quick on/off (and 0/1/true/false) to UAPI 1/0.
@@ -134,10 +136,33 @@ func (conf *Config) ToWgQuick() string {
 		output.WriteString(fmt.Sprintf("DNS = %s\n", strings.Join(addrStrings[:], ", ")))
 	}
 
+	if len(conf.Interface.DNSDomains) > 0 {
+		domainStrings := make([]string, len(conf.Interface.DNSDomains))
+		for i, domain := range conf.Interface.DNSDomains {
+			domainStrings[i] = "~" + domain
+		}
+		output.WriteString(fmt.Sprintf("DNSDomains = %s\n", strings.Join(domainStrings, ", ")))
+	}
+
 	if conf.Interface.MTU > 0 {
 		output.WriteString(fmt.Sprintf("MTU = %d\n", conf.Interface.MTU))
 	}
 
+	if conf.Interface.InterfaceMetric > 0 {
+		output.WriteString(fmt.Sprintf("InterfaceMetric = %d\n", conf.Interface.InterfaceMetric))
+	}
+
+	if len(conf.Interface.HandshakeWatchdog) > 0 {
+		thresholdStrings := make([]string, len(conf.Interface.HandshakeWatchdog))
+		for i, threshold := range conf.Interface.HandshakeWatchdog {
+			thresholdStrings[i] = strconv.FormatInt(int64(threshold/time.Second), 10)
+		}
+		output.WriteString(fmt.Sprintf("HandshakeWatchdog = %s\n", strings.Join(thresholdStrings, ", ")))
+	}
+	for _, junk := range conf.Interface.AlternateJunkPackets {
+		output.WriteString(fmt.Sprintf("AlternateJunkPackets = %s\n", junk.String()))
+	}
+
 	if len(conf.Interface.PreUp) > 0 {
 		output.WriteString(fmt.Sprintf("PreUp = %s\n", conf.Interface.PreUp))
 	}
@@ -152,6 +177,33 @@ func (conf *Config) ToWgQuick() string {
 	}
 	if conf.Interface.TableOff {
 		output.WriteString("Table = off\n")
+	} else if conf.Interface.Table > 0 {
+		output.WriteString(fmt.Sprintf("Table = %d\n", conf.Interface.Table))
+	}
+	if len(conf.Interface.Routes) > 0 {
+		routeStrings := make([]string, len(conf.Interface.Routes))
+		for i, route := range conf.Interface.Routes {
+			routeStrings[i] = route.String()
+		}
+		output.WriteString(fmt.Sprintf("Routes = %s\n", strings.Join(routeStrings, ", ")))
+	}
+	if conf.Interface.BlockDNSLeaks {
+		output.WriteString("BlockDNSLeaks = on\n")
+	}
+	if !conf.Interface.Proxy.IsEmpty() {
+		output.WriteString(fmt.Sprintf("Proxy = %s\n", conf.Interface.Proxy.String()))
+	}
+	if !conf.Interface.Metrics.IsEmpty() {
+		output.WriteString(fmt.Sprintf("Metrics = %s\n", conf.Interface.Metrics.String()))
+	}
+	if conf.Interface.LogRedaction != LogRedactSecrets {
+		output.WriteString(fmt.Sprintf("LogRedaction = %s\n", conf.Interface.LogRedaction.String()))
+	}
+	for _, pattern := range conf.Interface.LogRedactPatterns {
+		output.WriteString(fmt.Sprintf("LogRedactPattern = %s\n", pattern))
+	}
+	if conf.Interface.LogLevel != LogLevelDefault {
+		output.WriteString(fmt.Sprintf("LogLevel = %s\n", conf.Interface.LogLevel.String()))
 	}
 
 	for _, peer := range conf.Peers {
@@ -178,6 +230,10 @@ func (conf *Config) ToWgQuick() string {
 		if len(peer.PersistentKeepalive) > 0 && peer.PersistentKeepalive != "0" && peer.PersistentKeepalive != "off" {
 			output.WriteString(fmt.Sprintf("PersistentKeepalive = %s\n", peer.PersistentKeepalive))
 		}
+
+		if peer.RouteMetric > 0 {
+			output.WriteString(fmt.Sprintf("RouteMetric = %d\n", peer.RouteMetric))
+		}
 	}
 	return output.String()
 }
@@ -302,3 +358,27 @@ func (conf *Config) ToUAPI() (uapi string, dnsErr error) {
 	}
 	return output.String(), nil
 }
+
+// ToUAPIEndpoints resolves the peers' endpoints again, producing a UAPI update that leaves all else untouched.
+func (conf *Config) ToUAPIEndpoints() (uapi string, dnsErr error) {
+	var output strings.Builder
+	for _, peer := range conf.Peers {
+		if peer.Endpoint.IsEmpty() {
+			continue
+		}
+		var resolvedIP string
+		resolvedIP, dnsErr = resolveHostname(peer.Endpoint.Host)
+		if dnsErr != nil {
+			return
+		}
+		resolvedEndpoint := Endpoint{resolvedIP, peer.Endpoint.Port}
+		output.WriteString(fmt.Sprintf("public_key=%s\n", peer.PublicKey.HexString()))
+		output.WriteString("update_only=true\n")
+		output.WriteString(fmt.Sprintf("endpoint=%s\n", resolvedEndpoint.String()))
+	}
+	return output.String(), nil
+}
+
+func (j JunkPackets) ToUAPI() string {
+	return fmt.Sprintf("jc=%d\njmin=%d\njmax=%d\n", j.Count, j.MinSize, j.MaxSize)
+}
@@ -0,0 +1,100 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package diagnostics builds a zip bundle of what is needed to diagnose a tunnel: the log, the redacted
+// configuration, versions, the firewall plan, the network tables and the states of the tunnel services. Each
+// file of the bundle comes from a Provider, and those that fail are listed in the bundle instead.
+package diagnostics
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Provider collects one file of a bundle.
+type Provider interface {
+	// Name is the name of the file in the bundle.
+	Name() string
+	Collect(w io.Writer) error
+}
+
+type providerFunc struct {
+	name    string
+	collect func(w io.Writer) error
+}
+
+func (provider *providerFunc) Name() string {
+	return provider.name
+}
+
+func (provider *providerFunc) Collect(w io.Writer) error {
+	return provider.collect(w)
+}
+
+// NewProvider returns a Provider of the file called name, whose contents collect writes.
+func NewProvider(name string, collect func(w io.Writer) error) Provider {
+	return &providerFunc{name, collect}
+}
+
+// errorsName is the name of the file listing the providers that failed, if any did.
+const errorsName = "errors.txt"
+
+// Write writes a zip bundle of the files of the providers to w. A provider failing does not stop the others:
+// what it collected before failing is kept, and its error is listed in errors.txt. Only failing to write the
+// bundle itself is returned.
+func Write(w io.Writer, providers []Provider) error {
+	archive := zip.NewWriter(w)
+	now := time.Now()
+	var failures bytes.Buffer
+	for _, provider := range providers {
+		var contents bytes.Buffer
+		err := provider.Collect(&contents)
+		if err != nil {
+			fmt.Fprintf(&failures, "%s: %v\n", provider.Name(), err)
+		}
+		if contents.Len() == 0 {
+			continue
+		}
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: provider.Name(), Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(contents.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	if failures.Len() > 0 {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: errorsName, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(failures.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// Create writes a bundle to the file at path, replacing it if it exists.
+func Create(path string, providers []Provider) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = Write(file, providers)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
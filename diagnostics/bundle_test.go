@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package diagnostics
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+)
+
+func readBundle(t *testing.T, path string) map[string]string {
+	t.Helper()
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(contents)
+	}
+	return files
+}
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "log.bin")
+	rl, err := ringlogger.NewRinglogger(logPath, "TUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	fmt.Fprint(rl, "[office] Starting")
+
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	_, defaultRoute, _ := net.ParseCIDR("0.0.0.0/0")
+	providers := []Provider{
+		LogProvider(func() (*ringlogger.Ringlogger, error) {
+			return ringlogger.OpenRinglogger(logPath)
+		}),
+		ConfigProvider(func() (string, error) {
+			return "[Interface]\nAddress = 10.0.0.2/24\n", nil
+		}),
+		VersionsProvider(func() (*Versions, error) {
+			return &Versions{UserAgent: "AmneziaWG/1.0", OS: "Windows 11"}, nil
+		}),
+		FirewallProvider(func() (*FirewallPlan, error) {
+			return &FirewallPlan{BlockDNSLeaks: true, DNSServers: []net.IP{net.ParseIP("10.0.0.1")}}, nil
+		}),
+		NetworkProvider(func() (*Network, error) {
+			return &Network{
+				Interfaces: []NetworkInterface{{Index: 7, Name: "office", Up: true, MTU: 1420, Addresses: []net.IPNet{{IP: net.ParseIP("10.0.0.2").To4(), Mask: network.Mask}}}},
+				Routes:     []NetworkRoute{{Destination: *defaultRoute, NextHop: net.IPv4zero, InterfaceIndex: 7, Metric: 5}},
+			}, nil
+		}),
+		ServicesProvider(func() ([]ServiceStatus, error) {
+			return nil, errors.New("Access is denied.")
+		}),
+		NewProvider("partial.txt", func(w io.Writer) error {
+			io.WriteString(w, "Before failing\n")
+			return errors.New("Failed halfway")
+		}),
+	}
+	path := filepath.Join(dir, "bundle.zip")
+	if err := Create(path, providers); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readBundle(t, path)
+	want := map[string][]string{
+		"log.txt":      {": [TUN] [office] Starting\n"},
+		"config.conf":  {"Address = 10.0.0.2/24"},
+		"version.txt":  {"User agent: AmneziaWG/1.0\n", "Wintun: not loaded\n"},
+		"firewall.txt": {"Restriction: block all traffic outside of the tunnel\n", "DNS leaks: blocked except to 10.0.0.1\n"},
+		"network.txt":  {"7      office  up     1420  10.0.0.2/24  none", "0.0.0.0/0    on-link   7          5\n"},
+		"partial.txt":  {"Before failing\n"},
+		"errors.txt":   {"services.txt: Access is denied.\n", "partial.txt: Failed halfway\n"},
+	}
+	if len(files) != len(want) {
+		t.Errorf("bundle has %d files, want %d", len(files), len(want))
+	}
+	for name, parts := range want {
+		contents, ok := files[name]
+		if !ok {
+			t.Errorf("bundle is missing %s", name)
+			continue
+		}
+		for _, part := range parts {
+			if !strings.Contains(contents, part) {
+				t.Errorf("%s =\n%s\nwant it to contain %q", name, contents, part)
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package diagnostics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+)
+
+// LogProvider provides log.txt, a dump of the ring that open opens, which it closes after.
+func LogProvider(open func() (*ringlogger.Ringlogger, error)) Provider {
+	return NewProvider("log.txt", func(w io.Writer) error {
+		rl, err := open()
+		if err != nil {
+			return err
+		}
+		defer rl.Close()
+		_, err = rl.WriteTo(w)
+		return err
+	})
+}
+
+// ConfigProvider provides config.conf, the configuration that config returns, which must be redacted.
+func ConfigProvider(config func() (string, error)) Provider {
+	return NewProvider("config.conf", func(w io.Writer) error {
+		text, err := config()
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, text)
+		return err
+	})
+}
+
+type Versions struct {
+	UserAgent string
+	OS        string
+	Wintun    string // empty if the driver is not loaded
+}
+
+// VersionsProvider provides version.txt.
+func VersionsProvider(versions func() (*Versions, error)) Provider {
+	return NewProvider("version.txt", func(w io.Writer) error {
+		v, err := versions()
+		if err != nil {
+			return err
+		}
+		wintun := v.Wintun
+		if len(wintun) == 0 {
+			wintun = "not loaded"
+		}
+		_, err = fmt.Fprintf(w, "User agent: %s\nOS: %s\nWintun: %s\n", v.UserAgent, v.OS, wintun)
+		return err
+	})
+}
+
+// FirewallPlan is what the firewall of a tunnel blocks, as decided by tunnel.FirewallRestrictions.
+type FirewallPlan struct {
+	DoNotRestrict bool
+	BlockDNSLeaks bool
+	DNSServers    []net.IP // the servers that DNS may reach when leaks are blocked
+}
+
+// FirewallProvider provides firewall.txt.
+func FirewallProvider(plan func() (*FirewallPlan, error)) Provider {
+	return NewProvider("firewall.txt", func(w io.Writer) error {
+		p, err := plan()
+		if err != nil {
+			return err
+		}
+		restriction := "block all traffic outside of the tunnel"
+		if p.DoNotRestrict {
+			restriction = "none"
+		}
+		dns := "not blocked"
+		if p.BlockDNSLeaks {
+			dns = "blocked except to " + joinIPs(p.DNSServers)
+		}
+		_, err = fmt.Fprintf(w, "Restriction: %s\nDNS leaks: %s\n", restriction, dns)
+		return err
+	})
+}
+
+type NetworkInterface struct {
+	Index      uint32
+	Name       string
+	Up         bool
+	MTU        uint32
+	Addresses  []net.IPNet
+	DNSServers []net.IP
+	DNSSuffix  string
+}
+
+type NetworkRoute struct {
+	Destination    net.IPNet
+	NextHop        net.IP
+	InterfaceIndex uint32
+	Metric         uint32
+}
+
+// Network is the state of the network stack of the system.
+type Network struct {
+	Interfaces []NetworkInterface
+	Routes     []NetworkRoute
+}
+
+// NetworkProvider provides network.txt, which has the tables of the interfaces with their addresses and DNS
+// servers, and of the routes.
+func NetworkProvider(network func() (*Network, error)) Provider {
+	return NewProvider("network.txt", func(w io.Writer) error {
+		n, err := network()
+		if err != nil {
+			return err
+		}
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "Index\tName\tState\tMTU\tAddresses\tDNS servers\tDNS suffix")
+		for _, iface := range n.Interfaces {
+			state := "down"
+			if iface.Up {
+				state = "up"
+			}
+			addresses := make([]string, len(iface.Addresses))
+			for i := range iface.Addresses {
+				addresses[i] = iface.Addresses[i].String()
+			}
+			fmt.Fprintf(table, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", iface.Index, iface.Name, state, iface.MTU, orNone(strings.Join(addresses, ", ")), joinIPs(iface.DNSServers), orNone(iface.DNSSuffix))
+		}
+		fmt.Fprintln(table)
+		fmt.Fprintln(table, "Destination\tNext hop\tInterface\tMetric")
+		for _, route := range n.Routes {
+			nextHop := "on-link"
+			if route.NextHop != nil && !route.NextHop.IsUnspecified() {
+				nextHop = route.NextHop.String()
+			}
+			fmt.Fprintf(table, "%s\t%s\t%d\t%d\n", route.Destination.String(), nextHop, route.InterfaceIndex, route.Metric)
+		}
+		return table.Flush()
+	})
+}
+
+// ServiceStatus is the state of the service of a tunnel, along with the error it stopped with, if any.
+type ServiceStatus struct {
+	Tunnel string
+	State  string
+	Err    error
+}
+
+// ServicesProvider provides services.txt.
+func ServicesProvider(statuses func() ([]ServiceStatus, error)) Provider {
+	return NewProvider("services.txt", func(w io.Writer) error {
+		s, err := statuses()
+		if err != nil {
+			return err
+		}
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "Tunnel\tState\tError")
+		for _, status := range s {
+			errText := "none"
+			if status.Err != nil {
+				errText = status.Err.Error()
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\n", status.Tunnel, status.State, errText)
+		}
+		return table.Flush()
+	})
+}
+
+func joinIPs(ips []net.IP) string {
+	texts := make([]string, len(ips))
+	for i := range ips {
+		texts[i] = ips[i].String()
+	}
+	return orNone(strings.Join(texts, ", "))
+}
+
+func orNone(s string) string {
+	if len(s) == 0 {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package diagnostics
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/tun"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/manager"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/winipcfg"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/version"
+)
+
+// SystemProviders returns the providers of a bundle for a tunnel from the system, with the configuration of the
+// tunnel from loadConfig, which is called anew for each file, and the log being that of the tunnel services, or
+// of the current user's if notSystem.
+func SystemProviders(loadConfig func() (*conf.Config, error), notSystem bool) []Provider {
+	return []Provider{
+		LogProvider(func() (*ringlogger.Ringlogger, error) {
+			return ringlogger.OpenLog(notSystem)
+		}),
+		ConfigProvider(func() (string, error) {
+			config, err := loadConfig()
+			if err != nil {
+				return "", err
+			}
+			config.Redact()
+			return config.ToWgQuick(), nil
+		}),
+		VersionsProvider(systemVersions),
+		FirewallProvider(func() (*FirewallPlan, error) {
+			config, err := loadConfig()
+			if err != nil {
+				return nil, err
+			}
+			plan := &FirewallPlan{DNSServers: config.Interface.DNS}
+			plan.DoNotRestrict, plan.BlockDNSLeaks = tunnel.FirewallRestrictions(config)
+			return plan, nil
+		}),
+		NetworkProvider(systemNetwork),
+		ServicesProvider(systemServices),
+	}
+}
+
+func systemVersions() (*Versions, error) {
+	versions := &Versions{UserAgent: version.UserAgent(), OS: version.OsName()}
+	// The running version is that of the driver rather than of an adapter, so no adapter is needed.
+	wintunVersion, err := (*tun.NativeTun)(nil).RunningVersion()
+	if err == nil {
+		versions.Wintun = fmt.Sprintf("%d.%d", (wintunVersion>>16)&0xffff, wintunVersion&0xffff)
+	}
+	return versions, nil
+}
+
+func systemNetwork() (*Network, error) {
+	adapters, err := winipcfg.GetAdaptersAddresses(windows.AF_UNSPEC, winipcfg.GAAFlagSkipAnycast|winipcfg.GAAFlagSkipMulticast)
+	if err != nil {
+		return nil, err
+	}
+	network := &Network{}
+	for _, adapter := range adapters {
+		iface := NetworkInterface{
+			Index:     adapter.IfIndex,
+			Name:      adapter.FriendlyName(),
+			Up:        adapter.OperStatus == winipcfg.IfOperStatusUp,
+			MTU:       adapter.MTU,
+			DNSSuffix: adapter.DNSSuffix(),
+		}
+		if iface.Index == 0 {
+			iface.Index = adapter.IPv6IfIndex
+		}
+		for address := adapter.FirstUnicastAddress; address != nil; address = address.Next {
+			ip := address.Address.IP()
+			iface.Addresses = append(iface.Addresses, net.IPNet{IP: ip, Mask: net.CIDRMask(int(address.OnLinkPrefixLength), 8*len(ip))})
+		}
+		for server := adapter.FirstDNSServerAddress; server != nil; server = server.Next {
+			iface.DNSServers = append(iface.DNSServers, server.Address.IP())
+		}
+		network.Interfaces = append(network.Interfaces, iface)
+	}
+	routes, err := winipcfg.GetIPForwardTable2(windows.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	for i := range routes {
+		network.Routes = append(network.Routes, NetworkRoute{
+			Destination:    routes[i].DestinationPrefix.IPNet(),
+			NextHop:        routes[i].NextHop.IP(),
+			InterfaceIndex: routes[i].InterfaceIndex,
+			Metric:         routes[i].Metric,
+		})
+	}
+	return network, nil
+}
+
+func systemServices() ([]ServiceStatus, error) {
+	m, err := manager.Connect(manager.Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer m.Disconnect()
+	tunnels, err := m.Tunnels()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]ServiceStatus, 0, len(tunnels))
+	for _, tunnelName := range tunnels {
+		tunnelStatus, err := m.Query(tunnelName)
+		if err != nil {
+			statuses = append(statuses, ServiceStatus{Tunnel: tunnelName, State: "unknown", Err: err})
+			continue
+		}
+		statuses = append(statuses, ServiceStatus{Tunnel: tunnelName, State: serviceStateName(tunnelStatus.State), Err: tunnelStatus.Err})
+	}
+	return statuses, nil
+}
+
+func serviceStateName(state svc.State) string {
+	switch state {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "starting"
+	case svc.StopPending:
+		return "stopping"
+	case svc.Running:
+		return "running"
+	case svc.ContinuePending:
+		return "continuing"
+	case svc.PausePending:
+		return "pausing"
+	case svc.Paused:
+		return "paused"
+	}
+	return "unknown"
+}
@@ -0,0 +1,44 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package diagnostics
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+)
+
+func TestSystemProvidersConfig(t *testing.T) {
+	key, err := conf.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadConfig := func() (*conf.Config, error) {
+		// Not in the store, as the configurations of tunnels run by the DLL are not.
+		config := &conf.Config{Name: "dll"}
+		config.Interface.PrivateKey = *key
+		config.Peers = []conf.Peer{{PublicKey: *key.Public(), AllowedIPs: []conf.IPCidr{{IP: net.IPv4zero, Cidr: 0}}}}
+		return config, nil
+	}
+	collected := make(map[string]string)
+	for _, provider := range SystemProviders(loadConfig, true) {
+		if name := provider.Name(); name == "config.conf" || name == "firewall.txt" {
+			var output strings.Builder
+			if err := provider.Collect(&output); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			collected[name] = output.String()
+		}
+	}
+	if config := collected["config.conf"]; !strings.Contains(config, "AllowedIPs = 0.0.0.0/0") || strings.Contains(config, key.String()) {
+		t.Errorf("config.conf = %q, want the redacted configuration", config)
+	}
+	if firewall := collected["firewall.txt"]; !strings.Contains(firewall, "block all traffic outside of the tunnel") {
+		t.Errorf("firewall.txt = %q", firewall)
+	}
+}
@@ -10,6 +10,9 @@ require (
 
 require (
 	github.com/amnezia-vpn/amneziawg-go/v3 v3.1.20260814
+	github.com/google/btree v1.1.3 // indirect
 	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/time v0.9.0 // indirect
 	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2 // indirect
+	gvisor.dev/gvisor v0.0.0-20231202080848-1f7806d17489 // indirect
 )
@@ -11,6 +11,10 @@ import (
 	"golang.org/x/crypto/curve25519"
 	"golang.org/x/sys/windows"
 
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/diagnostics"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/status"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel"
 
 	"crypto/rand"
 	"log"
@@ -29,6 +33,105 @@ func WireGuardTunnelService(confString16 *uint16, nameString16 *uint16) bool {
 	return err == nil
 }
 
+// WireGuardTunnelNetstack runs the tunnel without Wintun or administrator rights, exposing it as a SOCKS5 and
+// HTTP CONNECT proxy on the given loopback address until interrupted. If the address is empty, the proxy listens
+// on that of the configuration's Proxy key, or else on 127.0.0.1:1080.
+//
+//export WireGuardTunnelNetstack
+func WireGuardTunnelNetstack(confString16 *uint16, proxyString16 *uint16) bool {
+	confStr := windows.UTF16PtrToString(confString16)
+	proxyStr := windows.UTF16PtrToString(proxyString16)
+	err := tunnel.RunNetstack(confStr, proxyStr)
+	if err != nil {
+		log.Printf("Netstack run error: %v", err)
+	}
+	return err == nil
+}
+
+// WireGuardTunnelStatus writes the JSON status of the tunnel, including throughput averaged over the calls
+// made within the last ten seconds, into buffer. It returns the length of the JSON document, which is not
+// written if it exceeds bufferLen, or 0 on error.
+//
+//export WireGuardTunnelStatus
+func WireGuardTunnelStatus(nameString16 *uint16, buffer *byte, bufferLen uint32) uint32 {
+	nameStr := windows.UTF16PtrToString(nameString16)
+	s, err := status.MonitorOf(nameStr).Status()
+	if err != nil {
+		log.Printf("Unable to query tunnel status: %v", err)
+		return 0
+	}
+	j, err := s.JSON()
+	if err != nil {
+		log.Printf("Unable to serialize tunnel status: %v", err)
+		return 0
+	}
+	if uint32(len(j)) <= bufferLen && buffer != nil {
+		copy(unsafe.Slice(buffer, bufferLen), j)
+	}
+	return uint32(len(j))
+}
+
+// WireGuardSetLogLevel sets the level of the log of the running tunnel, verbose, info, or error, until it stops.
+//
+//export WireGuardSetLogLevel
+func WireGuardSetLogLevel(nameString16 *uint16, levelString16 *uint16) bool {
+	nameStr := windows.UTF16PtrToString(nameString16)
+	level, err := conf.ParseLogLevel(windows.UTF16PtrToString(levelString16))
+	if err == nil {
+		err = tunnel.SetLogLevel(nameStr, level)
+	}
+	if err != nil {
+		log.Printf("Unable to set log level: %v", err)
+	}
+	return err == nil
+}
+
+// WireGuardStartupTrace writes the trace of the latest startup of the tunnel into buffer as an OTLP trace export
+// request in JSON, for OpenTelemetry collectors. It returns the length of the JSON document, which is not written
+// if it exceeds bufferLen, or 0 on error or if the tunnel has never started.
+//
+//export WireGuardStartupTrace
+func WireGuardStartupTrace(nameString16 *uint16, buffer *byte, bufferLen uint32) uint32 {
+	nameStr := windows.UTF16PtrToString(nameString16)
+	startup, err := status.StartupTrace(nameStr)
+	if err != nil {
+		log.Printf("Unable to load startup trace: %v", err)
+		return 0
+	}
+	if startup == nil {
+		return 0
+	}
+	j, err := startup.OTLP(nameStr)
+	if err != nil {
+		log.Printf("Unable to serialize startup trace: %v", err)
+		return 0
+	}
+	if uint32(len(j)) <= bufferLen && buffer != nil {
+		copy(unsafe.Slice(buffer, bufferLen), j)
+	}
+	return uint32(len(j))
+}
+
+// WireGuardDiagnosticsBundle writes a zip bundle for diagnosing the tunnel, whose configuration is given as to
+// WireGuardTunnelService, to the file at path, with the log of the tunnel services, the redacted configuration,
+// versions, the firewall plan, the network tables and the states of the tunnel services. Parts that cannot be
+// collected are listed in the bundle rather than failing it.
+//
+//export WireGuardDiagnosticsBundle
+func WireGuardDiagnosticsBundle(confString16 *uint16, nameString16 *uint16, pathString16 *uint16) bool {
+	confStr := windows.UTF16PtrToString(confString16)
+	nameStr := windows.UTF16PtrToString(nameString16)
+	pathStr := windows.UTF16PtrToString(pathString16)
+	loadConfig := func() (*conf.Config, error) {
+		return conf.FromWgQuickWithUnknownEncoding(confStr, nameStr)
+	}
+	err := diagnostics.Create(pathStr, diagnostics.SystemProviders(loadConfig, false))
+	if err != nil {
+		log.Printf("Unable to write diagnostics bundle: %v", err)
+	}
+	return err == nil
+}
+
 //export WireGuardGenerateKeypair
 func WireGuardGenerateKeypair(publicKey *byte, privateKey *byte) {
 	publicKeyArray := (*[32]byte)(unsafe.Pointer(publicKey))
@@ -0,0 +1,358 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package manager installs, starts, stops, queries and uninstalls the services that run tunnels, which the
+// executable of the embedding application hosts by calling tunnel.Run or WireGuardTunnelService.
+package manager
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+// Dependencies are the services that tunnel services depend on, as a tunnel configures the network stack.
+var Dependencies = []string{"Nsi", "TcpIp"}
+
+// DefaultRecoveryActions restart a tunnel service that fails twice, a few seconds apart, and then leave it.
+var DefaultRecoveryActions = []mgr.RecoveryAction{
+	{Type: mgr.ServiceRestart, Delay: time.Second * 3},
+	{Type: mgr.ServiceRestart, Delay: time.Second * 10},
+	{Type: mgr.NoAction},
+}
+
+const defaultRecoveryResetPeriod = 60 * 60 * 24
+
+var (
+	ErrTunnelRunning    = errors.New("Tunnel already installed and running")
+	ErrTunnelNotStopped = errors.New("Tunnel did not stop in time")
+)
+
+// Options tell how to install tunnel services. Each field has a default used when it is the zero value.
+type Options struct {
+	// Executable is the path of the program that hosts tunnel services, by default that of the running one.
+	Executable string
+	// Arguments are the command line arguments for the service of the configuration file at configPath, by
+	// default /service and the path.
+	Arguments func(configPath string) []string
+	// DisplayName is the name shown in the services console, by default "AmneziaWG Tunnel: " and the name.
+	DisplayName func(tunnelName string) string
+	// StartType is one of mgr.StartAutomatic, mgr.StartManual and mgr.StartDisabled, by default
+	// mgr.StartAutomatic so that tunnels come back up after a reboot.
+	StartType uint32
+	// DelayedAutoStart starts automatic tunnels after the other automatic services.
+	DelayedAutoStart bool
+	// RecoveryActions are taken when a tunnel service stops without being asked to, which includes failing
+	// with a services.Error, by default DefaultRecoveryActions. NoRecovery disables them.
+	RecoveryActions []mgr.RecoveryAction
+	NoRecovery      bool
+	// RecoveryResetPeriod is how long a tunnel must run without failing for the recovery actions to start
+	// over, by default a day.
+	RecoveryResetPeriod time.Duration
+}
+
+// Manager installs and controls tunnel services through an SCM.
+type Manager struct {
+	scm     SCM
+	options Options
+
+	// pollInterval and timeout are how often and how long to wait for services to be deleted or stopped.
+	pollInterval time.Duration
+	timeout      time.Duration
+}
+
+// Connect connects to the service control manager of the local computer, which requires administrator
+// rights for most operations.
+func Connect(options Options) (*Manager, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return New(systemSCM{m}, options), nil
+}
+
+// New returns a Manager that uses scm.
+func New(scm SCM, options Options) *Manager {
+	return &Manager{scm: scm, options: options, pollInterval: time.Second / 3, timeout: time.Second * 30}
+}
+
+func (m *Manager) Disconnect() error {
+	return m.scm.Disconnect()
+}
+
+// TunnelStatus is the state of a tunnel service, along with why it stopped if it failed.
+type TunnelStatus struct {
+	Name      string
+	State     svc.State
+	ProcessID uint32
+	// Err is a services.Error if the tunnel failed with one, or a windows.Errno if the service exited with a
+	// Windows error.
+	Err error
+}
+
+func (m *Manager) serviceConfig(tunnelName string) mgr.Config {
+	config := mgr.Config{
+		ServiceType:      windows.SERVICE_WIN32_OWN_PROCESS,
+		StartType:        mgr.StartAutomatic,
+		ErrorControl:     mgr.ErrorNormal,
+		Dependencies:     Dependencies,
+		DisplayName:      "AmneziaWG Tunnel: " + tunnelName,
+		SidType:          windows.SERVICE_SID_TYPE_UNRESTRICTED,
+		DelayedAutoStart: m.options.DelayedAutoStart,
+	}
+	if m.options.StartType != 0 {
+		config.StartType = m.options.StartType
+	}
+	if config.StartType != mgr.StartAutomatic {
+		config.DelayedAutoStart = false
+	}
+	if m.options.DisplayName != nil {
+		config.DisplayName = m.options.DisplayName(tunnelName)
+	}
+	return config
+}
+
+func (m *Manager) arguments(configPath string) []string {
+	if m.options.Arguments != nil {
+		return m.options.Arguments(configPath)
+	}
+	return []string{"/service", configPath}
+}
+
+func (m *Manager) setRecoveryActions(service Service) error {
+	actions := m.options.RecoveryActions
+	if actions == nil {
+		actions = DefaultRecoveryActions
+	}
+	if m.options.NoRecovery || len(actions) == 0 {
+		// New services have none.
+		return nil
+	}
+	resetPeriod := uint32(defaultRecoveryResetPeriod)
+	if m.options.RecoveryResetPeriod > 0 {
+		resetPeriod = uint32(m.options.RecoveryResetPeriod / time.Second)
+	}
+	err := service.SetRecoveryActions(actions, resetPeriod)
+	if err != nil {
+		return err
+	}
+	// A tunnel that fails reports SERVICE_STOPPED with an error code rather than crashing, which the SCM only
+	// considers a failure when told to.
+	return service.SetRecoveryActionsOnNonCrashFailures(true)
+}
+
+// Install creates the service of the tunnel of the configuration file at configPath, replacing one that is
+// installed but not running, and returns the name of the tunnel. It does not start it.
+func (m *Manager) Install(configPath string) (string, error) {
+	executable := m.options.Executable
+	if len(executable) == 0 {
+		var err error
+		executable, err = os.Executable()
+		if err != nil {
+			return "", err
+		}
+	}
+	tunnelName, err := conf.NameFromPath(configPath)
+	if err != nil {
+		return "", err
+	}
+	serviceName, err := services.ServiceNameOfTunnel(tunnelName)
+	if err != nil {
+		return "", err
+	}
+
+	service, err := m.scm.OpenService(serviceName)
+	if err == nil {
+		status, err := service.Query()
+		if err != nil && err != windows.ERROR_SERVICE_MARKED_FOR_DELETE {
+			service.Close()
+			return "", err
+		}
+		if status.State != svc.Stopped && err != windows.ERROR_SERVICE_MARKED_FOR_DELETE {
+			service.Close()
+			return "", ErrTunnelRunning
+		}
+		err = service.Delete()
+		service.Close()
+		if err != nil && err != windows.ERROR_SERVICE_MARKED_FOR_DELETE {
+			return "", err
+		}
+		err = m.waitForDeletion(serviceName)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	service, err = m.scm.CreateService(serviceName, executable, m.serviceConfig(tunnelName), m.arguments(configPath)...)
+	if err != nil {
+		return "", err
+	}
+	defer service.Close()
+	err = m.setRecoveryActions(service)
+	if err != nil {
+		service.Delete()
+		return "", err
+	}
+	return tunnelName, nil
+}
+
+// waitForDeletion waits for a deleted service to go away, which it does once the last handle to it is closed.
+func (m *Manager) waitForDeletion(serviceName string) error {
+	deadline := time.Now().Add(m.timeout)
+	for {
+		service, err := m.scm.OpenService(serviceName)
+		if err != nil && err != windows.ERROR_SERVICE_MARKED_FOR_DELETE {
+			return nil
+		}
+		if service != nil {
+			service.Close()
+		}
+		if time.Now().After(deadline) {
+			return windows.ERROR_SERVICE_MARKED_FOR_DELETE
+		}
+		time.Sleep(m.pollInterval)
+	}
+}
+
+func (m *Manager) openTunnel(tunnelName string) (Service, error) {
+	serviceName, err := services.ServiceNameOfTunnel(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	return m.scm.OpenService(serviceName)
+}
+
+// Start starts the service of the tunnel, which is not an error if it is already running.
+func (m *Manager) Start(tunnelName string) error {
+	service, err := m.openTunnel(tunnelName)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	err = service.Start()
+	if err == windows.ERROR_SERVICE_ALREADY_RUNNING {
+		return nil
+	}
+	return err
+}
+
+// Stop stops the service of the tunnel and waits for it to have stopped, which is not an error if it was
+// not running.
+func (m *Manager) Stop(tunnelName string) error {
+	service, err := m.openTunnel(tunnelName)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	return m.stop(service)
+}
+
+func (m *Manager) stop(service Service) error {
+	status, err := service.Control(svc.Stop)
+	if err == windows.ERROR_SERVICE_NOT_ACTIVE {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(m.timeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return ErrTunnelNotStopped
+		}
+		time.Sleep(m.pollInterval)
+		status, err = service.Query()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the status of the service of the tunnel.
+func (m *Manager) Query(tunnelName string) (*TunnelStatus, error) {
+	service, err := m.openTunnel(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	defer service.Close()
+	status, err := service.Query()
+	if err != nil {
+		return nil, err
+	}
+	tunnelStatus := &TunnelStatus{Name: tunnelName, State: status.State, ProcessID: status.ProcessId}
+	if status.State == svc.Stopped {
+		if status.Win32ExitCode == uint32(windows.ERROR_SERVICE_SPECIFIC_ERROR) {
+			tunnelStatus.Err = services.Error(status.ServiceSpecificExitCode)
+		} else if status.Win32ExitCode != uint32(windows.NO_ERROR) {
+			tunnelStatus.Err = windows.Errno(status.Win32ExitCode)
+		}
+	}
+	return tunnelStatus, nil
+}
+
+// SetStartType changes whether the service of the tunnel starts automatically, with the same meaning as
+// Options.StartType and Options.DelayedAutoStart.
+func (m *Manager) SetStartType(tunnelName string, startType uint32, delayedAutoStart bool) error {
+	service, err := m.openTunnel(tunnelName)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	config, err := service.Config()
+	if err != nil {
+		return err
+	}
+	config.StartType = startType
+	config.DelayedAutoStart = delayedAutoStart && startType == mgr.StartAutomatic
+	return service.UpdateConfig(config)
+}
+
+// Uninstall stops the service of the tunnel if it is running and deletes it.
+func (m *Manager) Uninstall(tunnelName string) error {
+	service, err := m.openTunnel(tunnelName)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	err = m.stop(service)
+	if err != nil {
+		return err
+	}
+	err = service.Delete()
+	if err == windows.ERROR_SERVICE_MARKED_FOR_DELETE {
+		return nil
+	}
+	return err
+}
+
+// Tunnels lists the names of the installed tunnels, sorted.
+func (m *Manager) Tunnels() ([]string, error) {
+	serviceNames, err := m.scm.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	var tunnels []string
+	for _, serviceName := range serviceNames {
+		_, tunnelName, ok := strings.Cut(serviceName, "$")
+		if !ok {
+			continue
+		}
+		if name, err := services.ServiceNameOfTunnel(tunnelName); err == nil && name == serviceName {
+			tunnels = append(tunnels, tunnelName)
+		}
+	}
+	sort.Strings(tunnels)
+	return tunnels, nil
+}
@@ -0,0 +1,434 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+// fakeSCM keeps services in memory. Like the real one, it only removes a deleted service once all handles to
+// it are closed, and stopping a service takes a few queries to complete.
+type fakeSCM struct {
+	mu       sync.Mutex
+	services map[string]*fakeService
+}
+
+type fakeService struct {
+	exepath     string
+	args        []string
+	config      mgr.Config
+	status      svc.Status
+	recovery    []mgr.RecoveryAction
+	resetPeriod uint32
+	nonCrash    bool
+	deleted     bool
+	handles     int
+	stopAfter   int
+}
+
+type fakeHandle struct {
+	scm     *fakeSCM
+	name    string
+	service *fakeService
+	closed  bool
+}
+
+func newFakeSCM() *fakeSCM {
+	return &fakeSCM{services: make(map[string]*fakeService)}
+}
+
+func (scm *fakeSCM) service(name string) *fakeService {
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	return scm.services[name]
+}
+
+func (scm *fakeSCM) OpenService(name string) (Service, error) {
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	service := scm.services[name]
+	if service == nil {
+		return nil, windows.ERROR_SERVICE_DOES_NOT_EXIST
+	}
+	if service.deleted {
+		return nil, windows.ERROR_SERVICE_MARKED_FOR_DELETE
+	}
+	service.handles++
+	return &fakeHandle{scm: scm, name: name, service: service}, nil
+}
+
+func (scm *fakeSCM) CreateService(name string, exepath string, config mgr.Config, args ...string) (Service, error) {
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	if scm.services[name] != nil {
+		return nil, windows.ERROR_SERVICE_EXISTS
+	}
+	service := &fakeService{exepath: exepath, args: args, config: config, status: svc.Status{State: svc.Stopped}, handles: 1}
+	scm.services[name] = service
+	return &fakeHandle{scm: scm, name: name, service: service}, nil
+}
+
+func (scm *fakeSCM) ListServices() ([]string, error) {
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	names := make([]string, 0, len(scm.services))
+	for name := range scm.services {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (scm *fakeSCM) Disconnect() error {
+	return nil
+}
+
+func (h *fakeHandle) Query() (svc.Status, error) {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	if h.service.status.State == svc.StopPending {
+		if h.service.stopAfter > 0 {
+			h.service.stopAfter--
+		} else {
+			h.service.status = svc.Status{State: svc.Stopped}
+		}
+	}
+	return h.service.status, nil
+}
+
+func (h *fakeHandle) Start(args ...string) error {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	if h.service.status.State != svc.Stopped {
+		return windows.ERROR_SERVICE_ALREADY_RUNNING
+	}
+	h.service.status = svc.Status{State: svc.Running, ProcessId: 1234}
+	return nil
+}
+
+func (h *fakeHandle) Control(cmd svc.Cmd) (svc.Status, error) {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	if cmd != svc.Stop {
+		return svc.Status{}, windows.ERROR_INVALID_SERVICE_CONTROL
+	}
+	if h.service.status.State == svc.Stopped {
+		return svc.Status{}, windows.ERROR_SERVICE_NOT_ACTIVE
+	}
+	h.service.status.State = svc.StopPending
+	return h.service.status, nil
+}
+
+func (h *fakeHandle) Config() (mgr.Config, error) {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	return h.service.config, nil
+}
+
+func (h *fakeHandle) UpdateConfig(config mgr.Config) error {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	h.service.config = config
+	return nil
+}
+
+func (h *fakeHandle) SetRecoveryActions(actions []mgr.RecoveryAction, resetPeriod uint32) error {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	h.service.recovery, h.service.resetPeriod = actions, resetPeriod
+	return nil
+}
+
+func (h *fakeHandle) SetRecoveryActionsOnNonCrashFailures(flag bool) error {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	h.service.nonCrash = flag
+	return nil
+}
+
+func (h *fakeHandle) Delete() error {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	if h.service.deleted {
+		return windows.ERROR_SERVICE_MARKED_FOR_DELETE
+	}
+	h.service.deleted = true
+	return nil
+}
+
+func (h *fakeHandle) Close() error {
+	h.scm.mu.Lock()
+	defer h.scm.mu.Unlock()
+	if h.closed {
+		return windows.ERROR_INVALID_HANDLE
+	}
+	h.closed = true
+	h.service.handles--
+	if h.service.deleted && h.service.handles == 0 && h.scm.services[h.name] == h.service {
+		delete(h.scm.services, h.name)
+	}
+	return nil
+}
+
+func newTestManager(scm *fakeSCM, options Options) *Manager {
+	if len(options.Executable) == 0 {
+		options.Executable = `C:\Program Files\AmneziaWG\amneziawg.exe`
+	}
+	m := New(scm, options)
+	m.pollInterval = time.Millisecond
+	m.timeout = time.Second
+	return m
+}
+
+const testServiceName = "AmneziaWGTunnel$office"
+
+func TestInstall(t *testing.T) {
+	scm := newFakeSCM()
+	m := newTestManager(scm, Options{})
+	name, err := m.Install(`C:\tunnels\office.conf.dpapi`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "office" {
+		t.Errorf("tunnel name = %q", name)
+	}
+	service := scm.service(testServiceName)
+	if service == nil {
+		t.Fatalf("no service among %v", scm.services)
+	}
+	if service.exepath != `C:\Program Files\AmneziaWG\amneziawg.exe` || !reflect.DeepEqual(service.args, []string{"/service", `C:\tunnels\office.conf.dpapi`}) {
+		t.Errorf("command line = %q %q", service.exepath, service.args)
+	}
+	if !reflect.DeepEqual(service.config.Dependencies, []string{"Nsi", "TcpIp"}) {
+		t.Errorf("dependencies = %q", service.config.Dependencies)
+	}
+	if service.config.StartType != mgr.StartAutomatic || service.config.DelayedAutoStart {
+		t.Errorf("start type = %d, delayed = %v", service.config.StartType, service.config.DelayedAutoStart)
+	}
+	if service.config.ServiceType != windows.SERVICE_WIN32_OWN_PROCESS || service.config.SidType != windows.SERVICE_SID_TYPE_UNRESTRICTED {
+		t.Errorf("service type = %#x, SID type = %d", service.config.ServiceType, service.config.SidType)
+	}
+	if service.config.DisplayName != "AmneziaWG Tunnel: office" {
+		t.Errorf("display name = %q", service.config.DisplayName)
+	}
+	if !reflect.DeepEqual(service.recovery, DefaultRecoveryActions) || service.resetPeriod != 60*60*24 || !service.nonCrash {
+		t.Errorf("recovery = %v every %ds, on non-crash failures = %v", service.recovery, service.resetPeriod, service.nonCrash)
+	}
+	if service.handles != 0 {
+		t.Errorf("%d handles left open", service.handles)
+	}
+	if status := service.status.State; status != svc.Stopped {
+		t.Errorf("state after install = %d, want it not started", status)
+	}
+
+	if _, err := m.Install(`C:\tunnels\not a valid name!.conf`); err == nil {
+		t.Error("installed a tunnel with an invalid name")
+	}
+	if _, err := m.Install(`C:\tunnels\office.txt`); err == nil {
+		t.Error("installed a tunnel of a file that is not a configuration")
+	}
+}
+
+func TestInstallOptions(t *testing.T) {
+	scm := newFakeSCM()
+	m := newTestManager(scm, Options{
+		Arguments:           func(configPath string) []string { return []string{"tunnel", "--config", configPath} },
+		DisplayName:         func(tunnelName string) string { return "VPN " + tunnelName },
+		StartType:           mgr.StartManual,
+		DelayedAutoStart:    true,
+		RecoveryActions:     []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}},
+		RecoveryResetPeriod: time.Hour,
+	})
+	if _, err := m.Install(`C:\tunnels\office.conf`); err != nil {
+		t.Fatal(err)
+	}
+	service := scm.service(testServiceName)
+	if !reflect.DeepEqual(service.args, []string{"tunnel", "--config", `C:\tunnels\office.conf`}) {
+		t.Errorf("arguments = %q", service.args)
+	}
+	if service.config.DisplayName != "VPN office" {
+		t.Errorf("display name = %q", service.config.DisplayName)
+	}
+	if service.config.StartType != mgr.StartManual || service.config.DelayedAutoStart {
+		t.Errorf("start type = %d, delayed = %v, want manual and not delayed", service.config.StartType, service.config.DelayedAutoStart)
+	}
+	if len(service.recovery) != 1 || service.recovery[0].Delay != time.Minute || service.resetPeriod != 60*60 {
+		t.Errorf("recovery = %v every %ds", service.recovery, service.resetPeriod)
+	}
+
+	scm = newFakeSCM()
+	m = newTestManager(scm, Options{NoRecovery: true})
+	if _, err := m.Install(`C:\tunnels\office.conf`); err != nil {
+		t.Fatal(err)
+	}
+	if service := scm.service(testServiceName); service.recovery != nil || service.nonCrash {
+		t.Errorf("recovery = %v, on non-crash failures = %v, want none", service.recovery, service.nonCrash)
+	}
+}
+
+func TestInstallReplaces(t *testing.T) {
+	scm := newFakeSCM()
+	m := newTestManager(scm, Options{})
+	if _, err := m.Install(`C:\tunnels\office.conf`); err != nil {
+		t.Fatal(err)
+	}
+	old := scm.service(testServiceName)
+
+	// Someone else holding a handle keeps the deleted service around until they let go.
+	held, err := scm.OpenService(testServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		held.Close()
+	}()
+	if _, err := m.Install(`C:\tunnels\office.conf.dpapi`); err != nil {
+		t.Fatal(err)
+	}
+	replaced := scm.service(testServiceName)
+	if replaced == old || replaced.args[1] != `C:\tunnels\office.conf.dpapi` {
+		t.Errorf("service not replaced: %+v", replaced)
+	}
+
+	if err := m.Start("office"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Install(`C:\tunnels\office.conf`); err != ErrTunnelRunning {
+		t.Errorf("Install over a running tunnel = %v, want %v", err, ErrTunnelRunning)
+	}
+}
+
+func TestStartStopQuery(t *testing.T) {
+	scm := newFakeSCM()
+	m := newTestManager(scm, Options{})
+	if _, err := m.Install(`C:\tunnels\office.conf`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start("office"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start("office"); err != nil {
+		t.Errorf("Start of a running tunnel = %v", err)
+	}
+	status, err := m.Query("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != svc.Running || status.ProcessID != 1234 || status.Err != nil {
+		t.Errorf("status = %+v", status)
+	}
+
+	scm.service(testServiceName).stopAfter = 3
+	if err := m.Stop("office"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := m.Query("office"); status.State != svc.Stopped {
+		t.Errorf("state after Stop = %d", status.State)
+	}
+	if err := m.Stop("office"); err != nil {
+		t.Errorf("Stop of a stopped tunnel = %v", err)
+	}
+
+	scm.service(testServiceName).status = svc.Status{State: svc.Stopped, Win32ExitCode: uint32(windows.ERROR_SERVICE_SPECIFIC_ERROR), ServiceSpecificExitCode: uint32(services.ErrorHandshakeTimeout)}
+	if status, _ := m.Query("office"); status.Err != services.ErrorHandshakeTimeout {
+		t.Errorf("error = %v, want %v", status.Err, services.ErrorHandshakeTimeout)
+	}
+	scm.service(testServiceName).status = svc.Status{State: svc.Stopped, Win32ExitCode: uint32(windows.ERROR_ACCESS_DENIED)}
+	if status, _ := m.Query("office"); !errors.Is(status.Err, windows.ERROR_ACCESS_DENIED) {
+		t.Errorf("error = %v, want %v", status.Err, windows.ERROR_ACCESS_DENIED)
+	}
+
+	if _, err := m.Query("lab"); err != windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		t.Errorf("Query of a tunnel not installed = %v", err)
+	}
+	if service := scm.service(testServiceName); service.handles != 0 {
+		t.Errorf("%d handles left open", service.handles)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	scm := newFakeSCM()
+	m := newTestManager(scm, Options{})
+	m.timeout = time.Millisecond * 20
+	if _, err := m.Install(`C:\tunnels\office.conf`); err != nil {
+		t.Fatal(err)
+	}
+	m.Start("office")
+	scm.service(testServiceName).stopAfter = 1 << 30
+	if err := m.Stop("office"); err != ErrTunnelNotStopped {
+		t.Errorf("Stop = %v, want %v", err, ErrTunnelNotStopped)
+	}
+}
+
+func TestSetStartType(t *testing.T) {
+	scm := newFakeSCM()
+	m := newTestManager(scm, Options{})
+	if _, err := m.Install(`C:\tunnels\office.conf`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetStartType("office", mgr.StartAutomatic, true); err != nil {
+		t.Fatal(err)
+	}
+	if config := scm.service(testServiceName).config; config.StartType != mgr.StartAutomatic || !config.DelayedAutoStart {
+		t.Errorf("start type = %d, delayed = %v", config.StartType, config.DelayedAutoStart)
+	}
+	if err := m.SetStartType("office", mgr.StartDisabled, true); err != nil {
+		t.Fatal(err)
+	}
+	config := scm.service(testServiceName).config
+	if config.StartType != mgr.StartDisabled || config.DelayedAutoStart {
+		t.Errorf("start type = %d, delayed = %v", config.StartType, config.DelayedAutoStart)
+	}
+	if !reflect.DeepEqual(config.Dependencies, Dependencies) {
+		t.Errorf("dependencies = %q after changing the start type", config.Dependencies)
+	}
+}
+
+func TestUninstallAndTunnels(t *testing.T) {
+	scm := newFakeSCM()
+	m := newTestManager(scm, Options{})
+	for _, path := range []string{`C:\tunnels\office.conf`, `C:\tunnels\lab.conf.dpapi`, `C:\tunnels\home.conf`} {
+		if _, err := m.Install(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scm.CreateService("Dnscache", `C:\Windows\system32\svchost.exe`, mgr.Config{})
+	scm.CreateService("WireGuardTunnel$office", `C:\Program Files\WireGuard\wireguard.exe`, mgr.Config{})
+	tunnels, err := m.Tunnels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tunnels, []string{"home", "lab", "office"}) {
+		t.Errorf("tunnels = %q", tunnels)
+	}
+
+	if err := m.Start("office"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Uninstall("office"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Uninstall("lab"); err != nil {
+		t.Fatal(err)
+	}
+	tunnels, _ = m.Tunnels()
+	if !reflect.DeepEqual(tunnels, []string{"home"}) {
+		t.Errorf("tunnels after uninstalling = %q", tunnels)
+	}
+	if err := m.Uninstall("office"); err != windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		t.Errorf("Uninstall of a tunnel not installed = %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// SCM is the part of the service control manager that Manager uses, so that tests can do without one.
+type SCM interface {
+	OpenService(name string) (Service, error)
+	CreateService(name string, exepath string, config mgr.Config, args ...string) (Service, error)
+	ListServices() ([]string, error)
+	Disconnect() error
+}
+
+// Service is the part of a service that Manager uses, which *mgr.Service implements.
+type Service interface {
+	Query() (svc.Status, error)
+	Start(args ...string) error
+	Control(cmd svc.Cmd) (svc.Status, error)
+	Config() (mgr.Config, error)
+	UpdateConfig(config mgr.Config) error
+	SetRecoveryActions(actions []mgr.RecoveryAction, resetPeriod uint32) error
+	SetRecoveryActionsOnNonCrashFailures(flag bool) error
+	Delete() error
+	Close() error
+}
+
+// systemSCM is the service control manager of the local computer.
+type systemSCM struct {
+	m *mgr.Mgr
+}
+
+func (scm systemSCM) OpenService(name string) (Service, error) {
+	service, err := scm.m.OpenService(name)
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func (scm systemSCM) CreateService(name string, exepath string, config mgr.Config, args ...string) (Service, error) {
+	service, err := scm.m.CreateService(name, exepath, config, args...)
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func (scm systemSCM) ListServices() ([]string, error) {
+	return scm.m.ListServices()
+}
+
+func (scm systemSCM) Disconnect() error {
+	return scm.m.Disconnect()
+}
@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrArchiverRunning = errors.New("Another process is archiving the log")
+
+type ArchiveOptions struct {
+	// Directory holds the archives, which on Windows is log-archive under conf.RootDirectory if empty.
+	Directory string
+	// MaxSize is the size of compressed archive at which it is rotated, which is 8 MiB if zero.
+	MaxSize int64
+	// MaxFiles is the number of archives to keep, including the current one, which is 8 if zero.
+	MaxFiles int
+	// Interval is how often to check the ring for new lines, which is a second if zero.
+	Interval time.Duration
+}
+
+// Archiver copies lines from a ring to compressed archives as they are written, so that they remain
+// available after the ring overwrites them. Only one process at a time archives a directory.
+type Archiver struct {
+	cancel context.CancelFunc
+	done   chan error
+	lock   io.Closer
+}
+
+// archiveExporter writes lines newer than those archived before it started, recording the time of the
+// newest archived line after each flush so that restarting does not archive lines again.
+type archiveExporter struct {
+	*RotatingFileExporter
+	statePath   string
+	resumeAfter time.Time
+	newest      time.Time
+}
+
+func (exporter *archiveExporter) Export(line *FollowLine) error {
+	if !line.Stamp.After(exporter.resumeAfter) {
+		return nil
+	}
+	if line.Stamp.After(exporter.newest) {
+		exporter.newest = line.Stamp
+	}
+	return exporter.RotatingFileExporter.Export(line)
+}
+
+func (exporter *archiveExporter) Flush() error {
+	err := exporter.RotatingFileExporter.Flush()
+	if err != nil || exporter.newest.IsZero() {
+		return err
+	}
+	return os.WriteFile(exporter.statePath, []byte(strconv.FormatInt(exporter.newest.UnixNano(), 10)), 0600)
+}
+
+func (rl *Ringlogger) StartArchiver(options ArchiveOptions) (*Archiver, error) {
+	if len(options.Directory) == 0 {
+		directory, err := defaultArchiveDirectory()
+		if err != nil {
+			return nil, err
+		}
+		options.Directory = directory
+	}
+	if options.MaxSize == 0 {
+		options.MaxSize = 8 << 20
+	}
+	if options.MaxFiles == 0 {
+		options.MaxFiles = 8
+	}
+	if options.Interval == 0 {
+		options.Interval = time.Second
+	}
+	if options.MaxSize < 0 || options.MaxFiles < 0 || options.Interval < 0 {
+		return nil, os.ErrInvalid
+	}
+	err := os.MkdirAll(options.Directory, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lockArchive(filepath.Join(options.Directory, "archive.lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := &archiveExporter{statePath: filepath.Join(options.Directory, "archive.state")}
+	if state, err := os.ReadFile(exporter.statePath); err == nil {
+		if ns, err := strconv.ParseInt(strings.TrimSpace(string(state)), 10, 64); err == nil {
+			exporter.resumeAfter = time.Unix(0, ns)
+		}
+	}
+	exporter.RotatingFileExporter, err = NewCompressedRotatingFileExporter(filepath.Join(options.Directory, "log.txt.gz"), options.MaxSize, options.MaxFiles-1)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	archiver := &Archiver{cancel: cancel, done: make(chan error, 1), lock: lock}
+	go func() {
+		err := rl.Follow(ctx, exporter, options.Interval)
+		if closeErr := exporter.Close(); err == context.Canceled {
+			err = closeErr
+		}
+		archiver.done <- err
+	}()
+	return archiver, nil
+}
+
+// Close stops archiving, returning the error that stopped it before, if any.
+func (archiver *Archiver) Close() error {
+	if archiver.cancel == nil {
+		return nil
+	}
+	archiver.cancel()
+	err := <-archiver.done
+	archiver.lock.Close()
+	archiver.cancel = nil
+	return err
+}
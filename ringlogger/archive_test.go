@@ -0,0 +1,87 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readArchive(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+func TestArchiver(t *testing.T) {
+	dir := t.TempDir()
+	rl, err := NewRingloggerWithLines(filepath.Join(dir, "log.bin"), "TST", MinLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	options := ArchiveOptions{Directory: filepath.Join(dir, "archive"), Interval: 10 * time.Millisecond}
+
+	archiver, err := rl.StartArchiver(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rl.StartArchiver(options); err != ErrArchiverRunning {
+		t.Errorf("second archiver = %v", err)
+	}
+	for i := 0; i < MinLines*3; i++ {
+		fmt.Fprintf(rl, "Line %d", i)
+		if i%64 == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := archiver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarting archives only the lines written since.
+	fmt.Fprint(rl, "After restarting")
+	archiver, err = rl.StartArchiver(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := archiver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readArchive(t, filepath.Join(options.Directory, "log.txt.gz"))), "\n")
+	if len(lines) != MinLines*3+1 {
+		t.Fatalf("%d lines archived, want %d", len(lines), MinLines*3+1)
+	}
+	for i, line := range lines[:MinLines*3] {
+		if !strings.HasSuffix(line, fmt.Sprintf(": [TST] Line %d", i)) {
+			t.Fatalf("line %d is %q", i, line)
+		}
+	}
+	if !strings.HasSuffix(lines[MinLines*3], ": [TST] After restarting") {
+		t.Errorf("last line is %q", lines[MinLines*3])
+	}
+}
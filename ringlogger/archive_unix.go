@@ -0,0 +1,37 @@
+//go:build unix
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func defaultArchiveDirectory() (string, error) {
+	return "", errors.New("No default archive directory on this platform")
+}
+
+// lockArchive takes an exclusive lock on the lock file of an archive directory, held until it is closed.
+func lockArchive(path string) (io.Closer, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		file.Close()
+		return nil, ErrArchiverRunning
+	} else if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
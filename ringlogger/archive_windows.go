@@ -0,0 +1,39 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+)
+
+func defaultArchiveDirectory() (string, error) {
+	root, err := conf.RootDirectory(true)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "log-archive"), nil
+}
+
+// lockArchive opens the lock file of an archive directory without sharing, deleting it once closed.
+func lockArchive(path string) (io.Closer, error) {
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := windows.CreateFile(path16, windows.GENERIC_WRITE, 0, nil, windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_DELETE_ON_CLOSE, 0)
+	if err == windows.ERROR_SHARING_VIOLATION {
+		return nil, ErrArchiverRunning
+	} else if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(lock), path), nil
+}
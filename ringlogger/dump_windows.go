@@ -0,0 +1,35 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"io"
+	"path/filepath"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+)
+
+// OpenLog opens the log of the tunnel services for reading, such as for exporting it with ExportTo or Follow.
+func OpenLog(notSystem bool) (*Ringlogger, error) {
+	root, err := conf.RootDirectory(!notSystem)
+	if err != nil {
+		return nil, err
+	}
+	return OpenRinglogger(filepath.Join(root, "log.bin"))
+}
+
+func DumpTo(out io.Writer, notSystem bool) error {
+	rl, err := OpenLog(notSystem)
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+	_, err = rl.WriteTo(out)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timestampFormat = "2006-01-02 15:04:05.000000"
+
+// Exporter writes log lines somewhere outside of the ring, such as to a file or a log collector.
+type Exporter interface {
+	Export(line *FollowLine) error
+	Close() error
+}
+
+// flushExporter flushes exporters that buffer lines, such as RotatingFileExporter.
+func flushExporter(exporter Exporter) error {
+	if flusher, ok := exporter.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// asRecord returns the record of a structured line, or one made of a text line, with the tag taken from its
+// prefix and the rest as an informational message.
+func (line *FollowLine) asRecord() Record {
+	if line.Record != nil {
+		return *line.Record
+	}
+	record := Record{Time: line.Stamp, Level: slog.LevelInfo, Message: line.Line}
+	if strings.HasPrefix(line.Line, "[") {
+		if tag, message, ok := strings.Cut(line.Line[1:], "] "); ok && len(tag) <= maxTagLength {
+			record.Tag, record.Message = tag, message
+		}
+	}
+	return record
+}
+
+// TextExporter writes lines in the format of WriteTo.
+type TextExporter struct {
+	out io.Writer
+}
+
+func NewTextExporter(out io.Writer) *TextExporter {
+	return &TextExporter{out}
+}
+
+func (exporter *TextExporter) Export(line *FollowLine) error {
+	_, err := fmt.Fprintf(exporter.out, "%s: %s\n", line.Stamp.Format(timestampFormat), line.Line)
+	return err
+}
+
+func (exporter *TextExporter) Close() error {
+	return nil
+}
+
+// JSONExporter writes lines as JSON Lines, one object per line with the time, level, tag, tunnel, message,
+// and fields, in the order they were logged.
+type JSONExporter struct {
+	out io.Writer
+	buf []byte
+}
+
+func NewJSONExporter(out io.Writer) *JSONExporter {
+	return &JSONExporter{out: out}
+}
+
+func appendJSONString(b []byte, s string) []byte {
+	encoded, _ := json.Marshal(s)
+	return append(b, encoded...)
+}
+
+func (exporter *JSONExporter) Export(line *FollowLine) error {
+	record := line.asRecord()
+	b := exporter.buf[:0]
+	b = append(b, `{"time":`...)
+	b = appendJSONString(b, line.Stamp.UTC().Format(time.RFC3339Nano))
+	b = append(b, `,"level":`...)
+	b = appendJSONString(b, levelName(record.Level))
+	if len(record.Tag) > 0 {
+		b = append(b, `,"tag":`...)
+		b = appendJSONString(b, record.Tag)
+	}
+	if len(record.Tunnel) > 0 {
+		b = append(b, `,"tunnel":`...)
+		b = appendJSONString(b, record.Tunnel)
+	}
+	b = append(b, `,"message":`...)
+	b = appendJSONString(b, record.Message)
+	if len(record.Fields) > 0 {
+		b = append(b, `,"fields":{`...)
+		for i, field := range record.Fields {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, field.Key)
+			b = append(b, ':')
+			b = appendJSONString(b, field.Value)
+		}
+		b = append(b, '}')
+	}
+	b = append(b, "}\n"...)
+	exporter.buf = b
+	_, err := exporter.out.Write(b)
+	return err
+}
+
+func (exporter *JSONExporter) Close() error {
+	return nil
+}
+
+const (
+	SyslogFacilityUser   = 1
+	SyslogFacilityDaemon = 3
+	SyslogFacilityLocal0 = 16
+)
+
+// syslogEnterpriseNumber is the private enterprise number reserved for documentation by RFC 5612, which
+// qualifies the names of our structured data elements.
+const syslogEnterpriseNumber = "32473"
+
+type SyslogOptions struct {
+	// Network is "udp" or "tcp", which frames messages by octet counting as in RFC 6587.
+	Network string
+	// Address is the host and port of the collector.
+	Address string
+	// Facility is SyslogFacilityDaemon if zero.
+	Facility int
+	// Hostname is that of the computer if empty.
+	Hostname string
+	// AppName is "amneziawg" if empty.
+	AppName string
+}
+
+// SyslogExporter forwards lines to a collector as RFC 5424 messages, with the tag as the message ID and
+// the tunnel and fields as structured data. It reconnects to TCP collectors that closed the connection.
+type SyslogExporter struct {
+	options SyslogOptions
+	procID  string
+	mu      sync.Mutex
+	conn    net.Conn
+	closed  bool
+}
+
+func DialSyslog(options SyslogOptions) (*SyslogExporter, error) {
+	if options.Network != "udp" && options.Network != "tcp" {
+		return nil, fmt.Errorf("Invalid syslog network %q", options.Network)
+	}
+	if options.Facility == 0 {
+		options.Facility = SyslogFacilityDaemon
+	}
+	if options.Facility < 0 || options.Facility > 23 {
+		return nil, fmt.Errorf("Invalid syslog facility %d", options.Facility)
+	}
+	if len(options.Hostname) == 0 {
+		options.Hostname, _ = os.Hostname()
+	}
+	if len(options.AppName) == 0 {
+		options.AppName = "amneziawg"
+	}
+	exporter := &SyslogExporter{options: options, procID: strconv.Itoa(os.Getpid())}
+	err := exporter.dial()
+	if err != nil {
+		return nil, err
+	}
+	return exporter, nil
+}
+
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// syslogName makes s into a header field or structured data name, which are printable ASCII without spaces,
+// and for names also without '=', ']', and '"', of at most max characters. Empty ones become the nil value.
+func syslogName(s string, max int, sdName bool) string {
+	name := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(name) < max; i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || (sdName && (c == '=' || c == ']' || c == '"')) {
+			c = '_'
+		}
+		name = append(name, c)
+	}
+	if len(name) == 0 {
+		return "-"
+	}
+	return string(name)
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (exporter *SyslogExporter) format(line *FollowLine) []byte {
+	record := line.asRecord()
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ",
+		exporter.options.Facility*8+syslogSeverity(record.Level),
+		line.Stamp.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogName(exporter.options.Hostname, 255, false),
+		syslogName(exporter.options.AppName, 48, false),
+		exporter.procID,
+		syslogName(record.Tag, 32, false))
+	if len(record.Tunnel) == 0 && len(record.Fields) == 0 {
+		b.WriteByte('-')
+	}
+	if len(record.Tunnel) > 0 {
+		b.WriteString("[tunnel@" + syslogEnterpriseNumber + ` name="` + syslogParamEscaper.Replace(record.Tunnel) + `"]`)
+	}
+	if len(record.Fields) > 0 {
+		b.WriteString("[fields@" + syslogEnterpriseNumber)
+		for _, field := range record.Fields {
+			b.WriteString(" " + syslogName(field.Key, 32, true) + `="` + syslogParamEscaper.Replace(field.Value) + `"`)
+		}
+		b.WriteByte(']')
+	}
+	if len(record.Message) > 0 {
+		b.WriteString(" \ufeff" + record.Message)
+	}
+	return []byte(b.String())
+}
+
+func (exporter *SyslogExporter) dial() (err error) {
+	exporter.conn, err = net.DialTimeout(exporter.options.Network, exporter.options.Address, time.Second*10)
+	return
+}
+
+func (exporter *SyslogExporter) Export(line *FollowLine) error {
+	message := exporter.format(line)
+	if exporter.options.Network == "tcp" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	if exporter.closed {
+		return net.ErrClosed
+	}
+	if exporter.conn == nil {
+		err := exporter.dial()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := exporter.conn.Write(message)
+	if err != nil && exporter.options.Network == "tcp" {
+		exporter.conn.Close()
+		err = exporter.dial()
+		if err != nil {
+			return err
+		}
+		_, err = exporter.conn.Write(message)
+	}
+	return err
+}
+
+func (exporter *SyslogExporter) Close() error {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	exporter.closed = true
+	if exporter.conn == nil {
+		return nil
+	}
+	err := exporter.conn.Close()
+	exporter.conn = nil
+	return err
+}
+
+// RotatingFileExporter writes lines in the format of WriteTo to a file, which is renamed with a .1 suffix
+// when it grows past a maximum size, shifting older files up to a maximum number of them.
+type RotatingFileExporter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	compress   bool
+	file       *os.File
+	gz         *gzip.Writer
+	out        *bufio.Writer
+	size       int64
+}
+
+func NewRotatingFileExporter(path string, maxSize int64, maxBackups int) (*RotatingFileExporter, error) {
+	return newRotatingFileExporter(path, maxSize, maxBackups, false)
+}
+
+// NewCompressedRotatingFileExporter is like NewRotatingFileExporter, but compresses the files with gzip and
+// puts the number of rotated ones before the extension, as in log.1.gz. As compressed lines reach the file
+// only when flushed, its size is checked against the maximum as of the last flush.
+func NewCompressedRotatingFileExporter(path string, maxSize int64, maxBackups int) (*RotatingFileExporter, error) {
+	return newRotatingFileExporter(path, maxSize, maxBackups, true)
+}
+
+func newRotatingFileExporter(path string, maxSize int64, maxBackups int, compress bool) (*RotatingFileExporter, error) {
+	if maxSize <= 0 || maxBackups < 0 {
+		return nil, os.ErrInvalid
+	}
+	exporter := &RotatingFileExporter{path: path, maxSize: maxSize, maxBackups: maxBackups, compress: compress}
+	err := exporter.open()
+	if err != nil {
+		return nil, err
+	}
+	return exporter, nil
+}
+
+func (exporter *RotatingFileExporter) backupPath(i int) string {
+	if exporter.compress {
+		extension := filepath.Ext(exporter.path)
+		return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(exporter.path, extension), i, extension)
+	}
+	return fmt.Sprintf("%s.%d", exporter.path, i)
+}
+
+func (exporter *RotatingFileExporter) open() error {
+	file, err := os.OpenFile(exporter.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	exporter.file = file
+	exporter.size = info.Size()
+	if exporter.compress {
+		// Appending to an existing file adds a gzip member, which decompressors read as a continuation.
+		exporter.gz = gzip.NewWriter(file)
+		exporter.out = bufio.NewWriter(exporter.gz)
+	} else {
+		exporter.out = bufio.NewWriter(file)
+	}
+	return nil
+}
+
+func (exporter *RotatingFileExporter) rotate() error {
+	err := exporter.closeFile()
+	if err != nil {
+		return err
+	}
+	if exporter.maxBackups == 0 {
+		err = os.Remove(exporter.path)
+	} else {
+		for i := exporter.maxBackups - 1; i > 0; i-- {
+			err = os.Rename(exporter.backupPath(i), exporter.backupPath(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(exporter.path, exporter.backupPath(1))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return exporter.open()
+}
+
+func (exporter *RotatingFileExporter) Export(line *FollowLine) error {
+	if exporter.file == nil {
+		return os.ErrClosed
+	}
+	text := fmt.Sprintf("%s: %s\n", line.Stamp.Format(timestampFormat), line.Line)
+	full := exporter.size > 0 && exporter.size+int64(len(text)) > exporter.maxSize
+	if exporter.compress {
+		full = exporter.size >= exporter.maxSize
+	}
+	if full {
+		err := exporter.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := exporter.out.WriteString(text)
+	if !exporter.compress {
+		exporter.size += int64(n)
+	}
+	return err
+}
+
+// Flush writes buffered lines to the file, which Follow does after each batch of them.
+func (exporter *RotatingFileExporter) Flush() error {
+	if exporter.out == nil {
+		return os.ErrClosed
+	}
+	err := exporter.out.Flush()
+	if err != nil || exporter.gz == nil {
+		return err
+	}
+	err = exporter.gz.Flush()
+	if err != nil {
+		return err
+	}
+	info, err := exporter.file.Stat()
+	if err != nil {
+		return err
+	}
+	exporter.size = info.Size()
+	return nil
+}
+
+func (exporter *RotatingFileExporter) closeFile() error {
+	if exporter.file == nil {
+		return nil
+	}
+	err := exporter.out.Flush()
+	if exporter.gz != nil {
+		if closeErr := exporter.gz.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := exporter.file.Close(); err == nil {
+		err = closeErr
+	}
+	exporter.file, exporter.gz, exporter.out = nil, nil, nil
+	return err
+}
+
+func (exporter *RotatingFileExporter) Close() error {
+	return exporter.closeFile()
+}
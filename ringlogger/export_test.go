@@ -0,0 +1,236 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	exportStamp = time.Date(2024, 3, 1, 12, 30, 45, 123456000, time.UTC)
+	textLine    = FollowLine{Line: "[TUN] [office] Starting", Stamp: exportStamp}
+	recordLine  = FollowLine{Line: "unused", Stamp: exportStamp, Record: &Record{
+		Time: exportStamp, Level: slog.LevelWarn, Tag: "TUN", Tunnel: "office", Message: "Handshake timed out",
+		Fields: []Field{{"peer", "xTIBA5rbo"}, {"error", `no "route" to host]`}, {"bad key", "1"}},
+	}}
+)
+
+func TestJSONExporter(t *testing.T) {
+	var out bytes.Buffer
+	exporter := NewJSONExporter(&out)
+	for _, line := range []*FollowLine{&textLine, &recordLine} {
+		if err := exporter.Export(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := `{"time":"2024-03-01T12:30:45.123456Z","level":"INFO","tag":"TUN","message":"[office] Starting"}
+{"time":"2024-03-01T12:30:45.123456Z","level":"WARN","tag":"TUN","tunnel":"office","message":"Handshake timed out","fields":{"peer":"xTIBA5rbo","error":"no \"route\" to host]","bad key":"1"}}
+`
+	if out.String() != want {
+		t.Errorf("JSON Lines =\n%s\nwant\n%s", out.String(), want)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !json.Valid([]byte(line)) {
+			t.Errorf("invalid JSON: %s", line)
+		}
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	exporter := &SyslogExporter{options: SyslogOptions{Facility: SyslogFacilityLocal0, Hostname: "laptop", AppName: "amneziawg"}, procID: "42"}
+	got := string(exporter.format(&recordLine))
+	want := `<132>1 2024-03-01T12:30:45.123456Z laptop amneziawg 42 TUN [tunnel@32473 name="office"][fields@32473 peer="xTIBA5rbo" error="no \"route\" to host\]" bad_key="1"] ` + "\ufeffHandshake timed out"
+	if got != want {
+		t.Errorf("message =\n%s\nwant\n%s", got, want)
+	}
+	got = string(exporter.format(&FollowLine{Line: "No tag", Stamp: exportStamp}))
+	if want = "<134>1 2024-03-01T12:30:45.123456Z laptop amneziawg 42 - - \ufeffNo tag"; got != want {
+		t.Errorf("message =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	exporter, err := DialSyslog(SyslogOptions{Network: "udp", Address: collector.LocalAddr().String(), Hostname: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	if err := exporter.Export(&textLine); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := collector.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("<30>1 2024-03-01T12:30:45.123456Z laptop amneziawg %d TUN - \ufeff[office] Starting", os.Getpid()); string(buf[:n]) != want {
+		t.Errorf("datagram = %q, want %q", buf[:n], want)
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	collector, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	received := make(chan []string, 2)
+	go func() {
+		for {
+			conn, err := collector.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			var messages []string
+			for {
+				length, err := reader.ReadString(' ')
+				if err != nil {
+					break
+				}
+				n, _ := strconv.Atoi(strings.TrimSpace(length))
+				message := make([]byte, n)
+				if _, err := io.ReadFull(reader, message); err != nil {
+					break
+				}
+				messages = append(messages, string(message))
+			}
+			received <- messages
+		}
+	}()
+
+	exporter, err := DialSyslog(SyslogOptions{Network: "tcp", Address: collector.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []*FollowLine{&textLine, &recordLine} {
+		if err := exporter.Export(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exporter.Close()
+	if err := exporter.Export(&textLine); err != net.ErrClosed {
+		t.Errorf("Export after Close = %v", err)
+	}
+	messages := <-received
+	if len(messages) != 2 || !strings.HasSuffix(messages[0], "[office] Starting") || !strings.HasSuffix(messages[1], "Handshake timed out") {
+		t.Errorf("messages = %q", messages)
+	}
+}
+
+func TestRotatingFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.log")
+	lineLength := int64(len(exportStamp.Format(timestampFormat)) + len(": ") + len(textLine.Line) + 1)
+	exporter, err := NewRotatingFileExporter(path, lineLength*3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := exporter.Export(&textLine); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for suffix, lines := range map[string]int{"": 1, ".1": 3, ".2": 3} {
+		contents, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(contents)) != lineLength*int64(lines) || !strings.HasPrefix(string(contents), "2024-03-01 12:30:45.123456: [TUN] [office] Starting\n") {
+			t.Errorf("%s has %q, want %d lines", suffix, contents, lines)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more backups than asked for: %v", err)
+	}
+}
+
+func TestCompressedRotatingFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.txt.gz")
+	exporter, err := NewCompressedRotatingFileExporter(path, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := exporter.Export(&textLine); err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	text := readArchive(t, path) + readArchive(t, filepath.Join(filepath.Dir(path), "log.txt.1.gz"))
+	if strings.Count(text, "[TUN] [office] Starting\n") != 2 {
+		t.Errorf("archives have %q, want the last two lines", text)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "log.txt.2.gz")); !os.IsNotExist(err) {
+		t.Errorf("kept more backups than asked for: %v", err)
+	}
+}
+
+type collectingExporter struct {
+	lines chan FollowLine
+}
+
+func (exporter *collectingExporter) Export(line *FollowLine) error {
+	exporter.lines <- *line
+	return nil
+}
+
+func (exporter *collectingExporter) Close() error {
+	return nil
+}
+
+func TestFollowExport(t *testing.T) {
+	rl, err := NewRinglogger(filepath.Join(t.TempDir(), "log.bin"), "TST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	fmt.Fprint(rl, "Before following")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exporter := &collectingExporter{make(chan FollowLine, 16)}
+	done := make(chan error)
+	go func() {
+		done <- rl.Follow(ctx, exporter, 10*time.Millisecond)
+	}()
+	if line := <-exporter.lines; line.Line != "[TST] Before following" {
+		t.Errorf("first line = %q", line.Line)
+	}
+	rl.WriteRecord(slog.LevelInfo, "office", "While following")
+	if line := <-exporter.lines; line.Record == nil || line.Record.Message != "While following" {
+		t.Errorf("followed line = %+v", line)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Follow = %v", err)
+	}
+}
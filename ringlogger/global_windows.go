@@ -15,6 +15,8 @@ import (
 
 var Global *Ringlogger
 
+// InitGlobalLogger opens the log shared by the processes of AmneziaWG, with as many lines as the LogLines admin
+// registry value asks for, or else DefaultLines.
 func InitGlobalLogger(tag string) error {
 	if Global != nil {
 		return nil
@@ -23,7 +25,11 @@ func InitGlobalLogger(tag string) error {
 	if err != nil {
 		return err
 	}
-	Global, err = NewRinglogger(filepath.Join(root, "log.bin"), tag)
+	lines := uint32(DefaultLines)
+	if configured := conf.AdminInteger("LogLines"); configured > 0 {
+		lines = uint32(min(configured, MaxLines))
+	}
+	Global, err = NewRingloggerWithLines(filepath.Join(root, "log.bin"), tag, lines)
 	if err != nil {
 		return err
 	}
@@ -33,6 +39,19 @@ func InitGlobalLogger(tag string) error {
 	return nil
 }
 
+// StartGlobalArchiver archives the global log if the LogArchive admin registry value is set. It returns nil
+// without error if it is not, or if another process archives the log already.
+func StartGlobalArchiver() (*Archiver, error) {
+	if Global == nil || !conf.AdminBool("LogArchive") {
+		return nil, nil
+	}
+	archiver, err := Global.StartArchiver(ArchiveOptions{})
+	if err == ErrArchiverRunning {
+		return nil, nil
+	}
+	return archiver, err
+}
+
 //go:linkname overrideWrite runtime.overrideWrite
 var overrideWrite func(fd uintptr, p unsafe.Pointer, n int32) int32
 
@@ -59,7 +78,7 @@ func globalWrite(fd uintptr, p unsafe.Pointer, n int32) int32 {
 			}
 		}
 		if foundNl || len(b) > 0 {
-			Global.Write(globalBuffer[:globalBufferLocation])
+			Global.write(globalBuffer[:globalBufferLocation], false)
 			globalBufferLocation = 0
 		}
 	}
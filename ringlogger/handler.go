@@ -0,0 +1,117 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// LevelVerbose is the level of the verbose lines of devices, which is named VERBOSE in the log rather than
+// DEBUG.
+const LevelVerbose = slog.LevelDebug
+
+func levelName(level slog.Level) string {
+	if level == LevelVerbose {
+		return "VERBOSE"
+	}
+	return level.String()
+}
+
+type HandlerOptions struct {
+	// Level is the minimum level of records to write, which is slog.LevelInfo if nil.
+	Level slog.Leveler
+	// Tunnel is the name of the tunnel to write in records.
+	Tunnel string
+}
+
+// Handler is a slog.Handler that writes structured records to a Ringlogger. Attributes in groups become
+// fields with keys qualified by the group names, separated by dots. Without a Ringlogger, as when the global
+// log was never opened, it writes the records as text lines to the standard logger instead.
+type Handler struct {
+	rl     *Ringlogger
+	opts   HandlerOptions
+	prefix string
+	fields []Field
+}
+
+func NewHandler(rl *Ringlogger, opts *HandlerOptions) *Handler {
+	handler := &Handler{rl: rl}
+	if opts != nil {
+		handler.opts = *opts
+	}
+	return handler
+}
+
+func (handler *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	minimum := slog.LevelInfo
+	if handler.opts.Level != nil {
+		minimum = handler.opts.Level.Level()
+	}
+	return level >= minimum
+}
+
+func appendAttr(fields []Field, prefix string, attr slog.Attr) []Field {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		if len(attr.Key) > 0 {
+			prefix += attr.Key + "."
+		}
+		for _, attr := range attr.Value.Group() {
+			fields = appendAttr(fields, prefix, attr)
+		}
+		return fields
+	case slog.KindTime:
+		return append(fields, Field{prefix + attr.Key, attr.Value.Time().Format(time.RFC3339Nano)})
+	}
+	return append(fields, Field{prefix + attr.Key, attr.Value.String()})
+}
+
+func (handler *Handler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]Field, len(handler.fields), len(handler.fields)+record.NumAttrs())
+	copy(fields, handler.fields)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendAttr(fields, handler.prefix, attr)
+		return true
+	})
+	stamp := record.Time
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	if handler.rl == nil {
+		text := Record{Level: record.Level, Tunnel: handler.opts.Tunnel, Message: record.Message, Fields: fields}
+		log.Print(text.String())
+		return nil
+	}
+	return handler.rl.writeRecord(stamp, record.Level, handler.opts.Tunnel, record.Message, fields)
+}
+
+func (handler *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return handler
+	}
+	clone := *handler
+	clone.fields = append([]Field(nil), handler.fields...)
+	for _, attr := range attrs {
+		clone.fields = appendAttr(clone.fields, handler.prefix, attr)
+	}
+	return &clone
+}
+
+func (handler *Handler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return handler
+	}
+	clone := *handler
+	clone.prefix += name + "."
+	return &clone
+}
@@ -0,0 +1,74 @@
+//go:build unix
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const errTagTooLong = unix.ENAMETOOLONG
+
+type fileMapping struct {
+	data []byte
+}
+
+// mapFile maps all of file, which other processes may map as well. Every process that maps the file holds a
+// shared lock on it, so that resizeUnlessShared can tell whether another one has it mapped.
+func mapFile(file *os.File, writable bool) (*fileMapping, error) {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return &fileMapping{}, nil
+	}
+	protection := unix.PROT_READ
+	if writable {
+		protection |= unix.PROT_WRITE
+	}
+	data, err := unix.Mmap(int(file.Fd()), 0, int(info.Size()), protection, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &fileMapping{data: data}, nil
+}
+
+func (mapping *fileMapping) flush() {
+	if len(mapping.data) > 0 {
+		unix.Msync(mapping.data, unix.MS_ASYNC)
+	}
+}
+
+func (mapping *fileMapping) close() {
+	if len(mapping.data) > 0 {
+		unix.Munmap(mapping.data)
+	}
+	mapping.data = nil
+}
+
+// resizeUnlessShared sets the size of file, unless another process has it mapped, as shrinking a file that
+// is mapped elsewhere would fault whoever mapped it.
+func resizeUnlessShared(file *os.File, size int64) (bool, error) {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	err = file.Truncate(size)
+	if lockErr := unix.Flock(int(file.Fd()), unix.LOCK_SH); err == nil {
+		err = lockErr
+	}
+	return err == nil, err
+}
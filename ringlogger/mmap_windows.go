@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const errTagTooLong = windows.ERROR_LABEL_TOO_LONG
+
+type fileMapping struct {
+	data   []byte
+	view   uintptr
+	handle windows.Handle
+}
+
+// mapFile maps all of file, which other processes may map as well.
+func mapFile(file *os.File, writable bool) (*fileMapping, error) {
+	protection, access := uint32(windows.PAGE_READONLY), uint32(windows.FILE_MAP_READ)
+	if writable {
+		protection, access = windows.PAGE_READWRITE, windows.FILE_MAP_WRITE
+	}
+	handle, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, protection, 0, 0, nil)
+	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
+		return nil, err
+	}
+	mapping, err := mapHandle(handle, access)
+	if err != nil {
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// mapHandle maps all of the file mapping object of handle, taking ownership of the handle if it succeeds.
+func mapHandle(handle windows.Handle, access uint32) (*fileMapping, error) {
+	view, err := windows.MapViewOfFile(handle, access, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	var info windows.MemoryBasicInformation
+	err = windows.VirtualQuery(view, &info, unsafe.Sizeof(info))
+	if err != nil {
+		windows.UnmapViewOfFile(view)
+		return nil, err
+	}
+	return &fileMapping{
+		data:   unsafe.Slice((*byte)(unsafe.Pointer(view)), info.RegionSize),
+		view:   view,
+		handle: handle,
+	}, nil
+}
+
+func (mapping *fileMapping) flush() {
+	windows.FlushViewOfFile(mapping.view, uintptr(len(mapping.data)))
+}
+
+func (mapping *fileMapping) close() {
+	windows.UnmapViewOfFile(mapping.view)
+	windows.CloseHandle(mapping.handle)
+	mapping.data = nil
+}
+
+// resizeUnlessShared sets the size of file, unless another process has it mapped, which Windows forbids.
+func resizeUnlessShared(file *os.File, size int64) (bool, error) {
+	err := file.Truncate(size)
+	if errors.Is(err, windows.ERROR_USER_MAPPED_FILE) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,155 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A line holding a structured record starts with a marker byte instead of the '[' of a text line, followed by
+// the level, the number of lines of the record in its first line or the index of the line in the others, and
+// the little endian length of the payload in the line. The payload of a record, split across its lines, is a
+// sequence of uvarint length prefixed strings: the tag, the tunnel name, the message, and then keys and values.
+const (
+	recordMarker       = 0x01
+	continuationMarker = 0x02
+	recordHeaderLength = 5
+	maxRecordLines     = 8
+	maxLinePayload     = maxLogLineLength - recordHeaderLength
+	maxRecordPayload   = maxRecordLines * maxLinePayload
+)
+
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Record is a structured log entry, as written by WriteRecord.
+type Record struct {
+	Time    time.Time
+	Level   slog.Level
+	Tag     string
+	Tunnel  string
+	Message string
+	Fields  []Field
+}
+
+func needsQuoting(s string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || r == utf8.RuneError || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the record like a text line, with the tag and tunnel name as prefixes, the level unless it is
+// informational, and the fields as key=value pairs after the message.
+func (record *Record) String() string {
+	var output strings.Builder
+	if len(record.Tag) > 0 {
+		output.WriteString("[" + record.Tag + "] ")
+	}
+	if len(record.Tunnel) > 0 {
+		output.WriteString("[" + record.Tunnel + "] ")
+	}
+	if record.Level != slog.LevelInfo {
+		output.WriteString(levelName(record.Level) + " ")
+	}
+	output.WriteString(record.Message)
+	for _, field := range record.Fields {
+		output.WriteString(" " + field.Key + "=")
+		if needsQuoting(field.Value) {
+			output.WriteString(strconv.Quote(field.Value))
+		} else {
+			output.WriteString(field.Value)
+		}
+	}
+	return output.String()
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// truncateString cuts s to at most n bytes without splitting a character.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// encodeRecord returns the payload of a record, which fits in maxRecordPayload by truncating the message and
+// leaving out the fields that do not fit.
+func encodeRecord(tag, tunnel, message string, fields []Field) []byte {
+	b := make([]byte, 0, maxLinePayload)
+	b = appendString(b, tag)
+	b = appendString(b, truncateString(tunnel, 0xff))
+	b = appendString(b, truncateString(message, maxRecordPayload-len(b)-binary.MaxVarintLen16))
+	for _, field := range fields {
+		next := appendString(appendString(b, field.Key), field.Value)
+		if len(next) > maxRecordPayload {
+			break
+		}
+		b = next
+	}
+	return b
+}
+
+func decodeRecord(payload []byte) (record Record, ok bool) {
+	readString := func() (string, bool) {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || length > uint64(len(payload)-n) {
+			return "", false
+		}
+		s := string(payload[n : n+int(length)])
+		payload = payload[n+int(length):]
+		return s, true
+	}
+	if record.Tag, ok = readString(); !ok {
+		return
+	}
+	if record.Tunnel, ok = readString(); !ok {
+		return
+	}
+	if record.Message, ok = readString(); !ok {
+		return
+	}
+	for len(payload) > 0 {
+		var field Field
+		if field.Key, ok = readString(); !ok {
+			return
+		}
+		if field.Value, ok = readString(); !ok {
+			return
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record, true
+}
+
+func storedLevel(level slog.Level) byte {
+	if level < -128 {
+		level = -128
+	} else if level > 127 {
+		level = 127
+	}
+	return byte(int8(level))
+}
@@ -0,0 +1,121 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordEncoding(t *testing.T) {
+	fields := []Field{{"peer", "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="}, {"endpoint", "[2001:db8::1]:51820"}, {"", ""}}
+	record, ok := decodeRecord(encodeRecord("TUN", "office", "Handshake completed", fields))
+	if !ok {
+		t.Fatal("unable to decode record")
+	}
+	want := Record{Tag: "TUN", Tunnel: "office", Message: "Handshake completed", Fields: fields}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("record = %+v, want %+v", record, want)
+	}
+
+	message := strings.Repeat("é", maxRecordPayload)
+	payload := encodeRecord("TUN", "office", message, fields)
+	if len(payload) > maxRecordPayload {
+		t.Errorf("payload of %d bytes, over %d", len(payload), maxRecordPayload)
+	}
+	record, ok = decodeRecord(payload)
+	if !ok || !strings.HasPrefix(message, record.Message) || len(record.Message) < maxRecordPayload-16 || record.Fields != nil {
+		t.Errorf("truncated message of %d bytes with %d fields, %v", len(record.Message), len(record.Fields), ok)
+	}
+
+	payload = encodeRecord("TUN", "office", "Message", nil)
+	for n := range payload {
+		if _, ok := decodeRecord(payload[:n]); ok {
+			t.Errorf("decoded record cut at %d bytes", n)
+		}
+	}
+}
+
+func TestRecordString(t *testing.T) {
+	record := Record{Tag: "TUN", Tunnel: "office", Level: slog.LevelWarn, Message: "Handshake timed out", Fields: []Field{
+		{"peer", "xTIBA5rbo"}, {"attempts", "3"}, {"error", "no route to host"}, {"note", ""},
+	}}
+	if got, want := record.String(), `[TUN] [office] WARN Handshake timed out peer=xTIBA5rbo attempts=3 error="no route to host" note=""`; got != want {
+		t.Errorf("String = %s, want %s", got, want)
+	}
+	record = Record{Tag: "MGR", Level: slog.LevelInfo, Message: "Starting"}
+	if got, want := record.String(), "[MGR] Starting"; got != want {
+		t.Errorf("String = %s, want %s", got, want)
+	}
+	record = Record{Tag: "TUN", Tunnel: "office", Level: LevelVerbose, Message: "Sending keepalive packet"}
+	if got, want := record.String(), "[TUN] [office] VERBOSE Sending keepalive packet"; got != want {
+		t.Errorf("String = %s, want %s", got, want)
+	}
+}
+
+func TestWriteRecord(t *testing.T) {
+	rl, err := NewRinglogger(filepath.Join(t.TempDir(), "log.bin"), "TST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+
+	fmt.Fprint(rl, "Plain text line")
+	long := strings.Repeat("0123456789", 150)
+	rl.WriteRecord(slog.LevelDebug, "office", long, Field{"key", "value"})
+	logger := slog.New(NewHandler(rl, &HandlerOptions{Tunnel: "office"})).With("peer", "xTIBA5rbo")
+	logger.Debug("Not written below the minimum level")
+	logger.WithGroup("handshake").Warn("Timed out", "attempts", 3, slog.Group("last", "error", "no route to host"))
+
+	lines, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != 3 {
+		t.Fatalf("%d lines, want 3", len(lines))
+	}
+	if lines[0].Line != "[TST] Plain text line" || lines[0].Record != nil {
+		t.Errorf("text line = %+v", lines[0])
+	}
+	if record := lines[1].Record; record == nil || record.Message != long || record.Level != slog.LevelDebug || len(record.Fields) != 1 {
+		t.Errorf("long record = %+v", record)
+	}
+	want := []Field{{"peer", "xTIBA5rbo"}, {"handshake.attempts", "3"}, {"handshake.last.error", "no route to host"}}
+	if record := lines[2].Record; record == nil || record.Tunnel != "office" || record.Level != slog.LevelWarn || !reflect.DeepEqual(record.Fields, want) {
+		t.Errorf("handler record = %+v", record)
+	}
+
+	var dump bytes.Buffer
+	if _, err := rl.WriteTo(&dump); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(dump.String(), `: [TST] [office] WARN Timed out peer=xTIBA5rbo handshake.attempts=3 handshake.last.error="no route to host"`+"\n") {
+		t.Errorf("dump = %s", dump.String())
+	}
+}
+
+func TestHandlerWithoutRinglogger(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	logger := slog.New(NewHandler(nil, &HandlerOptions{Tunnel: "office"}))
+	logger.Debug("Not written below the minimum level")
+	logger.Error("Failed to send handshake initiation", "error", "no route to host")
+
+	want := `[office] ERROR Failed to send handshake initiation error="no route to host"` + "\n"
+	if output.String() != want {
+		t.Errorf("log = %q, want %q", output.String(), want)
+	}
+}
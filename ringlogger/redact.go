@@ -0,0 +1,200 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bytes"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// RedactLevel is how much a Redactor hides, with each level hiding what the ones before it do.
+type RedactLevel uint8
+
+const (
+	RedactSecrets   RedactLevel = iota // keys, and the values of private and preshared keys and passwords
+	RedactAddresses                    // IP addresses
+	RedactHostnames                    // anything that looks like a domain name, which includes file names
+)
+
+const redacted = "(redacted)"
+
+var (
+	// secretAssignment matches PrivateKey=, PresharedKey= and Password= in configurations, private_key= and
+	// preshared_key= in UAPI, and the like, keeping the name but not the value.
+	secretAssignment = regexp.MustCompile("(?i)(\\b(?:private_?key|preshared_?key|password)\\s*[=:]\\s*)(\"[^\"]*\"|[^\\s\"'`,;]+)")
+	// ipCandidate matches what might be an IPv6 or IPv4 address, which is only redacted if it parses as one.
+	ipCandidate = regexp.MustCompile(`[0-9A-Fa-f:]*:[0-9A-Fa-f:.]*|\b\d{1,3}(?:\.\d{1,3}){3}\b`)
+	hostname    = regexp.MustCompile(`\b(?:[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?\b`)
+)
+
+type RedactOptions struct {
+	Level RedactLevel
+	// Patterns are hidden in addition to what Level hides.
+	Patterns []*regexp.Regexp
+}
+
+// Redactor hides secrets, and optionally addresses and other patterns, in log lines.
+type Redactor struct {
+	opts RedactOptions
+}
+
+// defaultRedactor is used for lines of tunnels without a redactor of their own, so that keys are never logged.
+var defaultRedactor = NewRedactor(RedactOptions{})
+
+func NewRedactor(opts RedactOptions) *Redactor {
+	return &Redactor{opts: opts}
+}
+
+func (redactor *Redactor) Redact(s string) string {
+	lower := strings.ToLower(s)
+	if strings.Contains(lower, "key") || strings.Contains(lower, "password") {
+		s = secretAssignment.ReplaceAllString(s, "${1}"+redacted)
+	}
+	s = redactKeys(s)
+	if redactor.opts.Level >= RedactAddresses {
+		s = ipCandidate.ReplaceAllStringFunc(s, func(candidate string) string {
+			address := strings.TrimRight(candidate, ".")
+			if _, err := netip.ParseAddr(address); err != nil {
+				return candidate
+			}
+			return redacted + candidate[len(address):]
+		})
+	}
+	if redactor.opts.Level >= RedactHostnames {
+		s = hostname.ReplaceAllLiteralString(s, redacted)
+	}
+	for _, pattern := range redactor.opts.Patterns {
+		s = pattern.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
+
+// containsFold reports whether p contains the lowercase ASCII word in any case, without allocating.
+func containsFold(p []byte, word string) bool {
+	for i := 0; i+len(word) <= len(p); i++ {
+		j := 0
+		for j < len(word) && p[i+j]|0x20 == word[j] {
+			j++
+		}
+		if j == len(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// leaves reports whether Redact would leave the line as it is, which it tells without allocating for
+// redactors that only hide secrets, as the default one does for every line written.
+func (redactor *Redactor) leaves(p []byte) bool {
+	if redactor.opts.Level >= RedactAddresses || len(redactor.opts.Patterns) > 0 {
+		return false
+	}
+	if containsFold(p, "key") || containsFold(p, "password") {
+		return false
+	}
+	run := 0
+	for _, c := range p {
+		if !isBase64Char(c) {
+			run = 0
+		} else if run++; run >= 43 {
+			return false
+		}
+	}
+	return true
+}
+
+func isBase64Char(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/'
+}
+
+func isHexChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// redactKeys hides 32 byte keys, in base64, whose last character before the padding has its lowest two
+// bits clear, and in hex, as in UAPI. This scans the line rather than using a regexp, as it runs on every
+// line written.
+func redactKeys(s string) string {
+	var b strings.Builder
+	written := 0
+	for start := 0; start < len(s); {
+		if !isBase64Char(s[start]) {
+			start++
+			continue
+		}
+		end, hex := start, true
+		for end < len(s) && isBase64Char(s[end]) {
+			hex = hex && isHexChar(s[end])
+			end++
+		}
+		keyStart, keyEnd := -1, end
+		if end-start >= 43 && end < len(s) && s[end] == '=' && strings.IndexByte("AEIMQUYcgkosw048", s[end-1]) >= 0 {
+			keyStart, keyEnd = end-43, end+1
+		} else if hex && end-start == 64 && (end == len(s) || s[end] != '_') && (start == 0 || s[start-1] != '_') {
+			keyStart = start
+		}
+		if keyStart >= 0 {
+			b.WriteString(s[written:keyStart])
+			b.WriteString(redacted)
+			written = keyEnd
+		}
+		start = keyEnd
+	}
+	if written == 0 {
+		return s
+	}
+	b.WriteString(s[written:])
+	return b.String()
+}
+
+// RedactField redacts the value of a field, all of which is hidden if its key names a secret.
+func (redactor *Redactor) RedactField(field Field) Field {
+	key := strings.ToLower(strings.NewReplacer("_", "", "-", "", ".", "").Replace(field.Key))
+	if strings.HasSuffix(key, "privatekey") || strings.HasSuffix(key, "presharedkey") || strings.HasSuffix(key, "password") {
+		return Field{field.Key, redacted}
+	}
+	return Field{field.Key, redactor.Redact(field.Value)}
+}
+
+// SetRedactor sets the redactor for the lines and records of a tunnel, or when nil, restores the default,
+// which only hides secrets. Text lines are of a tunnel when they start with its name in brackets, as they do
+// after log.SetPrefix. It does nothing on a nil Ringlogger, as Global is until InitGlobalLogger.
+func (rl *Ringlogger) SetRedactor(tunnel string, redactor *Redactor) {
+	if rl == nil {
+		return
+	}
+	rl.redactorsMutex.Lock()
+	defer rl.redactorsMutex.Unlock()
+	if redactor == nil {
+		delete(rl.redactors, tunnel)
+		return
+	}
+	if rl.redactors == nil {
+		rl.redactors = make(map[string]*Redactor)
+	}
+	rl.redactors[tunnel] = redactor
+}
+
+func (rl *Ringlogger) redactor(tunnel string) *Redactor {
+	rl.redactorsMutex.RLock()
+	defer rl.redactorsMutex.RUnlock()
+	if redactor, ok := rl.redactors[tunnel]; ok {
+		return redactor
+	}
+	return defaultRedactor
+}
+
+// redactorOfLine returns the redactor of the tunnel whose name the line starts with in brackets.
+func (rl *Ringlogger) redactorOfLine(line []byte) *Redactor {
+	if bytes.HasPrefix(line, []byte{'['}) {
+		if end := bytes.Index(line, []byte("] ")); end > 0 {
+			return rl.redactor(string(line[1:end]))
+		}
+	}
+	return rl.redactor("")
+}
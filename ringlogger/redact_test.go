@@ -0,0 +1,95 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	const key = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	const hex = "e84b5a6d2717c1003a13b431570353dbaca9146cf150c5f8575680feba52027a"
+	secrets := NewRedactor(RedactOptions{})
+	addresses := NewRedactor(RedactOptions{Level: RedactAddresses})
+	hostnames := NewRedactor(RedactOptions{Level: RedactHostnames, Patterns: []*regexp.Regexp{regexp.MustCompile(`office-\d+`)}})
+	for _, tt := range []struct {
+		redactor *Redactor
+		in, want string
+	}{
+		{secrets, "Peer " + key + " created", "Peer (redacted) created"},
+		{secrets, "private_key=" + hex, "private_key=(redacted)"},
+		{secrets, "Executing: `set-key.bat PrivateKey=hunter2 PresharedKey = \"two words\"`", "Executing: `set-key.bat PrivateKey=(redacted) PresharedKey = (redacted)`"},
+		{secrets, "Proxy password: swordfish; retrying", "Proxy password: (redacted); retrying"},
+		{secrets, "Endpoint 192.0.2.1:51820 at vpn.example.com", "Endpoint 192.0.2.1:51820 at vpn.example.com"},
+		{addresses, "Endpoint 192.0.2.1:51820 at vpn.example.com", "Endpoint (redacted):51820 at vpn.example.com"},
+		{addresses, "Endpoint [2001:db8::1]:51820 after 12:30:45", "Endpoint [(redacted)]:51820 after 12:30:45"},
+		{hostnames, "Resolved vpn.example.com for office-12 to ::ffff:192.0.2.1", "Resolved (redacted) for (redacted) to (redacted)"},
+	} {
+		if got := tt.redactor.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if tt.redactor.leaves([]byte(tt.in)) && tt.in != tt.want {
+			t.Errorf("leaves(%q) = true, but Redact changes it", tt.in)
+		}
+	}
+	if field := secrets.RedactField(Field{"peer.preshared_key", "short"}); field.Value != redacted {
+		t.Errorf("field = %+v", field)
+	}
+}
+
+func TestRingloggerRedaction(t *testing.T) {
+	rl, err := NewRinglogger(filepath.Join(t.TempDir(), "log.bin"), "TUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	rl.SetRedactor("office", NewRedactor(RedactOptions{Level: RedactAddresses}))
+	rl.Write([]byte("[office] Endpoint 192.0.2.1 with PrivateKey=secret"))
+	rl.Write([]byte("[home] Endpoint 192.0.2.1 with PrivateKey=secret"))
+	rl.WriteRecord(slog.LevelInfo, "office", "Handshake with 192.0.2.1", Field{"password", "secret"})
+	rl.SetRedactor("office", nil)
+	rl.Write([]byte("[office] Endpoint 192.0.2.1"))
+
+	lines, _ := rl.FollowFromCursor(CursorAll)
+	want := []string{
+		"[TUN] [office] Endpoint (redacted) with PrivateKey=(redacted)",
+		"[TUN] [home] Endpoint 192.0.2.1 with PrivateKey=(redacted)",
+		"[TUN] [office] Handshake with (redacted) password=(redacted)",
+		"[TUN] [office] Endpoint 192.0.2.1",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i].Line != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i].Line, want[i])
+		}
+	}
+
+	// Tunnels set their redactor whether or not the global log is open.
+	var global *Ringlogger
+	global.SetRedactor("office", NewRedactor(RedactOptions{Level: RedactAddresses}))
+}
+
+func TestRingloggerWriteWithoutRedaction(t *testing.T) {
+	rl, err := NewRinglogger(filepath.Join(t.TempDir(), "log.bin"), "TUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	line := []byte("[home] peer(xTIB…8Dg) - Sending keepalive packet")
+	if allocs := testing.AllocsPerRun(100, func() { rl.Write(line) }); allocs != 0 {
+		t.Errorf("writing a line without secrets allocated %v times", allocs)
+	}
+	rl.write([]byte("panic: PrivateKey=secret"), false)
+	lines, _ := rl.FollowFromCursor(CursorAll)
+	if last := lines[len(lines)-1].Line; last != "[TUN] panic: PrivateKey=secret" {
+		t.Errorf("unredacted line = %q", last)
+	}
+}
@@ -7,106 +7,275 @@ package ringlogger
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"encoding/binary"
+	"errors"
 	"io"
+	"log/slog"
+	"math/bits"
 	"os"
 	"runtime"
-	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
-
-	"golang.org/x/sys/windows"
 )
 
 const (
 	maxLogLineLength = 512
 	maxTagLength     = 5
-	maxLines         = 2048
-	magic            = 0xbadbabe
+	magicText        = 0xbadbabe     // version 1, with only text lines
+	magicRecords     = magicText + 1 // version 2, which adds structured records
+	magicSizes       = magicText + 2 // version 3, which records the number of lines in the header
+	magic            = magicText + 3 // version 4, which adds sequence numbers to lines
 )
 
+const (
+	DefaultLines = 2048
+	MinLines     = 256
+	MaxLines     = 1 << 18
+)
+
+var ErrInvalidLog = errors.New("Log is not a ring of a known version")
+
+// lineText holds the text of a line as words, so that it can be copied in and out atomically.
+type lineText [maxLogLineLength / 8]uint64
+
+func (text *lineText) bytes() []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(text)), maxLogLineLength)
+}
+
+// logLine is a slot of the ring, guarded by a sequence number in the manner of a seqlock. While the line
+// at index is being written, its sequence is 2*index+1, and once written, 2*index+2, so that readers can
+// tell whether a slot holds the line they are after, has been overwritten by a later lap of the ring, or
+// changed while they were copying it.
 type logLine struct {
-	timeNs int64
-	line   [maxLogLineLength]byte
+	sequence uint64
+	timeNs   int64
+	text     lineText
 }
 
-type logMem struct {
+// logHeader starts the mapping, followed by the lines.
+type logHeader struct {
 	magic     uint32
-	nextIndex uint32
-	lines     [maxLines]logLine
+	lineCount uint32
+	nextIndex uint64
 }
 
 type Ringlogger struct {
 	tag      string
 	file     *os.File
-	mapping  windows.Handle
-	log      *logMem
+	mapping  *fileMapping
+	header   *logHeader
+	lines    []logLine
 	readOnly bool
+
+	redactorsMutex sync.RWMutex
+	redactors      map[string]*Redactor
+}
+
+// ringLines rounds lines up to a power of two within MinLines and MaxLines.
+func ringLines(lines uint32) uint32 {
+	if lines <= MinLines {
+		return MinLines
+	}
+	if lines >= MaxLines {
+		return MaxLines
+	}
+	return 1 << bits.Len32(lines-1)
+}
+
+func mappingSize(lines uint32) int64 {
+	return int64(unsafe.Sizeof(logHeader{})) + int64(lines)*int64(unsafe.Sizeof(logLine{}))
 }
 
 func NewRinglogger(filename string, tag string) (*Ringlogger, error) {
+	return NewRingloggerWithLines(filename, tag, DefaultLines)
+}
+
+// NewRingloggerWithLines opens a ring of the given number of lines, rounded up to a power of two, or of the
+// number the ring already has if another process has it open.
+func NewRingloggerWithLines(filename string, tag string, lines uint32) (*Ringlogger, error) {
 	if len(tag) > maxTagLength {
-		return nil, windows.ERROR_LABEL_TOO_LONG
+		return nil, errTagTooLong
 	}
+	lines = ringLines(lines)
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
-	err = file.Truncate(int64(unsafe.Sizeof(logMem{})))
+	resized, err := resizeUnlessShared(file, mappingSize(lines))
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	mapping, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, windows.PAGE_READWRITE, 0, 0, nil)
-	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
+	if !resized {
+		lines = 0
+	}
+	mapping, err := mapFile(file, true)
+	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	rl, err := newRingloggerFromMappingHandle(mapping, tag, windows.FILE_MAP_WRITE)
+	rl, err := newRingloggerFromMapping(mapping, tag, true, lines)
 	if err != nil {
+		mapping.close()
+		file.Close()
 		return nil, err
 	}
 	rl.file = file
 	return rl, nil
 }
 
-func NewRingloggerFromInheritedMappingHandle(handleStr string, tag string) (*Ringlogger, error) {
-	handle, err := strconv.ParseUint(handleStr, 10, 64)
+// OpenRinglogger opens a ring for reading, such as for exporting it with ExportTo or Follow.
+func OpenRinglogger(filename string) (*Ringlogger, error) {
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	return newRingloggerFromMappingHandle(windows.Handle(handle), tag, windows.FILE_MAP_READ)
-}
-
-func newRingloggerFromMappingHandle(mappingHandle windows.Handle, tag string, access uint32) (*Ringlogger, error) {
-	view, err := windows.MapViewOfFile(mappingHandle, access, 0, 0, 0)
+	mapping, err := mapFile(file, false)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
+	rl, err := newRingloggerFromMapping(mapping, "DMP", false, 0)
 	if err != nil {
-		windows.CloseHandle(mappingHandle)
+		mapping.close()
+		file.Close()
 		return nil, err
 	}
-	log := (*logMem)(unsafe.Pointer(view))
-	if log.magic != magic {
-		bytes := (*[unsafe.Sizeof(logMem{})]byte)(unsafe.Pointer(log))
-		for i := range bytes {
-			bytes[i] = 0
-		}
-		log.magic = magic
-		windows.FlushViewOfFile(view, uintptr(len(bytes)))
-	}
+	rl.file = file
+	return rl, nil
+}
 
+// newRingloggerFromMapping uses a mapped ring, which for writers is reset to the given number of lines if it
+// has another number or an older version, unless lines is 0, in which case the ring keeps its number if valid.
+// Readers of older versions get a copy of the ring, converted to the current version.
+func newRingloggerFromMapping(mapping *fileMapping, tag string, writable bool, lines uint32) (*Ringlogger, error) {
+	data := mapping.data
+	if int64(len(data)) < mappingSize(0) {
+		return nil, ErrInvalidLog
+	}
 	rl := &Ringlogger{
 		tag:      tag,
-		mapping:  mappingHandle,
-		log:      log,
-		readOnly: access&windows.FILE_MAP_WRITE == 0,
+		mapping:  mapping,
+		header:   (*logHeader)(unsafe.Pointer(&data[0])),
+		readOnly: !writable,
+	}
+	count := rl.header.lineCount
+	switch atomic.LoadUint32(&rl.header.magic) {
+	case magic:
+		if count < MinLines || count > MaxLines || count&(count-1) != 0 || mappingSize(count) > int64(len(data)) || (lines != 0 && count != lines) {
+			count = 0
+		}
+	case magicText, magicRecords, magicSizes:
+		count = 0
+		if !writable {
+			legacy, ok := convertLegacyLines(data)
+			if ok {
+				rl.header = &logHeader{magic: magic, lineCount: uint32(len(legacy)), nextIndex: uint64(len(legacy))}
+				rl.lines = legacy
+				runtime.SetFinalizer(rl, (*Ringlogger).Close)
+				return rl, nil
+			}
+		}
+	default:
+		count = 0
+	}
+	if count == 0 {
+		if !writable {
+			return nil, ErrInvalidLog
+		}
+		if lines == 0 {
+			lines = MinLines
+			for lines < MaxLines && mappingSize(lines*2) <= int64(len(data)) {
+				lines *= 2
+			}
+			if mappingSize(lines) > int64(len(data)) {
+				return nil, ErrInvalidLog
+			}
+		}
+		clear(data[:mappingSize(lines)])
+		rl.header.lineCount = lines
+		atomic.StoreUint32(&rl.header.magic, magic)
+		mapping.flush()
+		count = lines
 	}
+	rl.lines = unsafe.Slice((*logLine)(unsafe.Pointer(&data[unsafe.Sizeof(logHeader{})])), count)
 	runtime.SetFinalizer(rl, (*Ringlogger).Close)
 	return rl, nil
 }
 
+// convertLegacyLines copies the lines of a ring of a version before sequence numbers, whose lines were a
+// timestamp followed by text, into lines of the current version, from the oldest to the newest.
+func convertLegacyLines(data []byte) ([]logLine, bool) {
+	const legacyLineLength = 8 + maxLogLineLength
+	headerLength, count := 8, uint32(DefaultLines)
+	if binary.NativeEndian.Uint32(data) == magicSizes {
+		headerLength, count = 16, binary.NativeEndian.Uint32(data[8:])
+		if count < MinLines || count > MaxLines || count&(count-1) != 0 {
+			return nil, false
+		}
+	}
+	if headerLength+int(count)*legacyLineLength > len(data) {
+		return nil, false
+	}
+	next := binary.NativeEndian.Uint32(data[4:])
+	lines := make([]logLine, count)
+	for l := uint32(0); l < count; l++ {
+		// Empty lines become written ones without text, which readers skip.
+		lines[l].sequence = 2*uint64(l) + 2
+		legacy := data[headerLength+int((next+l)%count)*legacyLineLength:][:legacyLineLength]
+		timeNs := int64(binary.NativeEndian.Uint64(legacy))
+		if timeNs == 0 {
+			continue
+		}
+		lines[l].timeNs = timeNs
+		copy(lines[l].text.bytes(), legacy[8:])
+	}
+	return lines, true
+}
+
+// Lines returns the number of lines in the ring.
+func (rl *Ringlogger) Lines() int {
+	return len(rl.lines)
+}
+
+// maxWriterSpins is how many times a writer yields waiting for the writer of an earlier lap of the ring to
+// finish with a line before taking it over, as that writer may have exited while writing it.
+const maxWriterSpins = 1000
+
+// writeLine writes the line at index, unless the writer of a later lap of the ring has taken it already.
+func (rl *Ringlogger) writeLine(index uint64, timeNs int64, text *lineText) {
+	line := &rl.lines[index%uint64(len(rl.lines))]
+	writing := 2*index + 1
+	for spins := 0; ; spins++ {
+		sequence := atomic.LoadUint64(&line.sequence)
+		if sequence >= writing {
+			return
+		}
+		if sequence&1 != 0 && spins < maxWriterSpins {
+			runtime.Gosched()
+			continue
+		}
+		if atomic.CompareAndSwapUint64(&line.sequence, sequence, writing) {
+			break
+		}
+	}
+	atomic.StoreInt64(&line.timeNs, timeNs)
+	for i := range text {
+		atomic.StoreUint64(&line.text[i], text[i])
+	}
+	atomic.CompareAndSwapUint64(&line.sequence, writing, writing+1)
+}
+
 func (rl *Ringlogger) Write(p []byte) (n int, err error) {
+	return rl.write(p, true)
+}
+
+// write writes a line of text, redacting it unless told not to, as when writing what the runtime prints when
+// it crashes, which must not allocate or take locks.
+func (rl *Ringlogger) write(p []byte, redact bool) (n int, err error) {
 	if rl.readOnly {
 		return 0, io.ErrShortWrite
 	}
@@ -115,136 +284,287 @@ func (rl *Ringlogger) Write(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return ret, nil
 	}
+	if rl.header == nil {
+		return 0, io.EOF
+	}
 
-	// Race: This isn't synchronized with the fetch_add below, so items might be slightly out of order.
-	ts := time.Now().UnixNano()
+	if redact {
+		if redactor := rl.redactorOfLine(p); !redactor.leaves(p) {
+			p = []byte(redactor.Redact(string(p)))
+		}
+	}
+	var text lineText
+	line := text.bytes()
+	if 3+len(p)+len(rl.tag) > maxLogLineLength-1 {
+		p = p[:maxLogLineLength-1-3-len(rl.tag)]
+	}
+	line[0] = '['
+	copy(line[1:], rl.tag)
+	line[1+len(rl.tag)] = ']'
+	line[2+len(rl.tag)] = ' '
+	copy(line[3+len(rl.tag):], p)
 
-	if rl.log == nil {
-		return 0, io.EOF
+	index := atomic.AddUint64(&rl.header.nextIndex, 1) - 1
+	rl.writeLine(index, time.Now().UnixNano(), &text)
+	return ret, nil
+}
+
+// WriteRecord writes a structured record, which takes up several lines if it does not fit in one. Messages
+// too long for maxRecordLines lines are truncated, and fields that do not fit after them are left out.
+func (rl *Ringlogger) WriteRecord(level slog.Level, tunnel, message string, fields ...Field) error {
+	return rl.writeRecord(time.Now(), level, tunnel, message, fields)
+}
+
+func (rl *Ringlogger) writeRecord(stamp time.Time, level slog.Level, tunnel, message string, fields []Field) error {
+	if rl.readOnly {
+		return io.ErrShortWrite
+	}
+	if rl.header == nil {
+		return io.EOF
 	}
+	redactor := rl.redactor(tunnel)
+	message = redactor.Redact(message)
+	redactedFields := make([]Field, len(fields))
+	for i := range fields {
+		redactedFields[i] = redactor.RedactField(fields[i])
+	}
+	payload := encodeRecord(rl.tag, tunnel, message, redactedFields)
+	count := (len(payload) + maxLinePayload - 1) / maxLinePayload
 
-	// Race: More than maxLines writers and this will clash.
-	index := atomic.AddUint32(&rl.log.nextIndex, 1) - 1
-	line := &rl.log.lines[index%maxLines]
+	// Reserving all of the lines at once keeps them together, so that readers find the continuations right
+	// after the first line.
+	first := atomic.AddUint64(&rl.header.nextIndex, uint64(count)) - uint64(count)
+	for i := 0; i < count; i++ {
+		var text lineText
+		line := text.bytes()
+		chunk := payload[i*maxLinePayload : min((i+1)*maxLinePayload, len(payload))]
+		line[0] = continuationMarker
+		line[1] = storedLevel(level)
+		line[2] = byte(i)
+		if i == 0 {
+			line[0] = recordMarker
+			line[2] = byte(count)
+		}
+		binary.LittleEndian.PutUint16(line[3:], uint16(len(chunk)))
+		copy(line[recordHeaderLength:], chunk)
+		rl.writeLine(first+uint64(i), stamp.UnixNano(), &text)
+	}
+	return nil
+}
+
+type lineState int
 
-	// Race: Before this line executes, we'll display old data after new data.
-	atomic.StoreInt64(&line.timeNs, 0)
-	for i := range line.line {
-		line.line[i] = 0
+const (
+	lineComplete lineState = iota
+	linePending            // reserved by a writer that has yet to finish writing it
+	lineGone               // overwritten by a later lap of the ring, or not a line to read on its own
+)
+
+// loadLine copies the line at index, checking the sequence number before and after.
+func (rl *Ringlogger) loadLine(index uint64, out *logLine) lineState {
+	line := &rl.lines[index%uint64(len(rl.lines))]
+	written := 2*index + 2
+	sequence := atomic.LoadUint64(&line.sequence)
+	if sequence < written {
+		return linePending
+	}
+	if sequence > written {
+		return lineGone
 	}
+	out.timeNs = atomic.LoadInt64(&line.timeNs)
+	for i := range line.text {
+		out.text[i] = atomic.LoadUint64(&line.text[i])
+	}
+	if atomic.LoadUint64(&line.sequence) != sequence {
+		return lineGone
+	}
+	out.sequence = sequence
+	return lineComplete
+}
 
-	textLen := 3 + len(p) + len(rl.tag)
-	if textLen > maxLogLineLength-1 {
-		p = p[:maxLogLineLength-1-3-len(rl.tag)]
-		textLen = maxLogLineLength - 1
+// readLine reads the text line or record starting at index, returning the number of lines it takes up.
+func (rl *Ringlogger) readLine(index uint64) (followLine FollowLine, lines uint64, state lineState) {
+	var line logLine
+	state = rl.loadLine(index, &line)
+	if state != lineComplete {
+		return FollowLine{}, 1, state
+	}
+	text := line.text.bytes()
+	stamp := time.Unix(0, line.timeNs)
+	switch text[0] {
+	case recordMarker:
+	case continuationMarker:
+		return FollowLine{}, 1, lineGone
+	default:
+		end := bytes.IndexByte(text, 0)
+		if end < 1 {
+			return FollowLine{}, 1, lineGone
+		}
+		return FollowLine{Line: string(text[:end]), Stamp: stamp}, 1, lineComplete
 	}
-	line.line[textLen] = 0
-	line.line[0] = 0 // Null out the beginning and only let it extend after the other writes have completed
-	copy(line.line[1:], rl.tag)
-	line.line[1+len(rl.tag)] = ']'
-	line.line[2+len(rl.tag)] = ' '
-	copy(line.line[3+len(rl.tag):], p[:])
-	line.line[0] = '['
-	atomic.StoreInt64(&line.timeNs, ts)
 
-	return ret, nil
+	count := uint64(text[2])
+	if count == 0 || count > maxRecordLines {
+		return FollowLine{}, 1, lineGone
+	}
+	level := slog.Level(int8(text[1]))
+	var payload []byte
+	for i := uint64(0); i < count; i++ {
+		if i > 0 {
+			state = rl.loadLine(index+i, &line)
+			if state != lineComplete {
+				return FollowLine{}, 1, state
+			}
+			if text[0] != continuationMarker || uint64(text[2]) != i {
+				return FollowLine{}, 1, lineGone
+			}
+		}
+		length := int(binary.LittleEndian.Uint16(text[3:]))
+		if length > maxLinePayload {
+			return FollowLine{}, 1, lineGone
+		}
+		payload = append(payload, text[recordHeaderLength:recordHeaderLength+length]...)
+	}
+	record, ok := decodeRecord(payload)
+	if !ok {
+		return FollowLine{}, count, lineGone
+	}
+	record.Time = stamp
+	record.Level = level
+	return FollowLine{Line: record.String(), Stamp: stamp, Record: &record}, count, lineComplete
 }
 
-func (rl *Ringlogger) WriteTo(out io.Writer) (n int64, err error) {
-	if rl.log == nil {
-		return 0, io.EOF
+// maxPendingLines is how far behind the newest line a line may be while its writer is still writing it.
+// Readers wait for such lines, but skip older ones, as their writers may have exited while writing them.
+const maxPendingLines = 64
+
+// readLines reads the lines from the one at cursor, or the oldest still in the ring if that is later, to
+// the newest, returning the index of the line to continue from. As writers take the time after reserving
+// their line, lines may be a little out of order by time, so the times are made to never go backwards.
+func (rl *Ringlogger) readLines(cursor uint64) (followLines []FollowLine, nextCursor uint64) {
+	next := atomic.LoadUint64(&rl.header.nextIndex)
+	oldest := uint64(0)
+	if next > uint64(len(rl.lines)) {
+		oldest = next - uint64(len(rl.lines))
 	}
-	log := *rl.log
-	i := log.nextIndex
-	for l := uint32(0); l < maxLines; l++ {
-		line := &log.lines[(i+l)%maxLines]
-		if line.timeNs == 0 {
-			continue
+	if cursor < oldest || cursor > next {
+		cursor = oldest
+	}
+	var latest time.Time
+	for cursor < next {
+		line, lines, state := rl.readLine(cursor)
+		if state == linePending && next-cursor <= maxPendingLines {
+			break
 		}
-		index := bytes.IndexByte(line.line[:], 0)
-		if index < 1 {
-			continue
+		if state == lineComplete {
+			if line.Stamp.Before(latest) {
+				line.Stamp = latest
+				if line.Record != nil {
+					line.Record.Time = latest
+				}
+			}
+			latest = line.Stamp
+			followLines = append(followLines, line)
 		}
-		var bytes int
-		bytes, err = fmt.Fprintf(out, "%s: %s\n", time.Unix(0, line.timeNs).Format("2006-01-02 15:04:05.000000"), line.line[:index])
+		cursor += lines
+	}
+	return followLines, cursor
+}
+
+func (rl *Ringlogger) WriteTo(out io.Writer) (n int64, err error) {
+	if rl.header == nil {
+		return 0, io.EOF
+	}
+	lines, _ := rl.readLines(0)
+	exporter := NewTextExporter(&countingWriter{out: out, n: &n})
+	for i := range lines {
+		err = exporter.Export(&lines[i])
 		if err != nil {
 			return
 		}
-		n += int64(bytes)
 	}
 	return
 }
 
-const CursorAll = ^uint32(0)
+type countingWriter struct {
+	out io.Writer
+	n   *int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	n, err := writer.out.Write(p)
+	*writer.n += int64(n)
+	return n, err
+}
+
+// CursorAll starts following from the oldest line in the ring.
+const CursorAll = ^uint64(0)
 
 type FollowLine struct {
-	Line  string
-	Stamp time.Time
+	Line   string
+	Stamp  time.Time
+	Record *Record // nil for text lines
 }
 
-func (rl *Ringlogger) FollowFromCursor(cursor uint32) (followLines []FollowLine, nextCursor uint32) {
-	followLines = make([]FollowLine, 0, maxLines)
-	nextCursor = cursor
-
-	if rl.log == nil {
-		return
+// FollowFromCursor returns the lines from cursor, which is a value it returned before or CursorAll, to the
+// newest, along with the cursor to pass next time for the lines written after them.
+func (rl *Ringlogger) FollowFromCursor(cursor uint64) (followLines []FollowLine, nextCursor uint64) {
+	if rl.header == nil {
+		return nil, cursor
 	}
-	log := *rl.log
+	return rl.readLines(cursor)
+}
 
-	i := cursor
-	if cursor == CursorAll {
-		i = log.nextIndex
+// ExportTo exports the lines in the ring, from the oldest to the newest.
+func (rl *Ringlogger) ExportTo(exporter Exporter) error {
+	lines, _ := rl.FollowFromCursor(CursorAll)
+	for i := range lines {
+		err := exporter.Export(&lines[i])
+		if err != nil {
+			return err
+		}
 	}
+	return flushExporter(exporter)
+}
 
-	for l := 0; l < maxLines; l++ {
-		line := &log.lines[i%maxLines]
-		if cursor != CursorAll && i%maxLines == log.nextIndex%maxLines {
-			break
+// Follow exports the lines in the ring and then those written after them, checking for new ones at each
+// interval, until the context is done or exporting fails.
+func (rl *Ringlogger) Follow(ctx context.Context, exporter Exporter, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	cursor := CursorAll
+	for {
+		var lines []FollowLine
+		lines, cursor = rl.FollowFromCursor(cursor)
+		for i := range lines {
+			err := exporter.Export(&lines[i])
+			if err != nil {
+				return err
+			}
 		}
-		if line.timeNs == 0 {
-			if cursor == CursorAll {
-				i++
-				continue
-			} else {
-				break
+		if len(lines) > 0 {
+			err := flushExporter(exporter)
+			if err != nil {
+				return err
 			}
 		}
-		index := bytes.IndexByte(line.line[:], 0)
-		if index > 0 {
-			followLines = append(followLines, FollowLine{string(line.line[:index]), time.Unix(0, line.timeNs)})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
-		i++
-		nextCursor = i % maxLines
 	}
-	return
 }
 
 func (rl *Ringlogger) Close() error {
+	if rl.mapping != nil {
+		rl.mapping.close()
+		rl.mapping = nil
+	}
+	rl.header, rl.lines = nil, nil
 	if rl.file != nil {
 		rl.file.Close()
 		rl.file = nil
 	}
-	if rl.log != nil {
-		windows.UnmapViewOfFile((uintptr)(unsafe.Pointer(rl.log)))
-		rl.log = nil
-	}
-	if rl.mapping != 0 {
-		windows.CloseHandle(rl.mapping)
-		rl.mapping = 0
-	}
 	return nil
 }
-
-func (rl *Ringlogger) ExportInheritableMappingHandle() (handleToClose windows.Handle, err error) {
-	handleToClose, err = windows.CreateFileMapping(windows.Handle(rl.file.Fd()), nil, windows.PAGE_READONLY, 0, 0, nil)
-	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
-		return
-	}
-	err = windows.SetHandleInformation(handleToClose, windows.HANDLE_FLAG_INHERIT, windows.HANDLE_FLAG_INHERIT)
-	if err != nil {
-		windows.CloseHandle(handleToClose)
-		handleToClose = 0
-		return
-	}
-	return
-}
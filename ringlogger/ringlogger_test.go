@@ -0,0 +1,92 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRingLines(t *testing.T) {
+	for lines, want := range map[uint32]uint32{0: MinLines, 1: MinLines, MinLines: MinLines, 300: 512, 2048: 2048, 2049: 4096, MaxLines + 1: MaxLines} {
+		if got := ringLines(lines); got != want {
+			t.Errorf("ringLines(%d) = %d, want %d", lines, got, want)
+		}
+	}
+}
+
+func openReader(t *testing.T, path string) *Ringlogger {
+	t.Helper()
+	rl, err := OpenRinglogger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rl
+}
+
+func TestRingSizeInHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.bin")
+	writer, err := NewRingloggerWithLines(path, "ONE", 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if writer.Lines() != 8192 {
+		t.Errorf("%d lines, want 8192", writer.Lines())
+	}
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(writer, "Line %d", i)
+	}
+
+	// Another writer keeps the size of the ring that the first has mapped, rather than resetting it.
+	other, err := NewRinglogger(path, "TWO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	fmt.Fprint(other, "From the other writer")
+
+	reader := openReader(t, path)
+	defer reader.Close()
+	lines, _ := reader.FollowFromCursor(CursorAll)
+	if reader.Lines() != 8192 || len(lines) != 3001 {
+		t.Fatalf("reader has %d lines of %d, want 3001 of 8192", len(lines), reader.Lines())
+	}
+	if lines[0].Line != "[ONE] Line 0" || lines[3000].Line != "[TWO] From the other writer" {
+		t.Errorf("lines from %q to %q", lines[0].Line, lines[3000].Line)
+	}
+}
+
+func TestLegacyRing(t *testing.T) {
+	const legacyHeaderLength = 8
+	path := filepath.Join(t.TempDir(), "log.bin")
+	legacy := make([]byte, legacyHeaderLength+DefaultLines*(8+maxLogLineLength))
+	copy(legacy, []byte{0xbe, 0xba, 0xad, 0x0b, 1, 0, 0, 0})
+	legacy[legacyHeaderLength] = 1 // a timestamp of 1ns
+	copy(legacy[legacyHeaderLength+8:], "[OLD] From version 1")
+	if err := os.WriteFile(path, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := openReader(t, path)
+	lines, _ := reader.FollowFromCursor(CursorAll)
+	if reader.Lines() != DefaultLines || len(lines) != 1 || lines[0].Line != "[OLD] From version 1" {
+		t.Errorf("lines of version 1 = %+v", lines)
+	}
+	reader.Close()
+
+	writer, err := NewRinglogger(path, "NEW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	lines, _ = writer.FollowFromCursor(CursorAll)
+	if writer.Lines() != DefaultLines || len(lines) != 0 {
+		t.Errorf("writer has %d lines of %d after upgrading", len(lines), writer.Lines())
+	}
+}
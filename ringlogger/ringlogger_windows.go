@@ -0,0 +1,43 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"strconv"
+
+	"golang.org/x/sys/windows"
+)
+
+func NewRingloggerFromInheritedMappingHandle(handleStr string, tag string) (*Ringlogger, error) {
+	handle, err := strconv.ParseUint(handleStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := mapHandle(windows.Handle(handle), windows.FILE_MAP_READ)
+	if err != nil {
+		return nil, err
+	}
+	rl, err := newRingloggerFromMapping(mapping, tag, false, 0)
+	if err != nil {
+		mapping.close()
+		return nil, err
+	}
+	return rl, nil
+}
+
+func (rl *Ringlogger) ExportInheritableMappingHandle() (handleToClose windows.Handle, err error) {
+	handleToClose, err = windows.CreateFileMapping(windows.Handle(rl.file.Fd()), nil, windows.PAGE_READONLY, 0, 0, nil)
+	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
+		return
+	}
+	err = windows.SetHandleInformation(handleToClose, windows.HANDLE_FLAG_INHERIT, windows.HANDLE_FLAG_INHERIT)
+	if err != nil {
+		windows.CloseHandle(handleToClose)
+		handleToClose = 0
+		return
+	}
+	return
+}
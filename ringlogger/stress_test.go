@@ -0,0 +1,222 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// stressEntry writes the seq'th entry of a writer, alternating between text lines and records of several
+// lines, with a checksum of its contents so that readers can tell torn lines apart.
+func stressEntry(rl *Ringlogger, seq int) {
+	padding := strings.Repeat(string(rune('a'+seq%26)), seq*37%1400)
+	sum := crc32.ChecksumIEEE([]byte(strconv.Itoa(seq) + padding))
+	if seq%3 == 0 {
+		rl.WriteRecord(slog.LevelInfo, "", padding, Field{"seq", strconv.Itoa(seq)}, Field{"sum", strconv.FormatUint(uint64(sum), 16)})
+		return
+	}
+	fmt.Fprintf(rl, "%d %x %s", seq, sum, padding[:min(len(padding), 400)])
+}
+
+// parseStressEntry returns the writer and sequence of an entry written by stressEntry.
+func parseStressEntry(line *FollowLine) (writer string, seq int, err error) {
+	var padding, sum string
+	if line.Record != nil {
+		if len(line.Record.Fields) != 2 {
+			return "", 0, fmt.Errorf("record has fields %v", line.Record.Fields)
+		}
+		writer, padding = line.Record.Tag, line.Record.Message
+		seq, err = strconv.Atoi(line.Record.Fields[0].Value)
+		sum = line.Record.Fields[1].Value
+	} else {
+		parts := strings.SplitN(line.Line, " ", 4)
+		if len(parts) < 3 {
+			return "", 0, fmt.Errorf("text line %q is too short", line.Line)
+		}
+		writer, sum = strings.Trim(parts[0], "[]"), parts[2]
+		if len(parts) == 4 {
+			padding = parts[3]
+		}
+		seq, err = strconv.Atoi(parts[1])
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if seq%3 != 0 {
+		padding += strings.Repeat(string(rune('a'+seq%26)), seq*37%1400-len(padding))
+	}
+	if want := strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(strconv.Itoa(seq)+padding))), 16); sum != want {
+		return "", 0, fmt.Errorf("entry %d of %s has checksum %s, want %s", seq, writer, sum, want)
+	}
+	return writer, seq, nil
+}
+
+// checkStressLines checks that lines are intact, in order for each writer and by time, and not repeated,
+// returning the last sequence seen of each writer.
+func checkStressLines(lines []FollowLine, last map[string]int) error {
+	for i := range lines {
+		writer, seq, err := parseStressEntry(&lines[i])
+		if err != nil {
+			return err
+		}
+		if previous, ok := last[writer]; ok && seq <= previous {
+			return fmt.Errorf("entry %d of %s after entry %d", seq, writer, previous)
+		}
+		last[writer] = seq
+		if i > 0 && lines[i].Stamp.Before(lines[i-1].Stamp) {
+			return fmt.Errorf("entry %d of %s goes back in time", seq, writer)
+		}
+	}
+	return nil
+}
+
+func runStressWriters(t *testing.T, path string, writers, entries int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		rl, err := NewRinglogger(path, "W"+strconv.Itoa(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer rl.Close()
+			for seq := 0; seq < entries; seq++ {
+				stressEntry(rl, seq)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	writers, entries := 4, 2000
+	if testing.Short() {
+		entries = 300
+	}
+	path := filepath.Join(t.TempDir(), "log.bin")
+	// The ring is big enough for everything written, so none of it may be lost.
+	first, err := NewRingloggerWithLines(path, "W0", 32768)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	reader := openReader(t, path)
+	defer reader.Close()
+	var done atomic.Bool
+	followed := make(chan error, 1)
+	go func() {
+		last := make(map[string]int)
+		cursor := CursorAll
+		for stop := false; !stop; {
+			stop = done.Load()
+			var lines []FollowLine
+			lines, cursor = reader.FollowFromCursor(cursor)
+			if err := checkStressLines(lines, last); err != nil {
+				followed <- err
+				return
+			}
+		}
+		followed <- nil
+	}()
+	runStressWriters(t, path, writers, entries)
+	done.Store(true)
+	if err := <-followed; err != nil {
+		t.Errorf("follower: %v", err)
+	}
+
+	lines, _ := reader.FollowFromCursor(CursorAll)
+	last := make(map[string]int)
+	if err := checkStressLines(lines, last); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != writers*entries {
+		t.Errorf("read %d entries, want %d", len(lines), writers*entries)
+	}
+	for w := 0; w < writers; w++ {
+		if seq, ok := last["W"+strconv.Itoa(w)]; !ok || seq != entries-1 {
+			t.Errorf("last entry of W%d is %d, want %d", w, seq, entries-1)
+		}
+	}
+}
+
+func TestConcurrentWraparound(t *testing.T) {
+	writers, entries := 4, 5000
+	if testing.Short() {
+		entries = 1000
+	}
+	path := filepath.Join(t.TempDir(), "log.bin")
+	first, err := NewRingloggerWithLines(path, "W0", MinLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// With the ring overwritten many times over while being read, readers may miss lines, but must never
+	// return a torn one, repeat one or return them out of order.
+	reader := openReader(t, path)
+	defer reader.Close()
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		last := make(map[string]int)
+		cursor := CursorAll
+		for !done.Load() {
+			var lines []FollowLine
+			lines, cursor = reader.FollowFromCursor(cursor)
+			if err := checkStressLines(lines, last); err != nil {
+				errs <- fmt.Errorf("follower: %w", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for !done.Load() {
+			var out bytes.Buffer
+			reader.WriteTo(&out)
+			for _, text := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+				if len(text) == 0 {
+					continue
+				}
+				_, line, ok := strings.Cut(text, ": ")
+				if !ok {
+					errs <- fmt.Errorf("dump has line %q", text)
+					return
+				}
+				// Records are dumped as text with their fields after, which is checked by the follower.
+				if strings.Contains(line, " seq=") {
+					continue
+				}
+				if _, _, err := parseStressEntry(&FollowLine{Line: line}); err != nil {
+					errs <- fmt.Errorf("dump: %w", err)
+					return
+				}
+			}
+		}
+	}()
+	runStressWriters(t, path, writers, entries)
+	done.Store(true)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"os"
 	"runtime"
@@ -26,6 +27,7 @@ import (
 	"github.com/amnezia-vpn/amneziawg-windows/v3/elevate"
 	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
 	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel"
 	"github.com/amnezia-vpn/amneziawg-windows/v3/version"
 )
 
@@ -42,8 +44,19 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 	var watcher *interfaceWatcher
 	var nativeTun *tun.NativeTun
 	var config *conf.Config
+	var archiver *ringlogger.Archiver
 	var err error
 	serviceError := services.ErrorSuccess
+	// The configuration is not in the store, but its failure and startup trace are kept where it would be, for the
+	// status API to find.
+	configPath, _ := (&conf.Config{Name: service.TunnelName}).Path()
+	startup := tunnel.NewStartupTrace(configPath)
+	var stage string
+	enterStage := func(name string) {
+		stage = name
+		startup.Stage(name)
+	}
+	enterStage("open-log")
 
 	defer func() {
 		svcSpecificEC, exitCode = services.DetermineErrorCode(err, serviceError)
@@ -51,6 +64,11 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		if logErr != nil {
 			log.Println(logErr)
 		}
+		startup.Finish(logErr)
+		failure := services.NewFailure(stage, serviceError, err)
+		if saveErr := services.SaveFailure(configPath, failure); saveErr != nil {
+			log.Printf("Unable to save failure: %v", saveErr)
+		}
 		changes <- svc.Status{State: svc.StopPending}
 
 		stopIt := make(chan bool, 1)
@@ -102,6 +120,9 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		}
 		stopIt <- true
 		log.Println("Shutting down")
+		if archiver != nil {
+			archiver.Close()
+		}
 	}()
 
 	err = ringlogger.InitGlobalLogger("TUN")
@@ -109,7 +130,13 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		serviceError = services.ErrorRingloggerOpen
 		return
 	}
+	archiver, err = ringlogger.StartGlobalArchiver()
+	if err != nil {
+		log.Printf("Warning: unable to archive log: %v", err)
+		err = nil
+	}
 
+	enterStage("load-configuration")
 	config, err = conf.FromWgQuickWithUnknownEncoding(service.ConfString, service.TunnelName)
 	if err != nil {
 		serviceError = services.ErrorLoadConfiguration
@@ -118,6 +145,7 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 	config.DeduplicateNetworkEntries()
 
 	log.SetPrefix(fmt.Sprintf("[%s] ", config.Name))
+	ringlogger.Global.SetRedactor(config.Name, tunnel.LogRedactor(config))
 
 	log.Println("Starting", version.UserAgent())
 
@@ -134,6 +162,7 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		m.Disconnect()
 	}
 
+	enterStage("watch-interfaces")
 	log.Println("Watching network interfaces")
 	watcher, err = watchInterface()
 	if err != nil {
@@ -141,13 +170,19 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		return
 	}
 
+	enterStage("resolve-dns")
 	log.Println("Resolving DNS names")
+	conf.ResolveAttempted = func(name string, start time.Time, err error) {
+		startup.Attempt(start, err, "host", name)
+	}
 	uapiConf, err := config.ToUAPI()
+	conf.ResolveAttempted = nil
 	if err != nil {
 		serviceError = services.ErrorDNSLookup
 		return
 	}
 
+	enterStage("create-wintun")
 	log.Println("Creating Wintun interface")
 	var wintun tun.Device
 	for i := 0; i < 5; i++ {
@@ -155,7 +190,9 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 			time.Sleep(time.Second)
 			log.Printf("Retrying Wintun creation after failure because system just booted (T+%v): %v", windows.DurationSinceBoot(), err)
 		}
+		start := time.Now()
 		wintun, err = tun.CreateTUNWithRequestedGUID(config.Name, deterministicGUID(config), 0)
+		startup.Attempt(start, err)
 		if err == nil || windows.DurationSinceBoot() > time.Minute*4 {
 			break
 		}
@@ -172,18 +209,21 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		log.Printf("Using Wintun/%d.%d", (wintunVersion>>16)&0xffff, wintunVersion&0xffff)
 	}
 
+	enterStage("pre-up")
 	err = runScriptCommand(config.Interface.PreUp, config.Name)
 	if err != nil {
 		serviceError = services.ErrorRunScript
 		return
 	}
 
+	enterStage("enable-firewall")
 	err = enableFirewall(config, nativeTun)
 	if err != nil {
 		serviceError = services.ErrorFirewall
 		return
 	}
 
+	enterStage("drop-privileges")
 	log.Println("Dropping privileges")
 	err = elevate.DropAllPrivileges(true)
 	if err != nil {
@@ -191,10 +231,14 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		return
 	}
 
+	enterStage("create-device")
 	log.Println("Creating interface instance")
 	bind := conn.NewDefaultBind()
-	dev = device.NewDevice(wintun, bind, &device.Logger{log.Printf, log.Printf})
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(tunnel.LogLevelOf(config))
+	dev = device.NewDevice(wintun, bind, tunnel.DeviceLogger(tunnel.NewTunnelLogger(config.Name, logLevel)))
 
+	enterStage("configure-device")
 	log.Println("Setting interface configuration")
 	uapi, err = ipc.UAPIListen(config.Name)
 	if err != nil {
@@ -207,6 +251,7 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		return
 	}
 
+	enterStage("bring-up-peers")
 	log.Println("Bringing peers up")
 	dev.Up()
 
@@ -219,10 +264,11 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 			if err != nil {
 				continue
 			}
-			go dev.IpcHandle(conn)
+			go tunnel.HandleUAPI(dev, conn, logLevel)
 		}
 	}()
 
+	enterStage("post-up")
 	err = runScriptCommand(config.Interface.PostUp, config.Name)
 	if err != nil {
 		serviceError = services.ErrorRunScript
@@ -231,6 +277,8 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 
 	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
 	log.Println("Startup complete")
+	startup.Finish(nil)
+	stage = "running"
 
 	for {
 		select {
@@ -31,6 +31,8 @@ const (
 	ErrorDropPrivileges
 	ErrorRunScript
 	ErrorWin32
+	ErrorHandshakeTimeout
+	ErrorProxyListen
 )
 
 func (e Error) Error() string {
@@ -67,6 +69,10 @@ func (e Error) Error() string {
 		return "An error occurred while running a configuration script command"
 	case ErrorWin32:
 		return "An internal Windows error has occurred"
+	case ErrorHandshakeTimeout:
+		return "Peers stopped responding to handshakes"
+	case ErrorProxyListen:
+		return "Unable to listen for local proxy clients"
 	default:
 		return "An unknown error has occurred"
 	}
@@ -0,0 +1,198 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/l18n"
+)
+
+var errorIDs = [...]string{
+	ErrorSuccess:                    "success",
+	ErrorRingloggerOpen:             "ringlogger-open",
+	ErrorLoadConfiguration:          "load-configuration",
+	ErrorCreateWintun:               "create-wintun",
+	ErrorUAPIListen:                 "uapi-listen",
+	ErrorDNSLookup:                  "dns-lookup",
+	ErrorFirewall:                   "firewall",
+	ErrorDeviceSetConfig:            "device-set-config",
+	ErrorBindSocketsToDefaultRoutes: "bind-sockets-to-default-routes",
+	ErrorSetNetConfig:               "set-net-config",
+	ErrorDetermineExecutablePath:    "determine-executable-path",
+	ErrorTrackTunnels:               "track-tunnels",
+	ErrorEnumerateSessions:          "enumerate-sessions",
+	ErrorDropPrivileges:             "drop-privileges",
+	ErrorRunScript:                  "run-script",
+	ErrorWin32:                      "win32",
+	ErrorHandshakeTimeout:           "handshake-timeout",
+	ErrorProxyListen:                "proxy-listen",
+}
+
+// ID returns a stable identifier of the error for programs to match on, which unlike its message is never
+// reworded.
+func (e Error) ID() string {
+	if int(e) < len(errorIDs) {
+		return errorIDs[e]
+	}
+	return fmt.Sprintf("error-%d", uint32(e))
+}
+
+// Failure is why a tunnel service stopped, detailed enough for the UI to explain it and suggest a remedy.
+type Failure struct {
+	Reason   Error
+	Stage    string           // what the service was doing, such as "create-wintun" or "running"
+	Win32    windows.Errno    // the underlying Windows error, if any
+	NTStatus windows.NTStatus // the underlying NTSTATUS, if any
+	Message  string           // the underlying error, in English
+	Time     time.Time
+}
+
+// NewFailure describes the failure of the service during stage, or returns nil if nothing failed.
+func NewFailure(stage string, serviceError Error, err error) *Failure {
+	if serviceError == ErrorSuccess && err == nil {
+		return nil
+	}
+	if serviceError == ErrorSuccess {
+		serviceError = ErrorWin32
+	}
+	f := &Failure{Reason: serviceError, Stage: stage, Time: time.Now().UTC()}
+	if err != nil {
+		f.Message = err.Error()
+		errors.As(err, &f.Win32)
+		errors.As(err, &f.NTStatus)
+	}
+	return f
+}
+
+func (f *Failure) Error() string {
+	if len(f.Message) > 0 {
+		return fmt.Sprintf("%v: %s", f.Reason, f.Message)
+	}
+	return f.Reason.Error()
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Reason
+}
+
+// Hint suggests, in the language of the user, what might fix the failure, or returns an empty string if there is
+// nothing better to suggest than reading the log.
+func (f *Failure) Hint() string {
+	switch f.Reason {
+	case ErrorRingloggerOpen:
+		return l18n.Sprintf("Check that the disk is not full and that the AmneziaWG data folder is writable.")
+	case ErrorLoadConfiguration:
+		return l18n.Sprintf("The configuration may be damaged. Import it again.")
+	case ErrorCreateWintun:
+		switch f.Win32 {
+		case windows.ERROR_DRIVER_BLOCKED:
+			return l18n.Sprintf("The Wintun driver is blocked by policy. Ask your administrator to allow it, or check whether security software blocks drivers.")
+		case windows.ERROR_DRIVER_FAILED_PRIOR_UNLOAD:
+			return l18n.Sprintf("The Wintun driver is still being unloaded. Restart the computer and try again.")
+		case windows.ERROR_ALREADY_EXISTS, windows.ERROR_OBJECT_ALREADY_EXISTS:
+			return l18n.Sprintf("A network adapter with the name of the tunnel already exists. Rename the tunnel or remove the adapter.")
+		}
+		return l18n.Sprintf("Reinstall AmneziaWG to repair the Wintun driver, then restart the computer.")
+	case ErrorUAPIListen:
+		return l18n.Sprintf("Another instance of the tunnel may still be running. Wait for it to stop and try again.")
+	case ErrorDNSLookup:
+		return l18n.Sprintf("Check the Internet connection and the spelling of the endpoint hostnames.")
+	case ErrorFirewall:
+		return l18n.Sprintf("Another VPN or firewall may hold conflicting filters. Disconnect it and try again.")
+	case ErrorBindSocketsToDefaultRoutes:
+		return l18n.Sprintf("Another VPN owns the default route. Disconnect it and try again.")
+	case ErrorSetNetConfig:
+		if f.Win32 == windows.ERROR_OBJECT_ALREADY_EXISTS {
+			return l18n.Sprintf("Another network adapter already uses one of the addresses or routes of the tunnel. Disconnect other VPNs and try again.")
+		}
+		return l18n.Sprintf("Another VPN may own the default route or conflict with the addresses of the tunnel. Disconnect it and try again.")
+	case ErrorRunScript:
+		return l18n.Sprintf("Check the PreUp, PostUp, PreDown, and PostDown commands, and that the administrator allows scripts.")
+	case ErrorHandshakeTimeout:
+		return l18n.Sprintf("The server stopped responding. Check that it is reachable and that the keys and obfuscation settings match its own.")
+	case ErrorProxyListen:
+		return l18n.Sprintf("Another program is using the address of the Proxy key. Choose another port.")
+	}
+	return ""
+}
+
+// failureJSON is the serialization of a Failure. The description and hint are written for the UI, but are not
+// read back, as they are in the language of whoever wrote them.
+type failureJSON struct {
+	ID          string    `json:"id"`
+	Code        uint32    `json:"code"`
+	Stage       string    `json:"stage,omitempty"`
+	Win32       uint32    `json:"win32Error,omitempty"`
+	NTStatus    uint32    `json:"ntStatus,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Description string    `json:"description"`
+	Hint        string    `json:"hint,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+func (f *Failure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&failureJSON{
+		ID:          f.Reason.ID(),
+		Code:        uint32(f.Reason),
+		Stage:       f.Stage,
+		Win32:       uint32(f.Win32),
+		NTStatus:    uint32(f.NTStatus),
+		Message:     f.Message,
+		Description: f.Reason.Error(),
+		Hint:        f.Hint(),
+		Time:        f.Time,
+	})
+}
+
+func (f *Failure) UnmarshalJSON(data []byte) error {
+	var j failureJSON
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	*f = Failure{Reason: Error(j.Code), Stage: j.Stage, Win32: windows.Errno(j.Win32), NTStatus: windows.NTStatus(j.NTStatus), Message: j.Message, Time: j.Time}
+	for i, id := range errorIDs {
+		if id == j.ID {
+			f.Reason = Error(i)
+			break
+		}
+	}
+	return nil
+}
+
+// SaveFailure keeps f, the last failure of the tunnel whose configuration is at configPath, next to the
+// configuration, or forgets the previous one if f is nil.
+func SaveFailure(configPath string, f *Failure) error {
+	if f == nil {
+		return conf.SaveFailure(configPath, nil)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return conf.SaveFailure(configPath, data)
+}
+
+// LastFailure returns the failure that the tunnel last stopped with, or nil if it last stopped cleanly.
+func LastFailure(tunnelName string) (*Failure, error) {
+	data, err := conf.LoadFailureOfName(tunnelName)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	f := &Failure{}
+	err = json.Unmarshal(data, f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
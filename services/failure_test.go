@@ -0,0 +1,59 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestErrorIDs(t *testing.T) {
+	seen := make(map[string]bool)
+	for e := ErrorSuccess; e <= ErrorProxyListen; e++ {
+		id := e.ID()
+		if len(id) == 0 || strings.HasPrefix(id, "error-") || seen[id] {
+			t.Errorf("error %d has ID %q", e, id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestFailure(t *testing.T) {
+	if NewFailure("running", ErrorSuccess, nil) != nil {
+		t.Error("clean stop is a failure")
+	}
+
+	f := NewFailure("create-wintun", ErrorCreateWintun, fmt.Errorf("Error creating interface: %w", windows.ERROR_DRIVER_BLOCKED))
+	if f.Win32 != windows.ERROR_DRIVER_BLOCKED || !errors.Is(f, ErrorCreateWintun) {
+		t.Errorf("failure = %+v", f)
+	}
+	if len(f.Hint()) == 0 {
+		t.Error("blocked driver has no hint")
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{`"id":"create-wintun"`, `"code":3`, `"stage":"create-wintun"`, `"win32Error":1275`, `"hint":"`} {
+		if !strings.Contains(string(data), part) {
+			t.Errorf("JSON %s does not contain %s", data, part)
+		}
+	}
+	var loaded Failure
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != *f {
+		t.Errorf("loaded %+v, want %+v", loaded, *f)
+	}
+}
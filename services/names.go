@@ -24,3 +24,17 @@ func PipePathOfTunnel(tunnelName string) (string, error) {
 	}
 	return `\\.\pipe\ProtectedPrefix\Administrators\AmneziaWG\` + tunnelName, nil
 }
+
+func EventPipePathOfTunnel(tunnelName string) (string, error) {
+	if !conf.TunnelNameIsValid(tunnelName) {
+		return "", errors.New("Tunnel name is not valid")
+	}
+	return `\\.\pipe\ProtectedPrefix\Administrators\AmneziaWGEvents\` + tunnelName, nil
+}
+
+func MetricsPipePathOfTunnel(tunnelName string) (string, error) {
+	if !conf.TunnelNameIsValid(tunnelName) {
+		return "", errors.New("Tunnel name is not valid")
+	}
+	return `\\.\pipe\ProtectedPrefix\Administrators\AmneziaWGMetrics\` + tunnelName, nil
+}
@@ -0,0 +1,130 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package status
+
+import (
+	"sync"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/trace"
+)
+
+// DefaultWindow is the span over which throughput rates are averaged.
+const DefaultWindow = 10 * time.Second
+
+type sample struct {
+	when   time.Time
+	rx, tx uint64
+}
+
+// Monitor queries a tunnel and keeps recent per-peer byte counters, so that throughput can be computed over a
+// sliding window. It is safe for concurrent use.
+type Monitor struct {
+	Name   string
+	Window time.Duration
+
+	// These are replaced by tests.
+	now          func() time.Time
+	query        func(name string) (*conf.Config, State, error)
+	lastFailure  func(name string) (*services.Failure, error)
+	startupTrace func(name string) (*trace.Trace, error)
+
+	mu      sync.Mutex
+	samples map[conf.Key][]sample
+}
+
+// NewMonitor returns a Monitor for the named tunnel, using the tunnel's pipe and the service manager.
+func NewMonitor(name string) *Monitor {
+	return &Monitor{
+		Name:         name,
+		Window:       DefaultWindow,
+		now:          time.Now,
+		query:        queryTunnel,
+		lastFailure:  services.LastFailure,
+		startupTrace: StartupTrace,
+		samples:      make(map[conf.Key][]sample),
+	}
+}
+
+// Status queries the tunnel, records a sample for each peer and returns a snapshot with throughput rates.
+// A tunnel that is not running yields a snapshot without peers rather than an error.
+func (m *Monitor) Status() (*Status, error) {
+	config, state, err := m.query(m.Name)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	// Not knowing how the tunnel last started or stopped is no reason to fail reporting its state.
+	startup, _ := m.startupTrace(m.Name)
+	if config == nil {
+		m.mu.Lock()
+		m.samples = make(map[conf.Key][]sample)
+		m.mu.Unlock()
+		status := &Status{Name: m.Name, State: state, Timestamp: now.UTC(), Peers: []Peer{}, Startup: startup}
+		if state == StateStopped {
+			status.LastFailure, _ = m.lastFailure(m.Name)
+		}
+		return status, nil
+	}
+	status := FromConfig(config, state, now.UTC())
+	status.Startup = startup
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[conf.Key]bool, len(config.Peers))
+	for i := range config.Peers {
+		key := config.Peers[i].PublicKey
+		seen[key] = true
+		current := sample{now, uint64(config.Peers[i].RxBytes), uint64(config.Peers[i].TxBytes)}
+		samples := m.samples[key]
+		if len(samples) > 0 {
+			last := samples[len(samples)-1]
+			if current.rx < last.rx || current.tx < last.tx {
+				// Counters were reset, because the peer was removed and re-added.
+				samples = samples[:0]
+			} else if !current.when.After(last.when) {
+				samples = samples[:len(samples)-1]
+			}
+		}
+		samples = append(samples, current)
+		// Keep the newest sample that is at least a window old, so that the rate always spans the window.
+		start := 0
+		for start < len(samples)-1 && now.Sub(samples[start+1].when) >= m.Window {
+			start++
+		}
+		samples = append(samples[:0], samples[start:]...)
+		m.samples[key] = samples
+		if oldest := samples[0]; len(samples) > 1 && now.After(oldest.when) {
+			seconds := now.Sub(oldest.when).Seconds()
+			status.Peers[i].RxBytesPerSecond = float64(current.rx-oldest.rx) / seconds
+			status.Peers[i].TxBytesPerSecond = float64(current.tx-oldest.tx) / seconds
+		}
+	}
+	for key := range m.samples {
+		if !seen[key] {
+			delete(m.samples, key)
+		}
+	}
+	return status, nil
+}
+
+var (
+	monitors     = make(map[string]*Monitor)
+	monitorsLock sync.Mutex
+)
+
+// MonitorOf returns the process-wide Monitor of the named tunnel, so that successive callers share samples.
+func MonitorOf(name string) *Monitor {
+	monitorsLock.Lock()
+	defer monitorsLock.Unlock()
+	m, ok := monitors[name]
+	if !ok {
+		m = NewMonitor(name)
+		monitors[name] = m
+	}
+	return m
+}
@@ -0,0 +1,119 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package status
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/trace"
+)
+
+// ServiceState asks the service manager for the state of the tunnel service, requiring no more than the
+// query right, which unprivileged callers are granted by default.
+func ServiceState(name string) (State, error) {
+	serviceName, err := services.ServiceNameOfTunnel(name)
+	if err != nil {
+		return StateUnknown, err
+	}
+	scm, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return StateUnknown, err
+	}
+	defer windows.CloseServiceHandle(scm)
+	serviceName16, err := windows.UTF16PtrFromString(serviceName)
+	if err != nil {
+		return StateUnknown, err
+	}
+	service, err := windows.OpenService(scm, serviceName16, windows.SERVICE_QUERY_STATUS)
+	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		return StateStopped, nil
+	}
+	if err != nil {
+		return StateUnknown, err
+	}
+	defer windows.CloseServiceHandle(service)
+	var serviceStatus windows.SERVICE_STATUS
+	err = windows.QueryServiceStatus(service, &serviceStatus)
+	if err != nil {
+		return StateUnknown, err
+	}
+	switch serviceStatus.CurrentState {
+	case windows.SERVICE_STOPPED:
+		return StateStopped, nil
+	case windows.SERVICE_START_PENDING:
+		return StateStarting, nil
+	case windows.SERVICE_RUNNING:
+		return StateRunning, nil
+	case windows.SERVICE_STOP_PENDING:
+		return StateStopping, nil
+	}
+	return StateUnknown, nil
+}
+
+// QueryUAPI fetches the running configuration of the tunnel from its UAPI pipe.
+func QueryUAPI(name string) (*conf.Config, error) {
+	pipePath, err := services.PipePathOfTunnel(name)
+	if err != nil {
+		return nil, err
+	}
+	pipePath16, err := windows.UTF16PtrFromString(pipePath)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateFile(pipePath16, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	pipe := os.NewFile(uintptr(handle), pipePath)
+	defer pipe.Close()
+	_, err = pipe.Write([]byte("get=1\n\n"))
+	if err != nil {
+		return nil, err
+	}
+	return conf.FromUAPI(bufio.NewReader(pipe), &conf.Config{Name: name})
+}
+
+// StartupTrace returns the trace of the latest startup of the tunnel, which the tunnel service keeps next to
+// the configuration, or nil if the tunnel has never started.
+func StartupTrace(name string) (*trace.Trace, error) {
+	data, err := conf.LoadStartupTraceOfName(name)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	startup := &trace.Trace{}
+	err = json.Unmarshal(data, startup)
+	if err != nil {
+		return nil, err
+	}
+	return startup, nil
+}
+
+// queryTunnel fetches the running configuration, unless the service is not up, in which case the
+// configuration is nil.
+func queryTunnel(name string) (*conf.Config, State, error) {
+	state, err := ServiceState(name)
+	if err != nil {
+		return nil, state, err
+	}
+	if state != StateRunning {
+		return nil, state, nil
+	}
+	config, err := QueryUAPI(name)
+	if err == windows.ERROR_FILE_NOT_FOUND {
+		// The service reports running before the device is listening.
+		return nil, StateStarting, nil
+	}
+	if err != nil {
+		return nil, state, err
+	}
+	return config, state, nil
+}
@@ -0,0 +1,83 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package status
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/trace"
+)
+
+// State is the lifecycle state of a tunnel service.
+type State string
+
+const (
+	StateUnknown  State = "unknown"
+	StateStopped  State = "stopped"
+	StateStarting State = "starting"
+	StateRunning  State = "running"
+	StateStopping State = "stopping"
+)
+
+// Peer holds the statistics of a single peer. Rates are averaged over the sliding window of the Monitor
+// that produced them, and are zero until at least two samples are available.
+type Peer struct {
+	PublicKey           string     `json:"publicKey"`
+	Endpoint            string     `json:"endpoint,omitempty"`
+	RxBytes             uint64     `json:"rxBytes"`
+	TxBytes             uint64     `json:"txBytes"`
+	LastHandshake       *time.Time `json:"lastHandshake,omitempty"`
+	RxBytesPerSecond    float64    `json:"rxBytesPerSecond"`
+	TxBytesPerSecond    float64    `json:"txBytesPerSecond"`
+	PersistentKeepalive string     `json:"persistentKeepalive,omitempty"`
+}
+
+// Status is a snapshot of a tunnel. A stopped tunnel has the failure it last stopped with, if any, and any
+// tunnel that has ever started has the trace of its latest startup, which is still going if it has no end.
+type Status struct {
+	Name        string            `json:"name"`
+	State       State             `json:"state"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Peers       []Peer            `json:"peers"`
+	LastFailure *services.Failure `json:"lastFailure,omitempty"`
+	Startup     *trace.Trace      `json:"startup,omitempty"`
+}
+
+// FromConfig builds a status snapshot from a configuration returned by conf.FromUAPI, without rates.
+func FromConfig(config *conf.Config, state State, timestamp time.Time) *Status {
+	status := &Status{
+		Name:      config.Name,
+		State:     state,
+		Timestamp: timestamp,
+		Peers:     make([]Peer, 0, len(config.Peers)),
+	}
+	for i := range config.Peers {
+		peer := &config.Peers[i]
+		p := Peer{
+			PublicKey:           peer.PublicKey.String(),
+			RxBytes:             uint64(peer.RxBytes),
+			TxBytes:             uint64(peer.TxBytes),
+			PersistentKeepalive: peer.PersistentKeepalive,
+		}
+		if !peer.Endpoint.IsEmpty() {
+			p.Endpoint = peer.Endpoint.String()
+		}
+		if !peer.LastHandshakeTime.IsEmpty() {
+			lastHandshake := time.Unix(0, 0).Add(time.Duration(peer.LastHandshakeTime)).UTC()
+			p.LastHandshake = &lastHandshake
+		}
+		status.Peers = append(status.Peers, p)
+	}
+	return status
+}
+
+// JSON serializes the status for the C-exported API.
+func (status *Status) JSON() ([]byte, error) {
+	return json.Marshal(status)
+}
@@ -0,0 +1,174 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/trace"
+)
+
+var testEpoch = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testConfig(rx, tx conf.Bytes, keys ...byte) *conf.Config {
+	config := &conf.Config{Name: "test"}
+	for _, key := range keys {
+		config.Peers = append(config.Peers, conf.Peer{PublicKey: conf.Key{key}, RxBytes: rx, TxBytes: tx})
+	}
+	return config
+}
+
+func TestFromConfig(t *testing.T) {
+	config := testConfig(1000, 2000, 1)
+	config.Peers[0].Endpoint = conf.Endpoint{Host: "2001:db8::1", Port: 51820}
+	config.Peers[0].LastHandshakeTime = conf.HandshakeTime(testEpoch.Sub(time.Unix(0, 0)))
+	config.Peers = append(config.Peers, conf.Peer{PublicKey: conf.Key{2}})
+	status := FromConfig(config, StateRunning, testEpoch)
+	if len(status.Peers) != 2 {
+		t.Fatalf("got %d peers, want 2", len(status.Peers))
+	}
+	peer := status.Peers[0]
+	if peer.PublicKey != config.Peers[0].PublicKey.String() || peer.Endpoint != "[2001:db8::1]:51820" || peer.RxBytes != 1000 || peer.TxBytes != 2000 {
+		t.Errorf("unexpected peer %+v", peer)
+	}
+	if peer.LastHandshake == nil || !peer.LastHandshake.Equal(testEpoch) {
+		t.Errorf("LastHandshake = %v, want %v", peer.LastHandshake, testEpoch)
+	}
+	if status.Peers[1].LastHandshake != nil || status.Peers[1].Endpoint != "" {
+		t.Errorf("unexpected peer %+v", status.Peers[1])
+	}
+
+	j, err := status.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	err = json.Unmarshal(j, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded["state"] != "running" || decoded["name"] != "test" {
+		t.Errorf("unexpected JSON %s", j)
+	}
+	if _, ok := decoded["peers"].([]interface{})[1].(map[string]interface{})["lastHandshake"]; ok {
+		t.Errorf("lastHandshake should be omitted when there was none: %s", j)
+	}
+}
+
+type fakeTunnel struct {
+	now     time.Time
+	config  *conf.Config
+	state   State
+	err     error
+	failure *services.Failure
+	startup *trace.Trace
+}
+
+func (f *fakeTunnel) monitor() *Monitor {
+	m := NewMonitor("test")
+	m.now = func() time.Time { return f.now }
+	m.query = func(name string) (*conf.Config, State, error) {
+		return f.config, f.state, f.err
+	}
+	m.lastFailure = func(name string) (*services.Failure, error) {
+		return f.failure, nil
+	}
+	m.startupTrace = func(name string) (*trace.Trace, error) {
+		return f.startup, nil
+	}
+	return m
+}
+
+func TestMonitorRates(t *testing.T) {
+	tunnel := &fakeTunnel{now: testEpoch, config: testConfig(0, 0, 1), state: StateRunning}
+	m := tunnel.monitor()
+	rate := func() (float64, float64) {
+		status, err := m.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status.Peers[0].RxBytesPerSecond, status.Peers[0].TxBytesPerSecond
+	}
+	if rx, tx := rate(); rx != 0 || tx != 0 {
+		t.Errorf("first sample rates = %v/%v, want 0/0", rx, tx)
+	}
+	for i := 1; i <= 20; i++ {
+		tunnel.now = testEpoch.Add(time.Duration(i) * time.Second)
+		tunnel.config = testConfig(conf.Bytes(i*1000), conf.Bytes(i*100), 1)
+		rx, tx := rate()
+		if rx != 1000 || tx != 100 {
+			t.Errorf("rates after %ds = %v/%v, want 1000/100", i, rx, tx)
+		}
+	}
+	if samples := len(m.samples[conf.Key{1}]); samples != int(DefaultWindow/time.Second)+1 {
+		t.Errorf("kept %d samples, want a window's worth", samples)
+	}
+
+	// Traffic stops; the rate decays as the window moves past the last burst.
+	tunnel.now = tunnel.now.Add(5 * time.Second)
+	if rx, _ := rate(); rx != 500 {
+		t.Errorf("rate after idling = %v, want 500", rx)
+	}
+
+	// The same instant replaces the previous sample.
+	if rx, _ := rate(); rx != 500 {
+		t.Errorf("rate after repeated query = %v, want 500", rx)
+	}
+
+	// Counters going backward mean the peer was re-added.
+	tunnel.now = tunnel.now.Add(time.Second)
+	tunnel.config = testConfig(10, 10, 1)
+	if rx, tx := rate(); rx != 0 || tx != 0 {
+		t.Errorf("rates after reset = %v/%v, want 0/0", rx, tx)
+	}
+}
+
+func TestMonitorPeersAndState(t *testing.T) {
+	tunnel := &fakeTunnel{now: testEpoch, config: testConfig(0, 0, 1, 2), state: StateRunning, startup: &trace.Trace{Start: testEpoch}}
+	m := tunnel.monitor()
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Startup != tunnel.startup {
+		t.Errorf("startup = %v, want %v", status.Startup, tunnel.startup)
+	}
+	tunnel.now = tunnel.now.Add(2 * time.Second)
+	tunnel.config = testConfig(4000, 0, 2)
+	status, err = m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Peers) != 1 || status.Peers[0].RxBytesPerSecond != 2000 {
+		t.Errorf("unexpected peers %+v", status.Peers)
+	}
+	if _, ok := m.samples[conf.Key{1}]; ok {
+		t.Error("samples of removed peer were kept")
+	}
+
+	tunnel.config, tunnel.state = nil, StateStopped
+	tunnel.failure = &services.Failure{Reason: services.ErrorHandshakeTimeout, Stage: "running", Time: testEpoch}
+	status, err = m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != StateStopped || len(status.Peers) != 0 || len(m.samples) != 0 {
+		t.Errorf("unexpected stopped status %+v", status)
+	}
+	if status.LastFailure != tunnel.failure {
+		t.Errorf("last failure = %v, want %v", status.LastFailure, tunnel.failure)
+	}
+
+	tunnel.err = errors.New("access denied")
+	if _, err = m.Status(); err == nil {
+		t.Error("query error was not returned")
+	}
+}
@@ -6,190 +6,82 @@
 package tunnel
 
 import (
-	"bytes"
 	"log"
 	"net"
-	"sort"
 
 	"github.com/amnezia-vpn/amneziawg-go/v3/tun"
-	"golang.org/x/sys/windows"
 
 	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
-	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/firewall"
-	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/winipcfg"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/journal"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
 )
 
-func cleanupAddressesOnDisconnectedInterfaces(family winipcfg.AddressFamily, addresses []net.IPNet) {
-	if len(addresses) == 0 {
-		return
-	}
-	includedInAddresses := func(a net.IPNet) bool {
-		// TODO: this makes the whole algorithm O(n^2). But we can't stick net.IPNet in a Go hashmap. Bummer!
-		for _, addr := range addresses {
-			ip := addr.IP
-			if ip4 := ip.To4(); ip4 != nil {
-				ip = ip4
-			}
-			mA, _ := addr.Mask.Size()
-			mB, _ := a.Mask.Size()
-			if bytes.Equal(ip, a.IP) && mA == mB {
-				return true
-			}
-		}
-		return false
-	}
-	interfaces, err := winipcfg.GetAdaptersAddresses(family, winipcfg.GAAFlagDefault)
-	if err != nil {
-		return
-	}
-	for _, iface := range interfaces {
-		if iface.OperStatus == winipcfg.IfOperStatusUp {
-			continue
-		}
-		for address := iface.FirstUnicastAddress; address != nil; address = address.Next {
-			ip := address.Address.IP()
-			ipnet := net.IPNet{IP: ip, Mask: net.CIDRMask(int(address.OnLinkPrefixLength), 8*len(ip))}
-			if includedInAddresses(ipnet) {
-				log.Printf("Cleaning up stale address %s from interface ‘%s’", ipnet.String(), iface.FriendlyName())
-				iface.LUID.DeleteIPAddress(ipnet)
-			}
+// routesForConfig builds the routes for the extra routes and the peers' allowed IPs, with the metrics offset
+// by the numeric routing table.
+func routesForConfig(config *conf.Config, addresses []net.IPNet) []netconfig.Route {
+	var candidates []netconfig.Route
+	for _, route := range config.Interface.Routes {
+		candidates = append(candidates, netconfig.Route{Destination: route.IPNet(), Metric: netconfig.TableMetric(config.Interface.Table, 0)})
+	}
+	for _, peer := range config.Peers {
+		for _, allowedip := range peer.AllowedIPs {
+			candidates = append(candidates, netconfig.Route{Destination: allowedip.IPNet(), Metric: netconfig.TableMetric(config.Interface.Table, peer.RouteMetric)})
 		}
 	}
+	return netconfig.BuildRoutes(addresses, candidates)
 }
 
-func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *tun.NativeTun) error {
-	luid := winipcfg.LUID(tun.LUID())
-
-	estimatedRouteCount := 0
-	for _, peer := range conf.Peers {
-		estimatedRouteCount += len(peer.AllowedIPs)
-	}
-	routes := make([]winipcfg.RouteData, 0, estimatedRouteCount)
-	addresses := make([]net.IPNet, len(conf.Interface.Addresses))
-	var haveV4Address, haveV6Address bool
-	for i, addr := range conf.Interface.Addresses {
+func settingsForConfig(config *conf.Config) *netconfig.Settings {
+	addresses := make([]net.IPNet, len(config.Interface.Addresses))
+	for i, addr := range config.Interface.Addresses {
 		addresses[i] = addr.IPNet()
-		if addr.Bits() == 32 {
-			haveV4Address = true
-		} else if addr.Bits() == 128 {
-			haveV6Address = true
-		}
-	}
-
-	foundDefault4 := false
-	foundDefault6 := false
-	for _, peer := range conf.Peers {
-		for _, allowedip := range peer.AllowedIPs {
-			allowedip.MaskSelf()
-			if (allowedip.Bits() == 32 && !haveV4Address) || (allowedip.Bits() == 128 && !haveV6Address) {
-				continue
-			}
-			route := winipcfg.RouteData{
-				Destination: allowedip.IPNet(),
-				Metric:      0,
-			}
-			if allowedip.Bits() == 32 {
-				if allowedip.Cidr == 0 {
-					foundDefault4 = true
-				}
-				route.NextHop = net.IPv4zero
-			} else if allowedip.Bits() == 128 {
-				if allowedip.Cidr == 0 {
-					foundDefault6 = true
-				}
-				route.NextHop = net.IPv6zero
-			}
-			routes = append(routes, route)
-		}
-	}
-
-	err := luid.SetIPAddressesForFamily(family, addresses)
-	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
-		cleanupAddressesOnDisconnectedInterfaces(family, addresses)
-		err = luid.SetIPAddressesForFamily(family, addresses)
-	}
-	if err != nil {
-		return err
 	}
-
-	deduplicatedRoutes := make([]*winipcfg.RouteData, 0, len(routes))
-	sort.Slice(routes, func(i, j int) bool {
-		if routes[i].Metric != routes[j].Metric {
-			return routes[i].Metric < routes[j].Metric
-		}
-		if c := bytes.Compare(routes[i].NextHop, routes[j].NextHop); c != 0 {
-			return c < 0
-		}
-		if c := bytes.Compare(routes[i].Destination.IP, routes[j].Destination.IP); c != 0 {
-			return c < 0
-		}
-		if c := bytes.Compare(routes[i].Destination.Mask, routes[j].Destination.Mask); c != 0 {
-			return c < 0
-		}
-		return false
-	})
-	for i := 0; i < len(routes); i++ {
-		if i > 0 && routes[i].Metric == routes[i-1].Metric &&
-			bytes.Equal(routes[i].NextHop, routes[i-1].NextHop) &&
-			bytes.Equal(routes[i].Destination.IP, routes[i-1].Destination.IP) &&
-			bytes.Equal(routes[i].Destination.Mask, routes[i-1].Destination.Mask) {
-			continue
-		}
-		deduplicatedRoutes = append(deduplicatedRoutes, &routes[i])
-	}
-
-	if !conf.Interface.TableOff {
-		err = luid.SetRoutesForFamily(family, deduplicatedRoutes)
-		if err != nil {
-			return err
-		}
+	_, blockDNSLeaks := FirewallRestrictions(config)
+	return &netconfig.Settings{
+		Addresses:       addresses,
+		Routes:          routesForConfig(config, addresses),
+		TableOff:        config.Interface.TableOff,
+		Table:           config.Interface.Table,
+		MTU:             uint32(config.Interface.MTU),
+		InterfaceMetric: config.Interface.InterfaceMetric,
+		DNS:             config.Interface.DNS,
+		DNSSearch:       config.Interface.DNSSearch,
+		DNSDomains:      config.Interface.DNSDomains,
+		BlockDNSLeaks:   blockDNSLeaks,
 	}
+}
 
-	ipif, err := luid.IPInterface(family)
+func configureInterface(nc netconfig.NetConfigurator, family netconfig.Family, conf *conf.Config, tun *tun.NativeTun, j *journal.Journal) error {
+	err := netconfig.ConfigureInterface(nc, netconfig.LUID(tun.LUID()), family, settingsForConfig(conf), j)
 	if err != nil {
 		return err
 	}
 	if conf.Interface.MTU > 0 {
-		ipif.NLMTU = uint32(conf.Interface.MTU)
-		tun.ForceMTU(int(ipif.NLMTU))
-	}
-	if family == windows.AF_INET {
-		if foundDefault4 {
-			ipif.UseAutomaticMetric = false
-			ipif.Metric = 0
-		}
-	} else if family == windows.AF_INET6 {
-		if foundDefault6 {
-			ipif.UseAutomaticMetric = false
-			ipif.Metric = 0
-		}
-		ipif.DadTransmits = 0
-		ipif.RouterDiscoveryBehavior = winipcfg.RouterDiscoveryDisabled
-	}
-	err = ipif.Set()
-	if err != nil {
-		return err
+		tun.ForceMTU(int(conf.Interface.MTU))
 	}
+	return nil
+}
 
-	return luid.SetDNS(family, conf.Interface.DNS, conf.Interface.DNSSearch)
+// FirewallRestrictions decides which firewall rules enableFirewall installs. The
+// full kill-switch is used whenever the peers capture the default route of
+// either family with routes that take precedence, while DNS leak blocking is
+// also available to split tunnels that opt in.
+func FirewallRestrictions(conf *conf.Config) (doNotRestrict bool, blockDNSLeaks bool) {
+	doNotRestrict = conf.RoutesPreempted() || !conf.DefaultRouteCapture().Any()
+	blockDNSLeaks = len(conf.Interface.DNS) > 0 && (!doNotRestrict || conf.Interface.BlockDNSLeaks)
+	return
 }
 
-func enableFirewall(conf *conf.Config, tun *tun.NativeTun) error {
-	doNotRestrict := true
-	if len(conf.Peers) == 1 && !conf.Interface.TableOff {
-	nextallowedip:
-		for _, allowedip := range conf.Peers[0].AllowedIPs {
-			if allowedip.Cidr == 0 {
-				for _, b := range allowedip.IP {
-					if b != 0 {
-						continue nextallowedip
-					}
-				}
-				doNotRestrict = false
-				break
-			}
-		}
+func enableFirewall(nc netconfig.NetConfigurator, conf *conf.Config, tun *tun.NativeTun, j *journal.Journal) error {
+	capture := conf.DefaultRouteCapture()
+	log.Printf("Default route capture: IPv4=%v, IPv6=%v", capture.IPv4, capture.IPv6)
+	doNotRestrict, blockDNSLeaks := FirewallRestrictions(conf)
+	if conf.Interface.BlockDNSLeaks && len(conf.Interface.DNS) == 0 {
+		log.Println("Warning: not blocking DNS leaks, because no DNS servers are configured")
+	}
+	if blockDNSLeaks && len(conf.Interface.DNSDomains) > 0 {
+		log.Println("Warning: resolving all names with the tunnel's DNS servers rather than only its routing domains, because DNS leaks are blocked")
 	}
 	log.Println("Enabling firewall rules")
-	return firewall.EnableFirewall(tun.LUID(), doNotRestrict, conf.Interface.DNS)
+	return netconfig.EnableFirewall(nc, netconfig.LUID(tun.LUID()), doNotRestrict, blockDNSLeaks, conf.Interface.DNS, j)
 }
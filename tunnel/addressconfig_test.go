@@ -0,0 +1,282 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"net"
+	"testing"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
+)
+
+func testCidr(s string) conf.IPCidr {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	cidr, _ := ipnet.Mask.Size()
+	return conf.IPCidr{IP: ip, Cidr: uint8(cidr)}
+}
+
+func testPeer(allowedIPs ...string) conf.Peer {
+	peer := conf.Peer{}
+	for _, allowedIP := range allowedIPs {
+		peer.AllowedIPs = append(peer.AllowedIPs, testCidr(allowedIP))
+	}
+	return peer
+}
+
+func TestFirewallRestrictions(t *testing.T) {
+	dns := []net.IP{net.IPv4(10, 64, 0, 1).To4()}
+	tests := []struct {
+		name              string
+		peers             []conf.Peer
+		dns               []net.IP
+		tableOff          bool
+		table             uint32
+		blockDNSLeaks     bool
+		wantDoNotRestrict bool
+		wantBlockDNS      bool
+	}{
+		{
+			name:              "full tunnel without dns",
+			peers:             []conf.Peer{testPeer("0.0.0.0/0")},
+			wantDoNotRestrict: false,
+			wantBlockDNS:      false,
+		},
+		{
+			name:              "full tunnel with dns",
+			peers:             []conf.Peer{testPeer("0.0.0.0/0", "::/0")},
+			dns:               dns,
+			wantDoNotRestrict: false,
+			wantBlockDNS:      true,
+		},
+		{
+			name:              "full tunnel with table off",
+			peers:             []conf.Peer{testPeer("0.0.0.0/0")},
+			dns:               dns,
+			tableOff:          true,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      false,
+		},
+		{
+			name:              "full tunnel with numeric table",
+			peers:             []conf.Peer{testPeer("0.0.0.0/0")},
+			dns:               dns,
+			table:             1234,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      false,
+		},
+		{
+			name:              "split tunnel with dns",
+			peers:             []conf.Peer{testPeer("10.64.0.0/16")},
+			dns:               dns,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      false,
+		},
+		{
+			name:              "split tunnel blocking dns leaks",
+			peers:             []conf.Peer{testPeer("10.64.0.0/16")},
+			dns:               dns,
+			blockDNSLeaks:     true,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      true,
+		},
+		{
+			name:              "split tunnel blocking dns leaks without dns",
+			peers:             []conf.Peer{testPeer("10.64.0.0/16")},
+			blockDNSLeaks:     true,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      false,
+		},
+		{
+			name:              "table off blocking dns leaks",
+			peers:             []conf.Peer{testPeer("0.0.0.0/0")},
+			dns:               dns,
+			tableOff:          true,
+			blockDNSLeaks:     true,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      true,
+		},
+		{
+			name:              "multiple peers with default route",
+			peers:             []conf.Peer{testPeer("10.64.0.0/16"), testPeer("0.0.0.0/0")},
+			dns:               dns,
+			wantDoNotRestrict: false,
+			wantBlockDNS:      true,
+		},
+		{
+			name:              "default route halves",
+			peers:             []conf.Peer{testPeer("0.0.0.0/1", "128.0.0.0/1")},
+			wantDoNotRestrict: false,
+			wantBlockDNS:      false,
+		},
+		{
+			name:              "default route halves across peers",
+			peers:             []conf.Peer{testPeer("::/1"), testPeer("8000::/1")},
+			dns:               dns,
+			wantDoNotRestrict: false,
+			wantBlockDNS:      true,
+		},
+		{
+			name:              "single default route half",
+			peers:             []conf.Peer{testPeer("0.0.0.0/1")},
+			dns:               dns,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      false,
+		},
+		{
+			name:              "multiple split peers blocking dns leaks",
+			peers:             []conf.Peer{testPeer("10.64.0.0/16"), testPeer("10.65.0.0/16")},
+			dns:               dns,
+			blockDNSLeaks:     true,
+			wantDoNotRestrict: true,
+			wantBlockDNS:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &conf.Config{Name: "test", Peers: tt.peers}
+			config.Interface.DNS = tt.dns
+			config.Interface.TableOff = tt.tableOff
+			config.Interface.Table = tt.table
+			config.Interface.BlockDNSLeaks = tt.blockDNSLeaks
+			doNotRestrict, blockDNS := FirewallRestrictions(config)
+			if doNotRestrict != tt.wantDoNotRestrict {
+				t.Errorf("doNotRestrict = %v, want %v", doNotRestrict, tt.wantDoNotRestrict)
+			}
+			if blockDNS != tt.wantBlockDNS {
+				t.Errorf("blockDNSLeaks = %v, want %v", blockDNS, tt.wantBlockDNS)
+			}
+		})
+	}
+}
+
+func TestRoutesForConfig(t *testing.T) {
+	metricPeer := func(metric uint32, allowedIPs ...string) conf.Peer {
+		peer := testPeer(allowedIPs...)
+		peer.RouteMetric = metric
+		return peer
+	}
+	route := func(destination string, metric uint32) netconfig.Route {
+		cidr := testCidr(destination)
+		nextHop := net.IPv6zero
+		if cidr.Bits() == 32 {
+			nextHop = net.IPv4zero
+		}
+		return netconfig.Route{Destination: cidr.IPNet(), NextHop: nextHop, Metric: metric}
+	}
+	tests := []struct {
+		name         string
+		addresses    []string
+		routes       []string
+		table        uint32
+		peers        []conf.Peer
+		want         []netconfig.Route
+		wantDefault4 bool
+		wantDefault6 bool
+	}{
+		{
+			name:      "default metrics",
+			addresses: []string{"10.64.0.2/32"},
+			peers:     []conf.Peer{testPeer("10.64.0.0/16", "10.65.1.1/16")},
+			want:      []netconfig.Route{route("10.64.0.0/16", 0), route("10.65.0.0/16", 0)},
+		},
+		{
+			name:      "per peer metrics",
+			addresses: []string{"10.64.0.2/32", "fd00::2/128"},
+			peers:     []conf.Peer{metricPeer(10, "10.64.0.0/16"), metricPeer(20, "10.65.0.0/16", "fd00::/64")},
+			want:      []netconfig.Route{route("10.64.0.0/16", 10), route("10.65.0.0/16", 20), route("fd00::/64", 20)},
+		},
+		{
+			name:      "duplicate keeps lowest metric",
+			addresses: []string{"10.64.0.2/32"},
+			peers:     []conf.Peer{metricPeer(30, "10.64.0.0/16"), metricPeer(5, "10.64.0.0/16"), metricPeer(5, "10.64.0.0/16")},
+			want:      []netconfig.Route{route("10.64.0.0/16", 5)},
+		},
+		{
+			name:         "family without address",
+			addresses:    []string{"fd00::2/128"},
+			peers:        []conf.Peer{metricPeer(7, "0.0.0.0/0", "::/0")},
+			want:         []netconfig.Route{route("::/0", 7)},
+			wantDefault6: true,
+		},
+		{
+			name:         "default routes",
+			addresses:    []string{"10.64.0.2/32", "fd00::2/128"},
+			peers:        []conf.Peer{testPeer("0.0.0.0/0", "::/0")},
+			want:         []netconfig.Route{route("0.0.0.0/0", 0), route("::/0", 0)},
+			wantDefault4: true,
+			wantDefault6: true,
+		},
+		{
+			name:      "extra routes",
+			addresses: []string{"10.64.0.2/32"},
+			routes:    []string{"192.168.5.0/24", "10.64.0.0/16", "fd00::/64"},
+			peers:     []conf.Peer{metricPeer(10, "10.64.0.0/16")},
+			want:      []netconfig.Route{route("10.64.0.0/16", 0), route("192.168.5.0/24", 0)},
+		},
+		{
+			name:         "extra default route",
+			addresses:    []string{"10.64.0.2/32"},
+			routes:       []string{"0.0.0.0/0"},
+			peers:        []conf.Peer{testPeer("10.64.0.0/16")},
+			want:         []netconfig.Route{route("0.0.0.0/0", 0), route("10.64.0.0/16", 0)},
+			wantDefault4: true,
+		},
+		{
+			name:      "numeric table",
+			addresses: []string{"10.64.0.2/32"},
+			routes:    []string{"192.168.5.0/24"},
+			table:     100,
+			peers:     []conf.Peer{testPeer("10.64.0.0/16"), metricPeer(5, "10.65.0.0/16")},
+			want:      []netconfig.Route{route("192.168.5.0/24", 100), route("10.64.0.0/16", 100), route("10.65.0.0/16", 105)},
+		},
+		{
+			name:      "numeric table saturates",
+			addresses: []string{"10.64.0.2/32"},
+			table:     4294967000,
+			peers:     []conf.Peer{metricPeer(9999, "10.64.0.0/16")},
+			want:      []netconfig.Route{route("10.64.0.0/16", 4294967295)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &conf.Config{Name: "test", Peers: tt.peers}
+			for _, address := range tt.addresses {
+				config.Interface.Addresses = append(config.Interface.Addresses, testCidr(address))
+			}
+			for _, route := range tt.routes {
+				config.Interface.Routes = append(config.Interface.Routes, testCidr(route))
+			}
+			config.Interface.Table = tt.table
+			routes := settingsForConfig(config).Routes
+			foundDefault4, foundDefault6 := netconfig.HasDefaultRoute(routes, netconfig.IPv4), netconfig.HasDefaultRoute(routes, netconfig.IPv6)
+			if foundDefault4 != tt.wantDefault4 || foundDefault6 != tt.wantDefault6 {
+				t.Errorf("foundDefault = %v/%v, want %v/%v", foundDefault4, foundDefault6, tt.wantDefault4, tt.wantDefault6)
+			}
+			if len(routes) != len(tt.want) {
+				t.Fatalf("got %d routes, want %d", len(routes), len(tt.want))
+			}
+			for _, want := range tt.want {
+				found := false
+				for _, route := range routes {
+					if route.Destination.String() == want.Destination.String() && route.NextHop.Equal(want.NextHop) && route.Metric == want.Metric {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("missing route %v via %v metric %d", want.Destination.String(), want.NextHop, want.Metric)
+				}
+			}
+		})
+	}
+}
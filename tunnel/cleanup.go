@@ -0,0 +1,70 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/journal"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/winipcfg"
+)
+
+// openJournal opens the journal of the tunnel and undoes whatever a previous run that did not shut down
+// cleanly left behind. Failures are only logged, as the journal is a safety net and not a requirement.
+func openJournal(tunnelName string) *journal.Journal {
+	root, err := conf.RootDirectory(true)
+	if err != nil {
+		log.Printf("Warning: unable to determine journal location: %v", err)
+		return nil
+	}
+	j, err := journal.Open(&journal.FileBackend{Path: filepath.Join(root, "Journal", tunnelName+".jsonl")})
+	if err != nil {
+		log.Printf("Warning: unable to open journal: %v", err)
+		return nil
+	}
+	if leftover := j.Leftover(); len(leftover) > 0 {
+		log.Printf("Cleaning up %d changes left behind by unclean shutdown", len(leftover))
+		err = j.Recover(undoJournalRecord)
+		if err != nil {
+			log.Printf("Warning: unable to clean up after unclean shutdown: %v", err)
+		}
+	}
+	return j
+}
+
+func undoJournalRecord(record journal.Record) error {
+	if record.Kind == journal.KindFirewall {
+		// The rules live in a dynamic WFP session, which Windows deleted along with the process that owned it.
+		return nil
+	}
+	guid, err := windows.GUIDFromString(record.Interface)
+	if err != nil {
+		return fmt.Errorf("Invalid journal record %+v: %w", record, err)
+	}
+	if record.Kind == journal.KindNRPT {
+		return winipcfg.DeleteNRPTRule(&guid)
+	}
+	luid, err := winipcfg.LUIDFromGUID(&guid)
+	if err != nil {
+		// Everything else went away with the interface.
+		return nil
+	}
+	family := winipcfg.AddressFamily(record.Family)
+	switch record.Kind {
+	case journal.KindAddresses:
+		return luid.FlushIPAddresses(family)
+	case journal.KindRoutes:
+		return luid.FlushRoutes(family)
+	case journal.KindDNS:
+		return luid.FlushDNS(family)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/device"
+	"github.com/amnezia-vpn/amneziawg-go/v3/ipc/namedpipe"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+// LogLevelOf returns the level of the log of the tunnel, which is that of its LogLevel key, or else of the
+// LogLevel admin registry value, or else info.
+func LogLevelOf(config *conf.Config) slog.Level {
+	level := config.Interface.LogLevel
+	if level == conf.LogLevelDefault {
+		level, _ = conf.ParseLogLevel(conf.AdminString("LogLevel"))
+	}
+	return slogLevel(level)
+}
+
+func slogLevel(level conf.LogLevel) slog.Level {
+	switch level {
+	case conf.LogLevelVerbose:
+		return ringlogger.LevelVerbose
+	case conf.LogLevelError:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// LogRedactor returns the redactor for the log of a tunnel, whose redaction levels match those of the log.
+func LogRedactor(config *conf.Config) *ringlogger.Redactor {
+	opts := ringlogger.RedactOptions{Level: ringlogger.RedactLevel(config.Interface.LogRedaction)}
+	for _, pattern := range config.Interface.LogRedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			continue
+		}
+		opts.Patterns = append(opts.Patterns, re)
+	}
+	return ringlogger.NewRedactor(opts)
+}
+
+// NewTunnelLogger returns the logger of the tunnel, which writes records tagged with the tunnel and their level
+// to the global log, or to the standard logger if the global log is not open, dropping those below level.
+func NewTunnelLogger(tunnelName string, level slog.Leveler) *slog.Logger {
+	return slog.New(ringlogger.NewHandler(ringlogger.Global, &ringlogger.HandlerOptions{Level: level, Tunnel: tunnelName}))
+}
+
+// DeviceLogger writes the verbose lines of a device as records of logger at ringlogger.LevelVerbose, and its
+// errors at slog.LevelError, formatting them only if the level of logger lets them through.
+func DeviceLogger(logger *slog.Logger) *device.Logger {
+	logf := func(level slog.Level) func(format string, args ...any) {
+		return func(format string, args ...any) {
+			if !logger.Enabled(context.Background(), level) {
+				return
+			}
+			logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+		}
+	}
+	return &device.Logger{Verbosef: logf(ringlogger.LevelVerbose), Errorf: logf(slog.LevelError)}
+}
+
+// The UAPI pipe of a tunnel also takes a log_level operation, which sets the level of the log of the tunnel
+// until it stops, and is answered like a set operation:
+//
+//	log_level=verbose
+//
+// Other operations are left to the device.
+const logLevelOperation = "log_level="
+
+// uapiConn replays the first line of an operation, which was read to tell which operation it is, to the device.
+type uapiConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (conn *uapiConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+// HandleUAPI serves an operation on the UAPI pipe of dev, setting level if it is a log_level operation.
+func HandleUAPI(dev *device.Device, conn net.Conn, level *slog.LevelVar) {
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if !strings.HasPrefix(line, logLevelOperation) {
+		if err != nil {
+			conn.Close()
+			return
+		}
+		dev.IpcHandle(&uapiConn{conn, io.MultiReader(strings.NewReader(line), reader)})
+		return
+	}
+	defer conn.Close()
+	for err == nil {
+		var next string
+		next, err = reader.ReadString('\n')
+		if next == "\n" {
+			break
+		}
+	}
+	errno := 0
+	newLevel, err := conf.ParseLogLevel(strings.TrimSpace(strings.TrimPrefix(line, logLevelOperation)))
+	if err != nil {
+		errno = 22 // EINVAL, as the device answers invalid values
+	} else {
+		level.Set(slogLevel(newLevel))
+		log.Printf("Log level set to %s", newLevel)
+	}
+	fmt.Fprintf(conn, "errno=%d\n\n", errno)
+}
+
+// SetLogLevel sets the level of the log of the running tunnel until it stops.
+func SetLogLevel(tunnelName string, level conf.LogLevel) error {
+	if level == conf.LogLevelDefault {
+		return fmt.Errorf("Log level must be verbose, info, or error")
+	}
+	path, err := services.PipePathOfTunnel(tunnelName)
+	if err != nil {
+		return err
+	}
+	conn, err := namedpipe.DialTimeout(path, time.Second*5)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s%s\n\n", logLevelOperation, level)
+	if err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != "errno=0\n" {
+		return fmt.Errorf("Unable to set log level: %s", strings.TrimSpace(reply))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+)
+
+type countingStringer struct {
+	calls int
+}
+
+func (s *countingStringer) String() string {
+	s.calls++
+	return "peer(xTIB…8Dg)"
+}
+
+func TestDeviceLogger(t *testing.T) {
+	var output strings.Builder
+	level := new(slog.LevelVar)
+	logger := slog.New(slog.NewTextHandler(&output, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}))
+	dev := DeviceLogger(logger)
+	peer := &countingStringer{}
+
+	dev.Verbosef("%v - Sending keepalive packet", peer)
+	dev.Errorf("%v - Failed to send handshake initiation: %v", peer, "no route to host")
+	if peer.calls != 1 {
+		t.Errorf("formatted %d lines, want only the error", peer.calls)
+	}
+	level.Set(ringlogger.LevelVerbose)
+	dev.Verbosef("%v - Sending keepalive packet", peer)
+
+	want := `level=ERROR msg="peer(xTIB…8Dg) - Failed to send handshake initiation: no route to host"` + "\n" +
+		`level=DEBUG msg="peer(xTIB…8Dg) - Sending keepalive packet"` + "\n"
+	if output.String() != want {
+		t.Errorf("log =\n%s\nwant\n%s", output.String(), want)
+	}
+}
+
+func TestHandleUAPILogLevel(t *testing.T) {
+	level := new(slog.LevelVar)
+	request := func(operation string) string {
+		client, server := net.Pipe()
+		defer client.Close()
+		go HandleUAPI(nil, server, level)
+		go io.WriteString(client, operation)
+		reply, err := bufio.NewReader(client).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if reply := request("log_level=verbose\n\n"); reply != "errno=0\n" || level.Level() != ringlogger.LevelVerbose {
+		t.Errorf("log_level=verbose: %q, level %v", reply, level.Level())
+	}
+	if reply := request("log_level=Error\n\n"); reply != "errno=0\n" || level.Level() != slog.LevelError {
+		t.Errorf("log_level=Error: %q, level %v", reply, level.Level())
+	}
+	if reply := request("log_level=debug\n\n"); reply != "errno=22\n" || level.Level() != slog.LevelError {
+		t.Errorf("log_level=debug: %q, level %v", reply, level.Level())
+	}
+}
+
+func TestLogRedactor(t *testing.T) {
+	config := &conf.Config{Name: "office"}
+	config.Interface.LogRedaction = conf.LogRedactAddresses
+	config.Interface.LogRedactPatterns = []string{`corp\.example`, `(`}
+	redactor := LogRedactor(config)
+	if got, want := redactor.Redact("Endpoint vpn.corp.example at 192.0.2.1"), "Endpoint vpn.(redacted) at (redacted)"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+}
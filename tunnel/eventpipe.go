@@ -0,0 +1,144 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/ipc"
+	"github.com/amnezia-vpn/amneziawg-go/v3/ipc/namedpipe"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+// The event pipe protocol is one JSON-encoded Event per line, sent by the tunnel service to every client,
+// starting with the recent history. Clients never write; closing the pipe unsubscribes.
+const eventPipeBuffer = 128
+
+type eventServer struct {
+	listener net.Listener
+	bus      *EventBus
+	clients  sync.WaitGroup
+
+	mu            sync.Mutex
+	subscriptions map[*EventSubscription]bool
+}
+
+// listenEvents serves bus on the event pipe of the tunnel, with the same permissions as its UAPI pipe.
+func listenEvents(tunnelName string, bus *EventBus) (*eventServer, error) {
+	path, err := services.EventPipePathOfTunnel(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := (&namedpipe.ListenConfig{SecurityDescriptor: ipc.UAPISecurityDescriptor}).Listen(path)
+	if err != nil {
+		return nil, err
+	}
+	server := &eventServer{listener: listener, bus: bus, subscriptions: make(map[*EventSubscription]bool)}
+	go server.accept()
+	return server, nil
+}
+
+func (server *eventServer) accept() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			if err == net.ErrClosed {
+				return
+			}
+			continue
+		}
+		server.mu.Lock()
+		if server.subscriptions == nil {
+			server.mu.Unlock()
+			conn.Close()
+			return
+		}
+		sub := server.bus.Subscribe(eventPipeBuffer, true)
+		server.subscriptions[sub] = true
+		server.clients.Add(1)
+		server.mu.Unlock()
+		go server.serve(conn, sub)
+	}
+}
+
+func (server *eventServer) serve(conn net.Conn, sub *EventSubscription) {
+	defer server.clients.Done()
+	defer conn.Close()
+	go func() {
+		io.Copy(io.Discard, conn)
+		sub.Close()
+	}()
+	encoder := json.NewEncoder(conn)
+	for event := range sub.C {
+		err := encoder.Encode(&event)
+		if err != nil {
+			sub.Close()
+			break
+		}
+	}
+	if dropped := sub.Dropped(); dropped > 0 {
+		log.Printf("Event pipe client fell behind and missed %d events", dropped)
+	}
+	server.mu.Lock()
+	delete(server.subscriptions, sub)
+	server.mu.Unlock()
+}
+
+// Close stops accepting clients and gives connected ones a moment to receive the events already queued,
+// such as the final shutdown event.
+func (server *eventServer) Close() {
+	server.listener.Close()
+	server.mu.Lock()
+	for sub := range server.subscriptions {
+		sub.Close()
+	}
+	server.subscriptions = nil
+	server.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		server.clients.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+// EventStream reads events from the event pipe of a running tunnel.
+type EventStream struct {
+	conn    net.Conn
+	decoder *json.Decoder
+}
+
+func DialEvents(tunnelName string) (*EventStream, error) {
+	path, err := services.EventPipePathOfTunnel(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := namedpipe.DialTimeout(path, time.Second*5)
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{conn: conn, decoder: json.NewDecoder(conn)}, nil
+}
+
+// Next blocks until the next event, returning io.EOF once the tunnel has shut down.
+func (stream *EventStream) Next() (Event, error) {
+	var event Event
+	err := stream.decoder.Decode(&event)
+	return event, err
+}
+
+func (stream *EventStream) Close() error {
+	return stream.conn.Close()
+}
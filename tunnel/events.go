@@ -0,0 +1,167 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+type EventKind int
+
+const (
+	EventServiceStarting EventKind = iota
+	EventWintunCreated
+	EventFirewallEnabled
+	EventPeersUp
+	EventHandshakeCompleted
+	EventHandshakeStale
+	EventEndpointChanged
+	EventDefaultRouteChanged
+	EventMTUChanged
+	EventShutdown
+	EventResolveFailed
+	EventHandshakeInitiated
+)
+
+var eventKindNames = [...]string{
+	EventServiceStarting:     "service-starting",
+	EventWintunCreated:       "wintun-created",
+	EventFirewallEnabled:     "firewall-enabled",
+	EventPeersUp:             "peers-up",
+	EventHandshakeCompleted:  "handshake-completed",
+	EventHandshakeStale:      "handshake-stale",
+	EventEndpointChanged:     "endpoint-changed",
+	EventDefaultRouteChanged: "default-route-changed",
+	EventMTUChanged:          "mtu-changed",
+	EventShutdown:            "shutdown",
+	EventResolveFailed:       "resolve-failed",
+	EventHandshakeInitiated:  "handshake-initiated",
+}
+
+func (kind EventKind) String() string {
+	if kind >= 0 && int(kind) < len(eventKindNames) {
+		return eventKindNames[kind]
+	}
+	return fmt.Sprintf("event-%d", int(kind))
+}
+
+func (kind EventKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+func (kind *EventKind) UnmarshalText(text []byte) error {
+	for i, name := range eventKindNames {
+		if name == string(text) {
+			*kind = EventKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown event kind %q", text)
+}
+
+// Event is a state transition of the tunnel. Only the fields relevant to its kind are set: Peer and Endpoint
+// for peer events, Family and Interface for default route changes, Family and MTU for MTU changes, and Error
+// for shutdown.
+type Event struct {
+	Kind      EventKind      `json:"kind"`
+	Time      time.Time      `json:"time"`
+	Peer      string         `json:"peer,omitempty"`
+	Endpoint  string         `json:"endpoint,omitempty"`
+	Family    string         `json:"family,omitempty"`
+	Interface uint32         `json:"interface,omitempty"`
+	MTU       uint32         `json:"mtu,omitempty"`
+	Error     services.Error `json:"error,omitempty"`
+	Message   string         `json:"message,omitempty"`
+}
+
+// eventHistoryLength is how many past events are replayed to new subscribers, enough to cover a startup.
+const eventHistoryLength = 32
+
+// EventBus fans events out to subscribers without ever blocking the publisher. A subscriber that falls
+// behind loses events rather than stalling the tunnel.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[*EventSubscription]bool
+	history     []Event
+	now         func() time.Time
+}
+
+type EventSubscription struct {
+	C <-chan Event
+
+	c       chan Event
+	bus     *EventBus
+	dropped atomic.Uint64
+}
+
+// Events is the bus of the tunnel running in this process.
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*EventSubscription]bool),
+		now:         time.Now,
+	}
+}
+
+// Subscribe starts delivering events, preceded by the recent history if replay is set.
+func (bus *EventBus) Subscribe(buffer int, replay bool) *EventSubscription {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if replay && buffer < len(bus.history) {
+		buffer = len(bus.history)
+	}
+	c := make(chan Event, buffer)
+	if replay {
+		for _, event := range bus.history {
+			c <- event
+		}
+	}
+	sub := &EventSubscription{C: c, c: c, bus: bus}
+	bus.subscribers[sub] = true
+	return sub
+}
+
+// Publish timestamps the event, unless it already has a time, and delivers it to all subscribers.
+func (bus *EventBus) Publish(event Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if event.Time.IsZero() {
+		event.Time = bus.now().UTC()
+	}
+	if len(bus.history) == eventHistoryLength {
+		copy(bus.history, bus.history[1:])
+		bus.history = bus.history[:eventHistoryLength-1]
+	}
+	bus.history = append(bus.history, event)
+	for sub := range bus.subscribers {
+		select {
+		case sub.c <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns how many events were lost because the subscriber's buffer was full.
+func (sub *EventSubscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+// Close stops delivery and closes C once buffered events are consumed.
+func (sub *EventSubscription) Close() {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+	if sub.bus.subscribers[sub] {
+		delete(sub.bus.subscribers, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,205 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/conn"
+	"github.com/amnezia-vpn/amneziawg-go/v3/device"
+	"github.com/amnezia-vpn/amneziawg-go/v3/tun/netstack"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+var testEventEpoch = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func receiveKinds(sub *EventSubscription) []EventKind {
+	var kinds []EventKind
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return kinds
+			}
+			kinds = append(kinds, event.Kind)
+		default:
+			return kinds
+		}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	bus.now = func() time.Time { return testEventEpoch }
+	bus.Publish(Event{Kind: EventServiceStarting})
+	bus.Publish(Event{Kind: EventWintunCreated})
+
+	live := bus.Subscribe(1, false)
+	replayed := bus.Subscribe(0, true)
+	bus.Publish(Event{Kind: EventPeersUp})
+	bus.Publish(Event{Kind: EventShutdown})
+
+	if kinds := receiveKinds(live); !reflect.DeepEqual(kinds, []EventKind{EventPeersUp}) {
+		t.Errorf("live subscriber got %v", kinds)
+	}
+	if dropped := live.Dropped(); dropped != 1 {
+		t.Errorf("live subscriber dropped %d events, want 1", dropped)
+	}
+	if kinds := receiveKinds(replayed); !reflect.DeepEqual(kinds, []EventKind{EventServiceStarting, EventWintunCreated}) {
+		t.Errorf("replaying subscriber got %v", kinds)
+	}
+
+	live.Close()
+	live.Close()
+	if _, ok := <-live.C; ok {
+		t.Error("closed subscription still delivers events")
+	}
+	bus.Publish(Event{Kind: EventMTUChanged})
+
+	for i := 0; i < eventHistoryLength+10; i++ {
+		bus.Publish(Event{Kind: EventHandshakeCompleted})
+	}
+	late := bus.Subscribe(0, true)
+	if kinds := receiveKinds(late); len(kinds) != eventHistoryLength {
+		t.Errorf("replayed %d events, want %d", len(kinds), eventHistoryLength)
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	event := Event{Kind: EventShutdown, Time: testEventEpoch, Error: services.ErrorFirewall, Message: "Unable to enable firewall rules"}
+	j, err := json.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"kind":"shutdown","time":"2021-03-01T12:00:00Z","error":6,"message":"Unable to enable firewall rules"}`; string(j) != want {
+		t.Errorf("Marshal = %s, want %s", j, want)
+	}
+	var decoded Event
+	err = json.Unmarshal(j, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, event) {
+		t.Errorf("Unmarshal = %+v, want %+v", decoded, event)
+	}
+	if err = json.Unmarshal([]byte(`{"kind":"bogus"}`), &decoded); err == nil {
+		t.Error("unknown kind was accepted")
+	}
+}
+
+func TestPeerTracker(t *testing.T) {
+	handshake := func(at time.Time) conf.HandshakeTime {
+		return conf.HandshakeTime(at.Sub(time.Unix(0, 0)))
+	}
+	snapshot := func(peers ...conf.Peer) *conf.Config {
+		return &conf.Config{Name: "test", Peers: peers}
+	}
+	peer := conf.Peer{PublicKey: conf.Key{1}, Endpoint: conf.Endpoint{Host: "192.0.2.1", Port: 51820}}
+	kinds := func(events []Event) []EventKind {
+		var kinds []EventKind
+		for _, event := range events {
+			kinds = append(kinds, event.Kind)
+		}
+		return kinds
+	}
+
+	tracker := newPeerTracker()
+	if events := tracker.observe(testEventEpoch, snapshot(peer)); len(events) != 0 {
+		t.Errorf("first observation without handshake = %v", kinds(events))
+	}
+
+	peer.LastHandshakeTime = handshake(testEventEpoch)
+	events := tracker.observe(testEventEpoch.Add(time.Second), snapshot(peer))
+	if !reflect.DeepEqual(kinds(events), []EventKind{EventHandshakeCompleted}) {
+		t.Fatalf("after handshake = %v", kinds(events))
+	}
+	if events[0].Peer != peer.PublicKey.String() || events[0].Endpoint != "192.0.2.1:51820" {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	if events := tracker.observe(testEventEpoch.Add(time.Minute), snapshot(peer)); len(events) != 0 {
+		t.Errorf("unchanged peer = %v", kinds(events))
+	}
+
+	stale := testEventEpoch.Add(handshakeStaleAfter + time.Second)
+	if events := tracker.observe(stale, snapshot(peer)); !reflect.DeepEqual(kinds(events), []EventKind{EventHandshakeStale}) {
+		t.Errorf("after stale = %v", kinds(events))
+	}
+	if events := tracker.observe(stale.Add(time.Minute), snapshot(peer)); len(events) != 0 {
+		t.Errorf("still stale = %v", kinds(events))
+	}
+
+	peer.Endpoint = conf.Endpoint{Host: "198.51.100.7", Port: 4500}
+	peer.LastHandshakeTime = handshake(stale)
+	events = tracker.observe(stale.Add(time.Second), snapshot(peer))
+	if !reflect.DeepEqual(kinds(events), []EventKind{EventEndpointChanged, EventHandshakeCompleted}) {
+		t.Errorf("after roaming = %v", kinds(events))
+	}
+
+	other := conf.Peer{PublicKey: conf.Key{2}, LastHandshakeTime: handshake(testEventEpoch)}
+	events = tracker.observe(stale.Add(2*time.Second), snapshot(other))
+	if !reflect.DeepEqual(kinds(events), []EventKind{EventHandshakeCompleted, EventHandshakeStale}) {
+		t.Errorf("new peer with old handshake = %v", kinds(events))
+	}
+	if len(tracker.peers) != 1 {
+		t.Errorf("tracking %d peers, want 1", len(tracker.peers))
+	}
+}
+
+func TestHandshakeInitiations(t *testing.T) {
+	key, err := conf.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := conf.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &conf.Config{Name: "initiator"}
+	config.Interface.PrivateKey = *key
+	config.Peers = []conf.Peer{{
+		PublicKey:           *peerKey.Public(),
+		AllowedIPs:          []conf.IPCidr{testCidr("10.79.0.0/24")},
+		Endpoint:            conf.Endpoint{Host: "127.0.0.1", Port: 52873},
+		PersistentKeepalive: "1",
+	}}
+	uapi, err := config.ToUAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := NewEventBus()
+	sub := bus.Subscribe(16, false)
+	handshakes := newHandshakeInitiations(bus)
+	tunDevice, _, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.79.0.1")}, nil, netstackDefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := device.NewDevice(tunDevice, conn.NewDefaultBind(), handshakes.wrap(&device.Logger{Verbosef: device.DiscardLogf, Errorf: device.DiscardLogf}))
+	defer dev.Close()
+	if err := dev.IpcSet(uapi); err != nil {
+		t.Fatal(err)
+	}
+	handshakes.lookupPeers(dev, config)
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keeping the peer alive starts with a handshake, which nothing answers. Seeing none means that the device
+	// no longer logs them with handshakeInitiationFormat.
+	select {
+	case event := <-sub.C:
+		if event.Kind != EventHandshakeInitiated || event.Peer != peerKey.Public().String() {
+			t.Errorf("event = %+v", event)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("no handshake initiation published")
+	}
+}
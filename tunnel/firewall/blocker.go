@@ -101,7 +101,7 @@ func registerBaseObjects(session uintptr) (*baseObjects, error) {
 	return bo, nil
 }
 
-func EnableFirewall(luid uint64, doNotRestrict bool, restrictToDNSServers []net.IP) error {
+func EnableFirewall(luid uint64, doNotRestrict bool, blockDNSLeaks bool, restrictToDNSServers []net.IP) error {
 	if wfpSession != 0 {
 		return errors.New("The firewall has already been enabled")
 	}
@@ -122,14 +122,14 @@ func EnableFirewall(luid uint64, doNotRestrict bool, restrictToDNSServers []net.
 			return wrapErr(err)
 		}
 
-		if !doNotRestrict {
-			if len(restrictToDNSServers) > 0 {
-				err = blockDNS(restrictToDNSServers, session, baseObjects, 15, 14)
-				if err != nil {
-					return wrapErr(err)
-				}
+		if (!doNotRestrict || blockDNSLeaks) && len(restrictToDNSServers) > 0 {
+			err = blockDNS(restrictToDNSServers, session, baseObjects, 15, 14)
+			if err != nil {
+				return wrapErr(err)
 			}
+		}
 
+		if !doNotRestrict {
 			err = permitLoopback(session, baseObjects, 13)
 			if err != nil {
 				return wrapErr(err)
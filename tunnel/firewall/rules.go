@@ -984,7 +984,7 @@ func blockAll(session uintptr, baseObjects *baseObjects, weight uint8) error {
 	return nil
 }
 
-// Block all DNS traffic except towards specified DNS servers.
+// Block all DNS and DNS-over-TLS traffic except towards specified DNS servers.
 func blockDNS(except []net.IP, session uintptr, baseObjects *baseObjects, weightAllow uint8, weightDeny uint8) error {
 	if weightDeny >= weightAllow {
 		return errors.New("The allow weight must be greater than the deny weight")
@@ -999,6 +999,15 @@ func blockDNS(except []net.IP, session uintptr, baseObjects *baseObjects, weight
 				value: uintptr(53),
 			},
 		},
+		// Repeat the condition type for logical OR.
+		{
+			fieldKey:  cFWPM_CONDITION_IP_REMOTE_PORT,
+			matchType: cFWP_MATCH_EQUAL,
+			conditionValue: wtFwpConditionValue0{
+				_type: cFWP_UINT16,
+				value: uintptr(853),
+			},
+		},
 		{
 			fieldKey:  cFWPM_CONDITION_IP_PROTOCOL,
 			matchType: cFWP_MATCH_EQUAL,
@@ -9,15 +9,13 @@ import (
 	"log"
 	"sync"
 
-	"golang.org/x/sys/windows"
-
 	"github.com/amnezia-vpn/amneziawg-go/v3/conn"
 	"github.com/amnezia-vpn/amneziawg-go/v3/tun"
 
 	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
 	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
-	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/firewall"
-	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/winipcfg"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/journal"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
 )
 
 type interfaceWatcherError struct {
@@ -25,70 +23,41 @@ type interfaceWatcherError struct {
 	err          error
 }
 type interfaceWatcherEvent struct {
-	luid   winipcfg.LUID
-	family winipcfg.AddressFamily
+	luid   netconfig.LUID
+	family netconfig.Family
 }
 type interfaceWatcher struct {
 	errors chan interfaceWatcherError
 
-	binder conn.BindSocketToInterface
-	conf   *conf.Config
-	tun    *tun.NativeTun
+	nc      netconfig.NetConfigurator
+	binder  conn.BindSocketToInterface
+	conf    *conf.Config
+	tun     *tun.NativeTun
+	journal *journal.Journal
 
 	setupMutex              sync.Mutex
-	interfaceChangeCallback winipcfg.ChangeCallback
-	changeCallbacks4        []winipcfg.ChangeCallback
-	changeCallbacks6        []winipcfg.ChangeCallback
+	interfaceChangeCallback netconfig.ChangeCallback
+	changeCallbacks4        []netconfig.ChangeCallback
+	changeCallbacks6        []netconfig.ChangeCallback
 	storedEvents            []interfaceWatcherEvent
 }
 
-func hasDefaultRoute(family winipcfg.AddressFamily, peers []conf.Peer) bool {
-	var (
-		foundV401    bool
-		foundV41281  bool
-		foundV600001 bool
-		foundV680001 bool
-		foundV400    bool
-		foundV600    bool
-		v40          = [4]byte{}
-		v60          = [16]byte{}
-		v48          = [4]byte{0x80}
-		v68          = [16]byte{0x80}
-	)
-	for _, peer := range peers {
-		for _, allowedip := range peer.AllowedIPs {
-			if allowedip.Cidr == 1 && len(allowedip.IP) == 16 && allowedip.IP.Equal(v60[:]) {
-				foundV600001 = true
-			} else if allowedip.Cidr == 1 && len(allowedip.IP) == 16 && allowedip.IP.Equal(v68[:]) {
-				foundV680001 = true
-			} else if allowedip.Cidr == 1 && len(allowedip.IP) == 4 && allowedip.IP.Equal(v40[:]) {
-				foundV401 = true
-			} else if allowedip.Cidr == 1 && len(allowedip.IP) == 4 && allowedip.IP.Equal(v48[:]) {
-				foundV41281 = true
-			} else if allowedip.Cidr == 0 && len(allowedip.IP) == 16 && allowedip.IP.Equal(v60[:]) {
-				foundV600 = true
-			} else if allowedip.Cidr == 0 && len(allowedip.IP) == 4 && allowedip.IP.Equal(v40[:]) {
-				foundV400 = true
-			}
-		}
-	}
-	if family == windows.AF_INET {
-		return foundV400 || (foundV401 && foundV41281)
-	} else if family == windows.AF_INET6 {
-		return foundV600 || (foundV600001 && foundV680001)
+func capturesDefaultRoute(family netconfig.Family, conf *conf.Config) bool {
+	capture := conf.DefaultRouteCapture()
+	if family == netconfig.IPv4 {
+		return capture.IPv4.Captured()
+	} else if family == netconfig.IPv6 {
+		return capture.IPv6.Captured()
 	}
 	return false
 }
 
-func (iw *interfaceWatcher) setup(family winipcfg.AddressFamily) {
-	var changeCallbacks *[]winipcfg.ChangeCallback
-	var ipversion string
-	if family == windows.AF_INET {
+func (iw *interfaceWatcher) setup(family netconfig.Family) {
+	var changeCallbacks *[]netconfig.ChangeCallback
+	if family == netconfig.IPv4 {
 		changeCallbacks = &iw.changeCallbacks4
-		ipversion = "v4"
-	} else if family == windows.AF_INET6 {
+	} else if family == netconfig.IPv6 {
 		changeCallbacks = &iw.changeCallbacks6
-		ipversion = "v6"
 	} else {
 		return
 	}
@@ -100,41 +69,43 @@ func (iw *interfaceWatcher) setup(family winipcfg.AddressFamily) {
 	}
 	var err error
 
-	log.Printf("Monitoring default %s routes", ipversion)
-	*changeCallbacks, err = monitorDefaultRoutes(family, iw.binder, iw.conf.Interface.MTU == 0, hasDefaultRoute(family, iw.conf.Peers), iw.tun)
+	log.Printf("Monitoring default %s routes", family)
+	*changeCallbacks, err = monitorDefaultRoutes(iw.nc, family, iw.binder, iw.conf.Interface.MTU == 0, capturesDefaultRoute(family, iw.conf), iw.tun)
 	if err != nil {
 		iw.errors <- interfaceWatcherError{services.ErrorBindSocketsToDefaultRoutes, err}
 		return
 	}
 
-	log.Printf("Setting device %s addresses", ipversion)
-	err = configureInterface(family, iw.conf, iw.tun)
+	log.Printf("Setting device %s addresses", family)
+	err = configureInterface(iw.nc, family, iw.conf, iw.tun, iw.journal)
 	if err != nil {
 		iw.errors <- interfaceWatcherError{services.ErrorSetNetConfig, err}
 		return
 	}
 }
 
-func watchInterface() (*interfaceWatcher, error) {
+func watchInterface(nc netconfig.NetConfigurator, journal *journal.Journal) (*interfaceWatcher, error) {
 	iw := &interfaceWatcher{
-		errors: make(chan interfaceWatcherError, 2),
+		errors:  make(chan interfaceWatcherError, 2),
+		nc:      nc,
+		journal: journal,
 	}
 	var err error
-	iw.interfaceChangeCallback, err = winipcfg.RegisterInterfaceChangeCallback(func(notificationType winipcfg.MibNotificationType, iface *winipcfg.MibIPInterfaceRow) {
+	iw.interfaceChangeCallback, err = nc.RegisterInterfaceChangeCallback(func(change netconfig.InterfaceChange) {
 		iw.setupMutex.Lock()
 		defer iw.setupMutex.Unlock()
 
-		if notificationType != winipcfg.MibAddInstance {
+		if change.Kind != netconfig.ChangeAdded {
 			return
 		}
 		if iw.tun == nil {
-			iw.storedEvents = append(iw.storedEvents, interfaceWatcherEvent{iface.InterfaceLUID, iface.Family})
+			iw.storedEvents = append(iw.storedEvents, interfaceWatcherEvent{change.Interface, change.Family})
 			return
 		}
-		if iface.InterfaceLUID != winipcfg.LUID(iw.tun.LUID()) {
+		if change.Interface != netconfig.LUID(iw.tun.LUID()) {
 			return
 		}
-		iw.setup(iface.Family)
+		iw.setup(change.Family)
 	})
 	if err != nil {
 		return nil, err
@@ -148,7 +119,7 @@ func (iw *interfaceWatcher) Configure(binder conn.BindSocketToInterface, conf *c
 
 	iw.binder, iw.conf, iw.tun = binder, conf, tun
 	for _, event := range iw.storedEvents {
-		if event.luid == winipcfg.LUID(iw.tun.LUID()) {
+		if event.luid == netconfig.LUID(iw.tun.LUID()) {
 			iw.setup(event.family)
 		}
 	}
@@ -185,17 +156,10 @@ func (iw *interfaceWatcher) Destroy() {
 		iw.changeCallbacks6 = iw.changeCallbacks6[1:]
 		changeCallbacks6 = changeCallbacks6[1:]
 	}
-	firewall.DisableFirewall()
 	if tun != nil && iw.tun == tun {
-		// It seems that the Windows networking stack doesn't like it when we destroy interfaces that have active
-		// routes, so to be certain, just remove everything before destroying.
-		luid := winipcfg.LUID(tun.LUID())
-		luid.FlushRoutes(windows.AF_INET)
-		luid.FlushIPAddresses(windows.AF_INET)
-		luid.FlushDNS(windows.AF_INET)
-		luid.FlushRoutes(windows.AF_INET6)
-		luid.FlushIPAddresses(windows.AF_INET6)
-		luid.FlushDNS(windows.AF_INET6)
+		netconfig.Deconfigure(iw.nc, netconfig.LUID(tun.LUID()))
+	} else {
+		iw.nc.DisableFirewall()
 	}
 	iw.setupMutex.Unlock()
 }
@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileBackend keeps the journal in a file, created along with its directory on first append.
+type FileBackend struct {
+	Path string
+}
+
+func (backend *FileBackend) Append(line []byte) error {
+	err := os.MkdirAll(filepath.Dir(backend.Path), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(backend.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(line)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (backend *FileBackend) ReadAll() ([]byte, error) {
+	data, err := os.ReadFile(backend.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (backend *FileBackend) Truncate() error {
+	err := os.Remove(backend.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// MemoryBackend keeps the journal in memory, where it does not survive the process. It is meant for tests.
+type MemoryBackend struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (backend *MemoryBackend) Append(line []byte) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.data = append(backend.data, line...)
+	return nil
+}
+
+func (backend *MemoryBackend) ReadAll() ([]byte, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	return append([]byte(nil), backend.data...), nil
+}
+
+func (backend *MemoryBackend) Truncate() error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.data = nil
+	return nil
+}
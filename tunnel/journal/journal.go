@@ -0,0 +1,136 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package journal is a write-ahead log of the system changes made by a tunnel, so that a tunnel that did not
+// shut down cleanly can have them undone the next time it starts.
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+type Kind string
+
+const (
+	KindAddresses Kind = "addresses"
+	KindRoutes    Kind = "routes"
+	KindDNS       Kind = "dns"
+	KindNRPT      Kind = "nrpt"
+	KindFirewall  Kind = "firewall"
+)
+
+// Record describes a change about to be made to the interface with the given GUID, for one address family
+// where that applies.
+type Record struct {
+	Kind      Kind   `json:"kind"`
+	Interface string `json:"interface,omitempty"`
+	Family    uint16 `json:"family,omitempty"`
+}
+
+// Backend stores the journal. Appends must be durable before they return.
+type Backend interface {
+	Append(line []byte) error
+	ReadAll() ([]byte, error)
+	Truncate() error
+}
+
+// Journal is safe for concurrent use. A nil *Journal records nothing, so callers need not check whether one
+// could be opened.
+type Journal struct {
+	mu       sync.Mutex
+	backend  Backend
+	recorded map[Record]bool
+	leftover []Record
+}
+
+// Open reads whatever a previous run left in the backend. Lines that cannot be parsed, such as one torn by a
+// crash while being written, are skipped.
+func Open(backend Backend) (*Journal, error) {
+	data, err := backend.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{backend: backend, recorded: make(map[Record]bool)}
+	seen := make(map[Record]bool)
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		var record Record
+		if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &record) != nil || len(record.Kind) == 0 {
+			continue
+		}
+		if !seen[record] {
+			seen[record] = true
+			j.leftover = append(j.leftover, record)
+		}
+	}
+	return j, nil
+}
+
+// Leftover returns the records of the previous run that have not been recovered.
+func (j *Journal) Leftover() []Record {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Record(nil), j.leftover...)
+}
+
+// Recover undoes the leftover records, newest first, and empties the journal. All records are attempted even
+// if some fail, and the journal is emptied regardless, as retrying on every start would not help either.
+func (j *Journal) Recover(undo func(Record) error) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var errs []error
+	for i := len(j.leftover) - 1; i >= 0; i-- {
+		if err := undo(j.leftover[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	j.leftover = nil
+	if err := j.backend.Truncate(); err != nil {
+		errs = append(errs, err)
+	}
+	j.recorded = make(map[Record]bool)
+	return errors.Join(errs...)
+}
+
+// Record durably notes a change before it is made. Recording the same change again is free.
+func (j *Journal) Record(record Record) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.recorded[record] {
+		return nil
+	}
+	line, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+	err = j.backend.Append(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	j.recorded[record] = true
+	return nil
+}
+
+// Clear empties the journal once all changes were undone by a clean shutdown.
+func (j *Journal) Clear() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.recorded = make(map[Record]bool)
+	return j.backend.Truncate()
+}
@@ -0,0 +1,112 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testInterface = "{DEADBEEF-1234-5678-0102-030405060708}"
+
+func TestJournalRecovery(t *testing.T) {
+	for _, backend := range []Backend{
+		&MemoryBackend{},
+		&FileBackend{Path: filepath.Join(t.TempDir(), "Journal", "test.jsonl")},
+	} {
+		j, err := Open(backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leftover := j.Leftover(); len(leftover) != 0 {
+			t.Errorf("%T: fresh journal has leftovers %v", backend, leftover)
+		}
+		records := []Record{
+			{Kind: KindFirewall},
+			{Kind: KindAddresses, Interface: testInterface, Family: 2},
+			{Kind: KindRoutes, Interface: testInterface, Family: 2},
+			{Kind: KindAddresses, Interface: testInterface, Family: 2},
+			{Kind: KindNRPT, Interface: testInterface},
+		}
+		for _, record := range records {
+			if err := j.Record(record); err != nil {
+				t.Fatalf("%T: Record() = %v", backend, err)
+			}
+		}
+
+		// Simulate a crash, followed by the next start, with a torn final line.
+		backend.Append([]byte(`{"kind":"dn`))
+		j, err = Open(backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Record{records[0], records[1], records[2], records[4]}
+		if leftover := j.Leftover(); !reflect.DeepEqual(leftover, want) {
+			t.Errorf("%T: Leftover() = %v, want %v", backend, leftover, want)
+		}
+		var undone []Record
+		err = j.Recover(func(record Record) error {
+			undone = append(undone, record)
+			if record.Kind == KindRoutes {
+				return errors.New("routes are gone")
+			}
+			return nil
+		})
+		if err == nil {
+			t.Errorf("%T: Recover() did not report the failed undo", backend)
+		}
+		if wantUndone := []Record{want[3], want[2], want[1], want[0]}; !reflect.DeepEqual(undone, wantUndone) {
+			t.Errorf("%T: undone %v, want %v", backend, undone, wantUndone)
+		}
+		if data, _ := backend.ReadAll(); len(data) != 0 {
+			t.Errorf("%T: journal not emptied after recovery: %q", backend, data)
+		}
+
+		// Records made before recovery count again afterwards, and a clean shutdown leaves nothing.
+		if err := j.Record(records[1]); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := backend.ReadAll(); len(data) == 0 {
+			t.Errorf("%T: record after recovery was not written", backend)
+		}
+		if err := j.Clear(); err != nil {
+			t.Fatal(err)
+		}
+		j, _ = Open(backend)
+		if leftover := j.Leftover(); len(leftover) != 0 {
+			t.Errorf("%T: leftovers after clean shutdown %v", backend, leftover)
+		}
+	}
+}
+
+func TestNilJournal(t *testing.T) {
+	var j *Journal
+	if err := j.Record(Record{Kind: KindDNS}); err != nil {
+		t.Error(err)
+	}
+	if err := j.Recover(func(Record) error { return errors.New("unexpected") }); err != nil {
+		t.Error(err)
+	}
+	if err := j.Clear(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileBackendMissing(t *testing.T) {
+	backend := &FileBackend{Path: filepath.Join(t.TempDir(), "missing", "test.jsonl")}
+	if data, err := backend.ReadAll(); data != nil || err != nil {
+		t.Errorf("ReadAll() = %q, %v", data, err)
+	}
+	if err := backend.Truncate(); err != nil {
+		t.Errorf("Truncate() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(backend.Path)); !os.IsNotExist(err) {
+		t.Errorf("directory created without appending")
+	}
+}
@@ -0,0 +1,99 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"math/bits"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/tun"
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/proxy"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/winipcfg"
+)
+
+const (
+	sockoptIP_UNICAST_IF   = 31
+	sockoptIPV6_UNICAST_IF = 31
+)
+
+// localProxy is a SOCKS5 and HTTP CONNECT proxy on loopback through which applications can use the tunnel
+// without routing their traffic into it.
+type localProxy struct {
+	server   *proxy.Server
+	listener net.Listener
+}
+
+// listenProxy starts a proxy on address, which must be a loopback address, as the proxy lets anyone who can
+// reach it use the tunnel. Clients must authenticate when credentials has a username.
+func listenProxy(address string, credentials conf.Proxy, dial proxy.DialFunc) (*localProxy, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return nil, fmt.Errorf("Proxy address %s is not a loopback address", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen for proxy clients: %w", err)
+	}
+	p := &localProxy{
+		server:   &proxy.Server{Dial: dial, Username: credentials.Username, Password: credentials.Password},
+		listener: listener,
+	}
+	go p.server.Serve(listener)
+	if len(credentials.Username) > 0 {
+		log.Printf("Proxying SOCKS5 and HTTP CONNECT on %v for user %s", listener.Addr(), credentials.Username)
+	} else {
+		log.Printf("Proxying SOCKS5 and HTTP CONNECT on %v", listener.Addr())
+	}
+	return p, nil
+}
+
+func (p *localProxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+func (p *localProxy) Close() error {
+	return p.server.Close()
+}
+
+// startInterfaceProxy serves the proxy of the configuration's Proxy key, with connections leaving through the
+// tunnel interface regardless of the routing table, so that it is of use even when AllowedIPs route little or
+// nothing into the tunnel.
+func startInterfaceProxy(config *conf.Config, tun *tun.NativeTun) (*localProxy, error) {
+	iface, err := winipcfg.LUID(tun.LUID()).Interface()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine index of interface: %w", err)
+	}
+	return listenProxy(config.Interface.Proxy.Address.String(), config.Interface.Proxy, interfaceDialer(iface.InterfaceIndex).DialContext)
+}
+
+// interfaceDialer returns a dialer whose sockets send out of the interface with the given index.
+func interfaceDialer(index uint32) *net.Dialer {
+	return &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				sockErr = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IPV6, sockoptIPV6_UNICAST_IF, int(index))
+			} else {
+				// IPv4 wants the index in network byte order, unlike IPv6.
+				sockErr = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, sockoptIP_UNICAST_IF, int(bits.ReverseBytes32(index)))
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+}
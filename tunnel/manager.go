@@ -0,0 +1,484 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/conn"
+	"github.com/amnezia-vpn/amneziawg-go/v3/device"
+	"github.com/amnezia-vpn/amneziawg-go/v3/ipc"
+	"github.com/amnezia-vpn/amneziawg-go/v3/tun"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/journal"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
+)
+
+type TunnelState int
+
+const (
+	TunnelStarting TunnelState = iota
+	TunnelRunning
+	TunnelFailed
+)
+
+func (state TunnelState) String() string {
+	switch state {
+	case TunnelStarting:
+		return "starting"
+	case TunnelRunning:
+		return "running"
+	case TunnelFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("state-%d", int(state))
+}
+
+// TunnelStatus describes a tunnel of a Manager. Err is set for failed tunnels.
+type TunnelStatus struct {
+	Name     string
+	State    TunnelState
+	Firewall bool // whether the tunnel holds the process's firewall session
+	Err      *TunnelError
+}
+
+// TunnelError is why a tunnel of a Manager failed to start or stopped on its own, in the terms a tunnel service
+// would report it.
+type TunnelError struct {
+	Name         string
+	ServiceError services.Error
+	Err          error
+}
+
+func (e *TunnelError) Error() string {
+	return fmt.Sprintf("Tunnel %s: %v", e.Name, services.CombineErrors(e.Err, e.ServiceError))
+}
+
+func (e *TunnelError) Unwrap() error {
+	if e.Err == nil {
+		return e.ServiceError
+	}
+	return e.Err
+}
+
+// ConflictError is why a Manager refused to start a tunnel alongside one it already runs.
+type ConflictError struct {
+	Name   string
+	Other  string
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Name == e.Other {
+		return fmt.Sprintf("Tunnel %s: %s", e.Name, e.Reason)
+	}
+	return fmt.Sprintf("Tunnel %s conflicts with tunnel %s: %s", e.Name, e.Other, e.Reason)
+}
+
+var (
+	ErrTunnelNotFound   = errors.New("Tunnel not found")
+	errDeviceClosed     = errors.New("Device closed unexpectedly")
+	errFirewallNotOwned = errors.New("Another tunnel holds the firewall session")
+)
+
+// Manager runs several tunnels inside a single process, where a tunnel service runs exactly one. Each tunnel
+// has its own Wintun adapter, device, interface watcher and UAPI listener, and fails without affecting the
+// others. Tunnels that conflict with running ones are refused: those that intersect according to
+// Config.IntersectsWith, those that would both route a family's default route, and those that would both
+// need firewall rules, as the process has only one firewall session.
+//
+// Unlike a tunnel service, the process keeps its privileges, as it may be asked to create adapters at any
+// time, and the tunnels share the global logger and Events.
+type Manager struct {
+	// NetConfigurator configures the interfaces of the tunnels, netconfig.System{} if nil.
+	NetConfigurator netconfig.NetConfigurator
+	// Stopped, if not nil, is called when a tunnel stops other than by Stop or Close, with the reason.
+	Stopped func(err *TunnelError)
+
+	mu            sync.Mutex
+	tunnels       map[string]*managedTunnel
+	firewallOwner string
+}
+
+type managedTunnel struct {
+	config *conf.Config
+	state  TunnelState
+	err    *TunnelError
+
+	nc        netconfig.NetConfigurator
+	firewall  bool
+	journal   *journal.Journal
+	watcher   *interfaceWatcher
+	nativeTun *tun.NativeTun
+	dev       *device.Device
+	logLevel  *slog.LevelVar
+	uapi      net.Listener
+	proxy     *localProxy
+
+	stop           chan struct{}
+	stopOnce       sync.Once
+	stopWatchdog   chan struct{}
+	watchdogFailed chan error
+	done           chan struct{}
+}
+
+// firewallGuard keeps a tunnel that does not hold the process's firewall session from replacing or removing
+// the rules of the one that does.
+type firewallGuard struct {
+	netconfig.NetConfigurator
+	owner bool
+}
+
+func (guard *firewallGuard) EnableFirewall(luid netconfig.LUID, doNotRestrict bool, blockDNSLeaks bool, dnsServers []net.IP) error {
+	if !guard.owner {
+		return errFirewallNotOwned
+	}
+	return guard.NetConfigurator.EnableFirewall(luid, doNotRestrict, blockDNSLeaks, dnsServers)
+}
+
+func (guard *firewallGuard) DisableFirewall() {
+	if guard.owner {
+		guard.NetConfigurator.DisableFirewall()
+	}
+}
+
+// needsFirewall reports whether any of the rules enableFirewall would install restrict anything.
+func needsFirewall(config *conf.Config) bool {
+	doNotRestrict, blockDNSLeaks := FirewallRestrictions(config)
+	return !doNotRestrict || blockDNSLeaks
+}
+
+// conflictReason explains why the two configurations cannot run side by side, or returns an empty string if
+// they can.
+func conflictReason(config *conf.Config, other *conf.Config) string {
+	if config.IntersectsWith(other) || other.IntersectsWith(config) {
+		return "addresses or allowed IPs overlap"
+	}
+	if config.RoutesPreempted() || other.RoutesPreempted() {
+		return ""
+	}
+	capture, otherCapture := config.DefaultRouteCapture(), other.DefaultRouteCapture()
+	if capture.IPv4.Captured() && otherCapture.IPv4.Captured() {
+		return "both route the IPv4 default route"
+	}
+	if capture.IPv6.Captured() && otherCapture.IPv6.Captured() {
+		return "both route the IPv6 default route"
+	}
+	return ""
+}
+
+// admit checks the configuration against the running tunnels and reserves its name and, if needed, the
+// firewall session.
+func (m *Manager) admit(config *conf.Config) (*managedTunnel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.tunnels[config.Name]; ok && existing.state != TunnelFailed {
+		return nil, &ConflictError{config.Name, config.Name, "already running"}
+	}
+	names := make([]string, 0, len(m.tunnels))
+	for name := range m.tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		other := m.tunnels[name]
+		if other.state == TunnelFailed || name == config.Name {
+			continue
+		}
+		if reason := conflictReason(config, other.config); len(reason) > 0 {
+			return nil, &ConflictError{config.Name, name, reason}
+		}
+	}
+	firewall := needsFirewall(config)
+	if firewall && len(m.firewallOwner) > 0 {
+		return nil, &ConflictError{config.Name, m.firewallOwner, "both need firewall rules, which only one tunnel per process can have"}
+	}
+
+	nc := m.NetConfigurator
+	if nc == nil {
+		nc = netconfig.System{}
+	}
+	t := &managedTunnel{
+		config:         config,
+		state:          TunnelStarting,
+		nc:             &firewallGuard{nc, firewall},
+		firewall:       firewall,
+		stop:           make(chan struct{}),
+		stopWatchdog:   make(chan struct{}, 1),
+		watchdogFailed: make(chan error, 1),
+		done:           make(chan struct{}),
+	}
+	if m.tunnels == nil {
+		m.tunnels = make(map[string]*managedTunnel)
+	}
+	m.tunnels[config.Name] = t
+	if firewall {
+		m.firewallOwner = config.Name
+	}
+	return t, nil
+}
+
+// release forgets the tunnel, or keeps it around as failed if err is not nil, and frees the firewall session
+// if the tunnel held it.
+func (m *Manager) release(t *managedTunnel, err *TunnelError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tunnels[t.config.Name] != t {
+		return
+	}
+	if t.firewall && m.firewallOwner == t.config.Name {
+		m.firewallOwner = ""
+	}
+	if err != nil {
+		t.state, t.err = TunnelFailed, err
+	} else {
+		delete(m.tunnels, t.config.Name)
+	}
+}
+
+// Start brings up the tunnel of the configuration, returning a *ConflictError if it cannot run alongside the
+// running tunnels and a *TunnelError if it fails to start. A tunnel of the same name that failed is replaced.
+func (m *Manager) Start(config *conf.Config) error {
+	config.DeduplicateNetworkEntries()
+	t, err := m.admit(config)
+	if err != nil {
+		return err
+	}
+	if err := t.start(); err != nil {
+		log.Println(err)
+		t.shutdown(false)
+		m.release(t, nil)
+		close(t.done)
+		return err
+	}
+	m.mu.Lock()
+	t.state = TunnelRunning
+	m.mu.Unlock()
+	go m.watch(t)
+	return nil
+}
+
+// Stop shuts the tunnel down and waits for it to be gone, or forgets it if it had failed.
+func (m *Manager) Stop(name string) error {
+	m.mu.Lock()
+	t, ok := m.tunnels[name]
+	if !ok {
+		m.mu.Unlock()
+		return ErrTunnelNotFound
+	}
+	if t.state == TunnelFailed {
+		delete(m.tunnels, name)
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+	return nil
+}
+
+// Close stops all tunnels.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.tunnels))
+	for name := range m.tunnels {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	for _, name := range names {
+		m.Stop(name)
+	}
+	return nil
+}
+
+// Tunnels lists the running and failed tunnels by name.
+func (m *Manager) Tunnels() []TunnelStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tunnels := make([]TunnelStatus, 0, len(m.tunnels))
+	for name, t := range m.tunnels {
+		tunnels = append(tunnels, TunnelStatus{Name: name, State: t.state, Firewall: t.firewall && t.state != TunnelFailed, Err: t.err})
+	}
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Name < tunnels[j].Name })
+	return tunnels
+}
+
+func (m *Manager) watch(t *managedTunnel) {
+	var err *TunnelError
+	select {
+	case <-t.stop:
+	case <-t.dev.Wait():
+		err = &TunnelError{t.config.Name, services.ErrorSuccess, errDeviceClosed}
+	case e := <-t.watcher.errors:
+		err = &TunnelError{t.config.Name, e.serviceError, e.err}
+	case e := <-t.watchdogFailed:
+		err = &TunnelError{t.config.Name, services.ErrorHandshakeTimeout, e}
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	t.shutdown(err == nil)
+	m.release(t, err)
+	close(t.done)
+	if err != nil && m.Stopped != nil {
+		m.Stopped(err)
+	}
+}
+
+func (t *managedTunnel) logf(format string, args ...any) {
+	log.Printf("[%s] "+format, append([]any{t.config.Name}, args...)...)
+}
+
+// redactLog makes the global log redact what the tunnel logs as its configuration asks, until shutdown. Lines
+// count as the tunnel's when they start with its name in brackets, as those of logf do.
+func (t *managedTunnel) redactLog() {
+	ringlogger.Global.SetRedactor(t.config.Name, LogRedactor(t.config))
+}
+
+// deviceLogger returns the logger of the device of the tunnel, whose level the UAPI pipe of the tunnel sets.
+func (t *managedTunnel) deviceLogger() *device.Logger {
+	t.logLevel = new(slog.LevelVar)
+	t.logLevel.Set(LogLevelOf(t.config))
+	return DeviceLogger(NewTunnelLogger(t.config.Name, t.logLevel))
+}
+
+// start follows the same steps as a tunnel service, leaving whatever it got to for shutdown to undo when it
+// fails.
+func (t *managedTunnel) start() *TunnelError {
+	config := t.config
+	fail := func(serviceError services.Error, err error) *TunnelError {
+		return &TunnelError{config.Name, serviceError, err}
+	}
+
+	t.redactLog()
+	t.journal = openJournal(config.Name)
+
+	t.logf("Watching network interfaces")
+	watcher, err := watchInterface(t.nc, t.journal)
+	if err != nil {
+		return fail(services.ErrorSetNetConfig, err)
+	}
+	t.watcher = watcher
+
+	t.logf("Resolving DNS names")
+	uapiConf, err := config.ToUAPI()
+	if err != nil {
+		return fail(services.ErrorDNSLookup, err)
+	}
+
+	t.logf("Creating Wintun interface")
+	wintun, err := tun.CreateTUNWithRequestedGUID(config.Name, deterministicGUID(config), 0)
+	if err != nil {
+		return fail(services.ErrorCreateWintun, err)
+	}
+	t.nativeTun = wintun.(*tun.NativeTun)
+
+	err = runScriptCommand(config.Interface.PreUp, config.Name, t.logf)
+	if err != nil {
+		wintun.Close()
+		return fail(services.ErrorRunScript, err)
+	}
+
+	if t.firewall {
+		err = enableFirewall(t.nc, config, t.nativeTun, t.journal)
+		if err != nil {
+			wintun.Close()
+			return fail(services.ErrorFirewall, err)
+		}
+	} else {
+		t.logf("Not enabling firewall rules, as none would restrict anything")
+	}
+
+	t.logf("Creating interface instance")
+	bind := conn.NewDefaultBind()
+	t.dev = device.NewDevice(wintun, bind, t.deviceLogger())
+
+	t.logf("Setting interface configuration")
+	t.uapi, err = ipc.UAPIListen(config.Name)
+	if err != nil {
+		return fail(services.ErrorUAPIListen, err)
+	}
+	err = t.dev.IpcSet(uapiConf)
+	if err != nil {
+		return fail(services.ErrorDeviceSetConfig, err)
+	}
+
+	t.logf("Bringing peers up")
+	t.dev.Up()
+	if len(config.Interface.HandshakeWatchdog) > 0 {
+		watchdog := newHandshakeWatchdog(t.dev, config, time.Now)
+		go func() {
+			if err := watchdog.run(time.Second*5, t.stopWatchdog); err != nil {
+				t.watchdogFailed <- err
+			}
+		}()
+	}
+
+	t.watcher.Configure(bind.(conn.BindSocketToInterface), config, t.nativeTun)
+
+	if !config.Interface.Proxy.IsEmpty() {
+		t.proxy, err = startInterfaceProxy(config, t.nativeTun)
+		if err != nil {
+			return fail(services.ErrorProxyListen, err)
+		}
+	}
+
+	uapi, dev, logLevel := t.uapi, t.dev, t.logLevel
+	go func() {
+		for {
+			conn, err := uapi.Accept()
+			if err != nil {
+				return
+			}
+			go HandleUAPI(dev, conn, logLevel)
+		}
+	}()
+
+	err = runScriptCommand(config.Interface.PostUp, config.Name, t.logf)
+	if err != nil {
+		return fail(services.ErrorRunScript, err)
+	}
+	t.logf("Startup complete")
+	return nil
+}
+
+// shutdown undoes start, running the down scripts only if asked to, as a tunnel service only does when it
+// stops cleanly.
+func (t *managedTunnel) shutdown(runScripts bool) {
+	config := t.config
+	if runScripts && t.dev != nil {
+		runScriptCommand(config.Interface.PreDown, config.Name, t.logf)
+	}
+	if t.watcher != nil {
+		t.watcher.Destroy()
+		t.journal.Clear()
+	}
+	t.stopWatchdog <- struct{}{}
+	if t.proxy != nil {
+		t.proxy.Close()
+	}
+	if t.uapi != nil {
+		t.uapi.Close()
+	}
+	if t.dev != nil {
+		t.dev.Close()
+	}
+	if runScripts && t.dev != nil {
+		runScriptCommand(config.Interface.PostDown, config.Name, t.logf)
+	}
+	t.logf("Shut down")
+	ringlogger.Global.SetRedactor(config.Name, nil)
+}
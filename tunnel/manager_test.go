@@ -0,0 +1,207 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
+)
+
+func testManagedConfig(name string, address string, allowedIPs ...string) *conf.Config {
+	config := &conf.Config{Name: name}
+	config.Interface.Addresses = []conf.IPCidr{testCidr(address)}
+	config.Peers = []conf.Peer{testPeer(allowedIPs...)}
+	return config
+}
+
+func TestManagerConflicts(t *testing.T) {
+	fullTunnel := testManagedConfig("full", "10.0.0.2/32", "0.0.0.0/0")
+	tests := []struct {
+		name      string
+		config    *conf.Config
+		wantOther string
+	}{
+		{"same name", testManagedConfig("full", "10.9.0.2/32", "10.9.0.0/24"), "full"},
+		{"overlapping allowed IPs", testManagedConfig("office", "10.1.0.2/32", "0.0.0.0/0", "10.1.0.0/24"), "full"},
+		{"same address", testManagedConfig("office", "10.0.0.2/32", "10.1.0.0/24"), "full"},
+		{"split default route", testManagedConfig("office", "10.1.0.2/32", "0.0.0.0/1", "128.0.0.0/1"), "full"},
+		{"kill-switch of its own", testManagedConfig("office", "10.1.0.2/32", "::/0"), "full"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{NetConfigurator: netconfig.NewMemory()}
+			if _, err := m.admit(fullTunnel); err != nil {
+				t.Fatal(err)
+			}
+			_, err := m.admit(tt.config)
+			var conflict *ConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("admit = %v, want a conflict", err)
+			}
+			if conflict.Other != tt.wantOther {
+				t.Errorf("conflicting tunnel = %q, want %q", conflict.Other, tt.wantOther)
+			}
+		})
+	}
+}
+
+func TestManagerAdmitsSplitTunnels(t *testing.T) {
+	m := &Manager{NetConfigurator: netconfig.NewMemory()}
+	configs := []*conf.Config{
+		testManagedConfig("full", "10.0.0.2/32", "0.0.0.0/0", "::/0"),
+		testManagedConfig("office", "10.1.0.2/32", "10.1.0.0/24"),
+		testManagedConfig("lab", "10.2.0.2/32", "10.2.0.0/24"),
+	}
+	tableOff := testManagedConfig("routed-elsewhere", "10.3.0.2/32", "0.0.0.0/1", "128.0.0.0/1")
+	tableOff.Interface.TableOff = true
+	configs = append(configs, tableOff)
+	for _, config := range configs {
+		if _, err := m.admit(config); err != nil {
+			t.Fatalf("admit %s = %v", config.Name, err)
+		}
+	}
+	tunnels := m.Tunnels()
+	if len(tunnels) != len(configs) {
+		t.Fatalf("tunnels = %+v", tunnels)
+	}
+	for _, status := range tunnels {
+		if status.Firewall != (status.Name == "full") {
+			t.Errorf("tunnel %s holds the firewall = %v", status.Name, status.Firewall)
+		}
+	}
+}
+
+func TestManagerRelease(t *testing.T) {
+	m := &Manager{NetConfigurator: netconfig.NewMemory()}
+	full, err := m.admit(testManagedConfig("full", "10.0.0.2/32", "0.0.0.0/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	office, err := m.admit(testManagedConfig("office", "10.1.0.2/32", "10.1.0.0/24"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tunnel that failed stays listed with its error, without holding back others.
+	failure := &TunnelError{"full", services.ErrorHandshakeTimeout, nil}
+	m.release(full, failure)
+	tunnels := m.Tunnels()
+	if len(tunnels) != 2 || tunnels[0].Name != "full" || tunnels[0].State != TunnelFailed || tunnels[0].Err != failure || tunnels[0].Firewall {
+		t.Fatalf("tunnels = %+v", tunnels)
+	}
+	if tunnels[1].Name != "office" || tunnels[1].State != TunnelStarting || tunnels[1].Err != nil {
+		t.Errorf("failure of one tunnel affected another: %+v", tunnels[1])
+	}
+	if !errors.Is(tunnels[0].Err, services.ErrorHandshakeTimeout) {
+		t.Errorf("error = %v, want it to wrap the service error", tunnels[0].Err)
+	}
+	if _, err := m.admit(testManagedConfig("full", "10.0.0.2/32", "0.0.0.0/0")); err != nil {
+		t.Errorf("admit after failure = %v", err)
+	}
+
+	// A tunnel that stopped cleanly is forgotten.
+	m.release(office, nil)
+	if err := m.Stop("office"); err != ErrTunnelNotFound {
+		t.Errorf("Stop after release = %v, want %v", err, ErrTunnelNotFound)
+	}
+}
+
+func TestManagerDeviceLogWithoutGlobalLogger(t *testing.T) {
+	defer func(global *ringlogger.Ringlogger) { ringlogger.Global = global }(ringlogger.Global)
+	ringlogger.Global = nil
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	m := &Manager{NetConfigurator: netconfig.NewMemory()}
+	office, err := m.admit(testManagedConfig("office", "10.1.0.2/32", "10.1.0.0/24"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := office.deviceLogger()
+	logger.Verbosef("%v - Sending keepalive packet", "peer(xTIB…8Dg)")
+	logger.Errorf("%v - Failed to send handshake initiation: %v", "peer(xTIB…8Dg)", "no route to host")
+
+	want := `[office] ERROR peer(xTIB…8Dg) - Failed to send handshake initiation: no route to host` + "\n"
+	if output.String() != want {
+		t.Errorf("log = %q, want %q", output.String(), want)
+	}
+}
+
+func TestFirewallGuard(t *testing.T) {
+	nc := netconfig.NewMemory()
+	nc.AddInterface(0x100, "full")
+	nc.AddInterface(0x200, "office")
+	owner := &firewallGuard{nc, true}
+	other := &firewallGuard{nc, false}
+	if err := owner.EnableFirewall(0x100, false, true, []net.IP{net.IPv4(10, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.EnableFirewall(0x200, true, false, nil); err == nil {
+		t.Error("a tunnel without the firewall session enabled the firewall")
+	}
+	netconfig.Deconfigure(other, 0x200)
+	if firewall := nc.Firewall(); firewall == nil || firewall.Interface != 0x100 {
+		t.Fatalf("firewall = %+v after deconfiguring another tunnel", firewall)
+	}
+	netconfig.Deconfigure(owner, 0x100)
+	if firewall := nc.Firewall(); firewall != nil {
+		t.Errorf("firewall = %+v after deconfiguring its tunnel", firewall)
+	}
+}
+
+func TestManagerLogRedaction(t *testing.T) {
+	rl, err := ringlogger.NewRinglogger(filepath.Join(t.TempDir(), "log.bin"), "TUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	defer func(global *ringlogger.Ringlogger) { ringlogger.Global = global }(ringlogger.Global)
+	ringlogger.Global = rl
+	log.SetOutput(rl)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	m := &Manager{NetConfigurator: netconfig.NewMemory()}
+	config := testManagedConfig("office", "10.1.0.2/32", "10.1.0.0/24")
+	config.Interface.LogRedaction = conf.LogRedactAddresses
+	office, err := m.admit(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	office.redactLog()
+	office.logf("cmd> %s", "ping 192.0.2.1")
+	office.shutdown(false)
+	office.logf("cmd> %s", "ping 192.0.2.1")
+
+	lines, _ := rl.FollowFromCursor(ringlogger.CursorAll)
+	want := []string{"[TUN] [office] cmd> ping (redacted)", "[TUN] [office] Shut down", "[TUN] [office] cmd> ping 192.0.2.1"}
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i].Line != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i].Line, want[i])
+		}
+	}
+}
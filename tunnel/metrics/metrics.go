@@ -0,0 +1,273 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package metrics collects metrics of tunnels, from their devices over UAPI when scraped and from the events of
+// the tunnel watcher as they happen, and writes them in the OpenMetrics text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is that of the OpenMetrics text format.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Device is the part of *device.Device that metrics are read from.
+type Device interface {
+	IpcGet() (string, error)
+}
+
+type peerCounters struct {
+	handshakes      uint64
+	initiations     uint64
+	endpointChanges uint64
+}
+
+type tunnelMetrics struct {
+	dev             Device
+	peers           map[string]*peerCounters
+	mtus            map[string]uint32
+	resolveFailures uint64
+	serviceError    uint32
+}
+
+// Collector keeps the metrics of tunnels. It is safe for concurrent use, and serves them over HTTP.
+type Collector struct {
+	// This is replaced by tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	tunnels map[string]*tunnelMetrics
+}
+
+func NewCollector() *Collector {
+	return &Collector{now: time.Now, tunnels: make(map[string]*tunnelMetrics)}
+}
+
+// AddTunnel starts collecting the metrics of the tunnel from its device, which is nil while there is none,
+// keeping the counters of the tunnel if it was added before.
+func (c *Collector) AddTunnel(name string, dev Device) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunnel(name).dev = dev
+}
+
+// RemoveTunnel forgets the tunnel and its counters.
+func (c *Collector) RemoveTunnel(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tunnels, name)
+}
+
+func (c *Collector) tunnel(name string) *tunnelMetrics {
+	t, ok := c.tunnels[name]
+	if !ok {
+		t = &tunnelMetrics{peers: make(map[string]*peerCounters), mtus: make(map[string]uint32)}
+		c.tunnels[name] = t
+	}
+	return t
+}
+
+func (t *tunnelMetrics) peer(publicKey string) *peerCounters {
+	p, ok := t.peers[publicKey]
+	if !ok {
+		p = &peerCounters{}
+		t.peers[publicKey] = p
+	}
+	return p
+}
+
+// HandshakeCompleted counts a handshake with the peer, whose public key is in base64.
+func (c *Collector) HandshakeCompleted(tunnel, publicKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunnel(tunnel).peer(publicKey).handshakes++
+}
+
+// HandshakeInitiated counts a handshake initiation sent to the peer, whose public key is in base64, whether
+// or not the handshake completes.
+func (c *Collector) HandshakeInitiated(tunnel, publicKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunnel(tunnel).peer(publicKey).initiations++
+}
+
+// EndpointChanged counts a change of the endpoint of the peer, whose public key is in base64.
+func (c *Collector) EndpointChanged(tunnel, publicKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunnel(tunnel).peer(publicKey).endpointChanges++
+}
+
+// MTUChanged records the MTU of the family, "v4" or "v6", of the tunnel.
+func (c *Collector) MTUChanged(tunnel, family string, mtu uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunnel(tunnel).mtus[family] = mtu
+}
+
+// ResolveFailed counts a failure to resolve the endpoints of the tunnel.
+func (c *Collector) ResolveFailed(tunnel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunnel(tunnel).resolveFailures++
+}
+
+// ServiceFailed records the services.Error that the tunnel last stopped with, or 0 if it stopped cleanly.
+func (c *Collector) ServiceFailed(tunnel string, code uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunnel(tunnel).serviceError = code
+}
+
+// metricFamily is the metadata and samples of a metric, written together as OpenMetrics requires.
+type metricFamily struct {
+	name    string
+	kind    string
+	help    string
+	samples []string
+}
+
+func (family *metricFamily) add(value any, labels ...string) {
+	var sample strings.Builder
+	sample.WriteString(family.name)
+	if family.kind == "counter" {
+		sample.WriteString("_total")
+	}
+	if len(labels) > 0 {
+		sample.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sample.WriteByte(',')
+			}
+			sample.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		sample.WriteByte('}')
+	}
+	fmt.Fprintf(&sample, " %v\n", value)
+	family.samples = append(family.samples, sample.String())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// WriteTo queries the devices of the tunnels and writes all metrics in the OpenMetrics text format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.tunnels))
+	devices := make(map[string]Device, len(c.tunnels))
+	for name, t := range c.tunnels {
+		names = append(names, name)
+		devices[name] = t.dev
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+
+	// Querying the devices without holding the lock keeps a slow device from holding up events.
+	peers := make(map[string][]peerState, len(names))
+	up := make(map[string]bool, len(names))
+	for _, name := range names {
+		if devices[name] == nil {
+			continue
+		}
+		uapi, err := devices[name].IpcGet()
+		if err != nil {
+			continue
+		}
+		up[name] = true
+		peers[name] = parseUAPI(uapi)
+	}
+
+	var (
+		tunnelUp        = &metricFamily{name: "amneziawg_tunnel_up", kind: "gauge", help: "Whether the device of the tunnel answers UAPI queries."}
+		rxBytes         = &metricFamily{name: "amneziawg_peer_receive_bytes", kind: "counter", help: "Bytes received from the peer."}
+		txBytes         = &metricFamily{name: "amneziawg_peer_transmit_bytes", kind: "counter", help: "Bytes sent to the peer."}
+		lastHandshake   = &metricFamily{name: "amneziawg_peer_last_handshake_timestamp_seconds", kind: "gauge", help: "When the latest handshake with the peer completed."}
+		handshakeAge    = &metricFamily{name: "amneziawg_peer_handshake_age_seconds", kind: "gauge", help: "How long ago the latest handshake with the peer completed."}
+		handshakes      = &metricFamily{name: "amneziawg_peer_handshakes", kind: "counter", help: "Handshakes with the peer seen completing by the tunnel watcher."}
+		attempts        = &metricFamily{name: "amneziawg_peer_handshake_attempts", kind: "counter", help: "Handshake initiations sent to the peer, whether or not they completed."}
+		endpointChanges = &metricFamily{name: "amneziawg_peer_endpoint_changes", kind: "counter", help: "Changes of the endpoint of the peer seen by the tunnel watcher."}
+		mtu             = &metricFamily{name: "amneziawg_tunnel_mtu_bytes", kind: "gauge", help: "MTU of the tunnel interface, by address family."}
+		resolveFailures = &metricFamily{name: "amneziawg_tunnel_resolve_failures", kind: "counter", help: "Failures to resolve the endpoints of the peers."}
+		serviceError    = &metricFamily{name: "amneziawg_tunnel_service_error", kind: "gauge", help: "The services.Error code that the tunnel last stopped with, 0 if it stopped cleanly."}
+	)
+	families := []*metricFamily{tunnelUp, rxBytes, txBytes, lastHandshake, handshakeAge, handshakes, attempts, endpointChanges, mtu, resolveFailures, serviceError}
+
+	now := c.now()
+	c.mu.Lock()
+	for _, name := range names {
+		t, ok := c.tunnels[name]
+		if !ok {
+			continue
+		}
+		if up[name] {
+			tunnelUp.add(1, "tunnel", name)
+		} else {
+			tunnelUp.add(0, "tunnel", name)
+		}
+		for _, peer := range peers[name] {
+			rxBytes.add(peer.rxBytes, "tunnel", name, "peer", peer.publicKey)
+			txBytes.add(peer.txBytes, "tunnel", name, "peer", peer.publicKey)
+			if !peer.lastHandshake.IsZero() {
+				lastHandshake.add(float64(peer.lastHandshake.UnixNano())/1e9, "tunnel", name, "peer", peer.publicKey)
+				handshakeAge.add(max(now.Sub(peer.lastHandshake).Seconds(), 0), "tunnel", name, "peer", peer.publicKey)
+			}
+		}
+		publicKeys := make([]string, 0, len(t.peers))
+		for publicKey := range t.peers {
+			publicKeys = append(publicKeys, publicKey)
+		}
+		sort.Strings(publicKeys)
+		for _, publicKey := range publicKeys {
+			handshakes.add(t.peers[publicKey].handshakes, "tunnel", name, "peer", publicKey)
+			attempts.add(t.peers[publicKey].initiations, "tunnel", name, "peer", publicKey)
+			endpointChanges.add(t.peers[publicKey].endpointChanges, "tunnel", name, "peer", publicKey)
+		}
+		for _, family := range []string{"v4", "v6"} {
+			if value, ok := t.mtus[family]; ok {
+				mtu.add(value, "tunnel", name, "family", family)
+			}
+		}
+		resolveFailures.add(t.resolveFailures, "tunnel", name)
+		serviceError.add(t.serviceError, "tunnel", name)
+	}
+	c.mu.Unlock()
+
+	var output strings.Builder
+	for _, family := range families {
+		if len(family.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&output, "# TYPE %s %s\n# HELP %s %s\n", family.name, family.kind, family.name, family.help)
+		for _, sample := range family.samples {
+			output.WriteString(sample)
+		}
+	}
+	output.WriteString("# EOF\n")
+	n, err := io.WriteString(w, output.String())
+	return int64(n), err
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	c.WriteTo(w)
+}
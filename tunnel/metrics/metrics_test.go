@@ -0,0 +1,151 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeDevice struct {
+	uapi string
+	err  error
+}
+
+func (dev *fakeDevice) IpcGet() (string, error) {
+	return dev.uapi, dev.err
+}
+
+const fakeUAPI = `private_key=e84b5a6d2717c1003a13b431570353dbaca9146cf150c5f8575680feba52027a
+listen_port=51820
+public_key=b85996fecc9c7f1fc6d2572a76eda11d59bcd20be8e543b15ce4bd85a8e75a33
+endpoint=192.0.2.1:51820
+last_handshake_time_sec=1700000000
+last_handshake_time_nsec=500000000
+tx_bytes=1024
+rx_bytes=2048
+persistent_keepalive_interval=25
+allowed_ip=0.0.0.0/0
+public_key=58402e695ba1772b1cc9309755f043251ea77fdcf10fbe63989ceb7e19321376
+last_handshake_time_sec=0
+last_handshake_time_nsec=0
+tx_bytes=0
+rx_bytes=0
+errno=0
+`
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	c.now = func() time.Time { return time.Unix(1700000030, 0) }
+	c.AddTunnel("office", &fakeDevice{uapi: fakeUAPI})
+	c.AddTunnel("home", &fakeDevice{err: errors.New("Device closed")})
+	c.HandshakeCompleted("office", "uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM=")
+	c.HandshakeCompleted("office", "uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM=")
+	c.HandshakeInitiated("office", "uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM=")
+	c.HandshakeInitiated("office", "uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM=")
+	c.HandshakeInitiated("office", "uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM=")
+	c.EndpointChanged("office", "uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM=")
+	c.MTUChanged("office", "v6", 1400)
+	c.MTUChanged("office", "v4", 1420)
+	c.ResolveFailed("home")
+	c.ServiceFailed("home", 5)
+
+	var output strings.Builder
+	if _, err := c.WriteTo(&output); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE amneziawg_tunnel_up gauge
+# HELP amneziawg_tunnel_up Whether the device of the tunnel answers UAPI queries.
+amneziawg_tunnel_up{tunnel="home"} 0
+amneziawg_tunnel_up{tunnel="office"} 1
+# TYPE amneziawg_peer_receive_bytes counter
+# HELP amneziawg_peer_receive_bytes Bytes received from the peer.
+amneziawg_peer_receive_bytes_total{tunnel="office",peer="uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM="} 2048
+amneziawg_peer_receive_bytes_total{tunnel="office",peer="WEAuaVuhdyscyTCXVfBDJR6nf9zxD75jmJzrfhkyE3Y="} 0
+# TYPE amneziawg_peer_transmit_bytes counter
+# HELP amneziawg_peer_transmit_bytes Bytes sent to the peer.
+amneziawg_peer_transmit_bytes_total{tunnel="office",peer="uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM="} 1024
+amneziawg_peer_transmit_bytes_total{tunnel="office",peer="WEAuaVuhdyscyTCXVfBDJR6nf9zxD75jmJzrfhkyE3Y="} 0
+# TYPE amneziawg_peer_last_handshake_timestamp_seconds gauge
+# HELP amneziawg_peer_last_handshake_timestamp_seconds When the latest handshake with the peer completed.
+amneziawg_peer_last_handshake_timestamp_seconds{tunnel="office",peer="uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM="} 1.7000000005e+09
+# TYPE amneziawg_peer_handshake_age_seconds gauge
+# HELP amneziawg_peer_handshake_age_seconds How long ago the latest handshake with the peer completed.
+amneziawg_peer_handshake_age_seconds{tunnel="office",peer="uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM="} 29.5
+# TYPE amneziawg_peer_handshakes counter
+# HELP amneziawg_peer_handshakes Handshakes with the peer seen completing by the tunnel watcher.
+amneziawg_peer_handshakes_total{tunnel="office",peer="uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM="} 2
+# TYPE amneziawg_peer_handshake_attempts counter
+# HELP amneziawg_peer_handshake_attempts Handshake initiations sent to the peer, whether or not they completed.
+amneziawg_peer_handshake_attempts_total{tunnel="office",peer="uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM="} 3
+# TYPE amneziawg_peer_endpoint_changes counter
+# HELP amneziawg_peer_endpoint_changes Changes of the endpoint of the peer seen by the tunnel watcher.
+amneziawg_peer_endpoint_changes_total{tunnel="office",peer="uFmW/sycfx/G0lcqdu2hHVm80gvo5UOxXOS9hajnWjM="} 1
+# TYPE amneziawg_tunnel_mtu_bytes gauge
+# HELP amneziawg_tunnel_mtu_bytes MTU of the tunnel interface, by address family.
+amneziawg_tunnel_mtu_bytes{tunnel="office",family="v4"} 1420
+amneziawg_tunnel_mtu_bytes{tunnel="office",family="v6"} 1400
+# TYPE amneziawg_tunnel_resolve_failures counter
+# HELP amneziawg_tunnel_resolve_failures Failures to resolve the endpoints of the peers.
+amneziawg_tunnel_resolve_failures_total{tunnel="home"} 1
+amneziawg_tunnel_resolve_failures_total{tunnel="office"} 0
+# TYPE amneziawg_tunnel_service_error gauge
+# HELP amneziawg_tunnel_service_error The services.Error code that the tunnel last stopped with, 0 if it stopped cleanly.
+amneziawg_tunnel_service_error{tunnel="home"} 5
+amneziawg_tunnel_service_error{tunnel="office"} 0
+# EOF
+`
+	if output.String() != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", output.String(), want)
+	}
+	if strings.Contains(output.String(), "e84b5a6d") {
+		t.Error("metrics leak the private key")
+	}
+
+	c.RemoveTunnel("home")
+	output.Reset()
+	c.WriteTo(&output)
+	if strings.Contains(output.String(), `"home"`) {
+		t.Errorf("removed tunnel is still in metrics:\n%s", output.String())
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got, want := escapeLabel("a\\b\"c\nd"), `a\\b\"c\nd`; got != want {
+		t.Errorf("escapeLabel = %s, want %s", got, want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	c := NewCollector()
+	c.AddTunnel("office", &fakeDevice{uapi: fakeUAPI})
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.Header.Get("Content-Type") != ContentType || !strings.HasSuffix(string(body), "# EOF\n") {
+		t.Errorf("GET = %s %q:\n%s", response.Status, response.Header.Get("Content-Type"), body)
+	}
+
+	response, err = http.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %s", response.Status)
+	}
+}
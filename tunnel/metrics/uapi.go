@@ -0,0 +1,73 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// peerState is what a UAPI get reports of a peer that metrics are made of.
+type peerState struct {
+	publicKey     string // in base64, as in configurations and events
+	endpoint      string
+	lastHandshake time.Time // zero if there was none
+	rxBytes       uint64
+	txBytes       uint64
+}
+
+// parseUAPI reads the peers of the reply to a UAPI get, ignoring the interface, whose private key it is best
+// never to hold on to.
+func parseUAPI(uapi string) []peerState {
+	var peers []peerState
+	var peer *peerState
+	var sec, nsec int64
+	finishPeer := func() {
+		if peer != nil && (sec != 0 || nsec != 0) {
+			peer.lastHandshake = time.Unix(sec, nsec)
+		}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(uapi))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		if key == "public_key" {
+			finishPeer()
+			publicKey, err := hex.DecodeString(value)
+			if err != nil {
+				peer = nil
+				continue
+			}
+			peers = append(peers, peerState{publicKey: base64.StdEncoding.EncodeToString(publicKey)})
+			peer = &peers[len(peers)-1]
+			sec, nsec = 0, 0
+			continue
+		}
+		if peer == nil {
+			continue
+		}
+		switch key {
+		case "endpoint":
+			peer.endpoint = value
+		case "last_handshake_time_sec":
+			sec, _ = strconv.ParseInt(value, 10, 64)
+		case "last_handshake_time_nsec":
+			nsec, _ = strconv.ParseInt(value, 10, 64)
+		case "rx_bytes":
+			peer.rxBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "tx_bytes":
+			peer.txBytes, _ = strconv.ParseUint(value, 10, 64)
+		}
+	}
+	finishPeer()
+	return peers
+}
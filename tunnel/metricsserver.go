@@ -0,0 +1,92 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/ipc"
+	"github.com/amnezia-vpn/amneziawg-go/v3/ipc/namedpipe"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/metrics"
+)
+
+// metricsServer serves the metrics of the tunnel in the OpenMetrics text format, counting what the tunnel
+// watcher publishes on the event bus in between scrapes.
+type metricsServer struct {
+	server    *http.Server
+	collector *metrics.Collector
+	sub       *EventSubscription
+}
+
+// startMetrics serves the metrics of dev on the listener of the configuration's Metrics key: either the
+// metrics pipe of the tunnel, with the same permissions as its UAPI pipe, or a loopback address.
+func startMetrics(config *conf.Config, dev metrics.Device, bus *EventBus) (*metricsServer, error) {
+	var listener net.Listener
+	var err error
+	if config.Interface.Metrics.Pipe {
+		var path string
+		path, err = services.MetricsPipePathOfTunnel(config.Name)
+		if err != nil {
+			return nil, err
+		}
+		listener, err = (&namedpipe.ListenConfig{SecurityDescriptor: ipc.UAPISecurityDescriptor}).Listen(path)
+	} else {
+		address := config.Interface.Metrics.Address
+		if !address.Addr().IsLoopback() {
+			return nil, fmt.Errorf("Metrics address %s is not a loopback address", address)
+		}
+		listener, err = net.Listen("tcp", address.String())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen for metrics scrapers: %w", err)
+	}
+
+	collector := metrics.NewCollector()
+	collector.AddTunnel(config.Name, dev)
+	// The failure of this run is only known once it stops, by which time it cannot be scraped, so this is the
+	// failure of the run before, which the service saved when it stopped.
+	if failure, err := services.LastFailure(config.Name); err == nil && failure != nil {
+		collector.ServiceFailed(config.Name, uint32(failure.Reason))
+	}
+	m := &metricsServer{
+		server:    &http.Server{Handler: collector, ReadHeaderTimeout: time.Second * 10},
+		collector: collector,
+		sub:       bus.Subscribe(eventPipeBuffer, true),
+	}
+	go m.count(config.Name)
+	go m.server.Serve(listener)
+	log.Printf("Serving metrics on %v", listener.Addr())
+	return m, nil
+}
+
+func (m *metricsServer) count(tunnelName string) {
+	for event := range m.sub.C {
+		switch event.Kind {
+		case EventHandshakeCompleted:
+			m.collector.HandshakeCompleted(tunnelName, event.Peer)
+		case EventHandshakeInitiated:
+			m.collector.HandshakeInitiated(tunnelName, event.Peer)
+		case EventEndpointChanged:
+			m.collector.EndpointChanged(tunnelName, event.Peer)
+		case EventMTUChanged:
+			m.collector.MTUChanged(tunnelName, event.Family, event.MTU)
+		case EventResolveFailed:
+			m.collector.ResolveFailed(tunnelName)
+		}
+	}
+}
+
+func (m *metricsServer) Close() error {
+	m.sub.Close()
+	return m.server.Close()
+}
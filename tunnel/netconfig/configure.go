@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/journal"
+)
+
+// Settings is the network configuration of a tunnel interface, for both families.
+type Settings struct {
+	Addresses       []net.IPNet
+	Routes          []Route
+	TableOff        bool   // Routes are not installed, though they still decide the metric.
+	Table           uint32 // Nonzero offsets the routes behind those of other interfaces, so they do not decide the metric.
+	MTU             uint32 // Zero leaves the MTU to the default route monitor.
+	InterfaceMetric uint32 // Zero uses the automatic metric, unless the default route is captured.
+	DNS             []net.IP
+	DNSSearch       []string
+	DNSDomains      []string // With routing domains, DNS is only used for these, through NRPT.
+	BlockDNSLeaks   bool     // The firewall lets DNS through only to the servers in DNS.
+}
+
+// ConfigureInterface applies the settings of the family to the interface, recording each kind of change in the
+// journal before making it.
+func ConfigureInterface(nc NetConfigurator, luid LUID, family Family, settings *Settings, j *journal.Journal) error {
+	record(nc, j, journal.KindAddresses, luid, family)
+	err := nc.SetAddresses(luid, family, settings.Addresses)
+	if errors.Is(err, ErrAddressExists) {
+		CleanupAddressesOnDisconnectedInterfaces(nc, family, settings.Addresses)
+		err = nc.SetAddresses(luid, family, settings.Addresses)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !settings.TableOff {
+		record(nc, j, journal.KindRoutes, luid, family)
+		err = nc.SetRoutes(luid, family, settings.Routes)
+		if err != nil {
+			return err
+		}
+	}
+
+	ifSettings := InterfaceSettings{MTU: settings.MTU, DisableRouterDiscovery: family == IPv6}
+	if settings.InterfaceMetric > 0 {
+		ifSettings.FixedMetric = true
+		ifSettings.Metric = settings.InterfaceMetric
+	} else if settings.Table == 0 && HasDefaultRoute(settings.Routes, family) {
+		ifSettings.FixedMetric = true
+		ifSettings.Metric = 0
+	}
+	err = nc.SetInterface(luid, family, ifSettings)
+	if err != nil {
+		return err
+	}
+
+	record(nc, j, journal.KindDNS, luid, family)
+	// When the firewall blocks DNS leaks, the DNS servers of the physical interfaces are unreachable, so those of
+	// the tunnel must resolve all names, routing domains or not.
+	if len(settings.DNSDomains) == 0 || settings.BlockDNSLeaks {
+		return nc.SetDNS(luid, family, settings.DNS, settings.DNSSearch)
+	}
+
+	// With routing domains, the tunnel's DNS servers are only reachable through NRPT, so that all other names
+	// continue to be resolved by the DNS servers of the physical interfaces.
+	err = nc.SetDNS(luid, family, nil, settings.DNSSearch)
+	if err != nil {
+		return err
+	}
+	record(nc, j, journal.KindNRPT, luid, family)
+	return nc.SetNRPTRule(luid, settings.DNSDomains, settings.DNS)
+}
+
+// EnableFirewall records the firewall in the journal before enabling it.
+func EnableFirewall(nc NetConfigurator, luid LUID, doNotRestrict bool, blockDNSLeaks bool, dnsServers []net.IP, j *journal.Journal) error {
+	err := j.Record(journal.Record{Kind: journal.KindFirewall})
+	if err != nil {
+		log.Printf("Warning: unable to journal firewall change: %v", err)
+	}
+	return nc.EnableFirewall(luid, doNotRestrict, blockDNSLeaks, dnsServers)
+}
+
+// Deconfigure disables the firewall and removes everything ConfigureInterface set. It seems that the Windows
+// networking stack doesn't like it when we destroy interfaces that have active routes, so this is done before
+// destroying the interface.
+func Deconfigure(nc NetConfigurator, luid LUID) {
+	nc.DisableFirewall()
+	for _, family := range []Family{IPv4, IPv6} {
+		nc.FlushRoutes(luid, family)
+		nc.FlushAddresses(luid, family)
+		nc.FlushDNS(luid, family)
+	}
+	nc.FlushNRPTRule(luid)
+}
+
+// CleanupAddressesOnDisconnectedInterfaces removes addresses from interfaces that are not up, where they may
+// have been left by a previous tunnel and now keep them from being assigned.
+func CleanupAddressesOnDisconnectedInterfaces(nc NetConfigurator, family Family, addresses []net.IPNet) {
+	if len(addresses) == 0 {
+		return
+	}
+	includedInAddresses := func(a net.IPNet) bool {
+		// TODO: this makes the whole algorithm O(n^2). But we can't stick net.IPNet in a Go hashmap. Bummer!
+		for _, addr := range addresses {
+			ip := addr.IP
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			mA, _ := addr.Mask.Size()
+			mB, _ := a.Mask.Size()
+			if bytes.Equal(ip, a.IP) && mA == mB {
+				return true
+			}
+		}
+		return false
+	}
+	interfaces, err := nc.Interfaces(family)
+	if err != nil {
+		return
+	}
+	for _, iface := range interfaces {
+		if iface.Up {
+			continue
+		}
+		for _, ipnet := range iface.Addresses {
+			if includedInAddresses(ipnet) {
+				log.Printf("Cleaning up stale address %s from interface ‘%s’", ipnet.String(), iface.Name)
+				nc.DeleteAddress(iface.Interface, ipnet)
+			}
+		}
+	}
+}
+
+// record records a change to the interface before it is made.
+func record(nc NetConfigurator, j *journal.Journal, kind journal.Kind, luid LUID, family Family) {
+	if j == nil {
+		return
+	}
+	guid, err := nc.InterfaceGUID(luid)
+	if err != nil {
+		log.Printf("Warning: unable to journal %s change: %v", kind, err)
+		return
+	}
+	err = j.Record(journal.Record{Kind: kind, Interface: guid, Family: uint16(family)})
+	if err != nil {
+		log.Printf("Warning: unable to journal %s change: %v", kind, err)
+	}
+}
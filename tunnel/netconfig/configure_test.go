@@ -0,0 +1,242 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/journal"
+)
+
+const testLUID LUID = 0x100
+
+func testSettings() *Settings {
+	addresses := testPrefixes("10.64.0.2/32", "fd00::2/128")
+	return &Settings{
+		Addresses: addresses,
+		Routes:    BuildRoutes(addresses, []Route{{Destination: testPrefix("0.0.0.0/0")}, {Destination: testPrefix("fd00::/64")}}),
+		DNS:       []net.IP{net.IPv4(10, 64, 0, 1).To4(), net.ParseIP("fd00::1")},
+		DNSSearch: []string{"example.com"},
+	}
+}
+
+func journalKinds(t *testing.T, backend journal.Backend) []journal.Kind {
+	t.Helper()
+	j, err := journal.Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []journal.Kind
+	for _, record := range j.Leftover() {
+		kinds = append(kinds, record.Kind)
+	}
+	return kinds
+}
+
+func TestConfigureInterface(t *testing.T) {
+	nc := NewMemory()
+	iface := nc.AddInterface(testLUID, "test")
+	backend := &journal.MemoryBackend{}
+	j, _ := journal.Open(backend)
+	settings := testSettings()
+
+	for _, family := range []Family{IPv4, IPv6} {
+		if err := ConfigureInterface(nc, testLUID, family, settings, j); err != nil {
+			t.Fatalf("ConfigureInterface(%v) = %v", family, err)
+		}
+	}
+	if got := iface.Addresses[IPv4]; len(got) != 1 || got[0].String() != "10.64.0.2/32" {
+		t.Errorf("v4 addresses = %v", got)
+	}
+	if got := iface.Addresses[IPv6]; len(got) != 1 || got[0].String() != "fd00::2/128" {
+		t.Errorf("v6 addresses = %v", got)
+	}
+	if len(iface.Routes[IPv4]) != 1 || len(iface.Routes[IPv6]) != 1 {
+		t.Errorf("routes = %v", iface.Routes)
+	}
+	if want := (InterfaceSettings{FixedMetric: true}); iface.IP[IPv4] != want {
+		t.Errorf("v4 interface = %+v, want %+v, as the default route is captured", iface.IP[IPv4], want)
+	}
+	if want := (InterfaceSettings{DisableRouterDiscovery: true}); iface.IP[IPv6] != want {
+		t.Errorf("v6 interface = %+v, want %+v", iface.IP[IPv6], want)
+	}
+	if len(iface.DNS[IPv4]) != 1 || len(iface.DNS[IPv6]) != 1 || !reflect.DeepEqual(iface.DNSSearch[IPv4], []string{"example.com"}) {
+		t.Errorf("DNS = %v, search = %v", iface.DNS, iface.DNSSearch)
+	}
+	if iface.NRPT != nil {
+		t.Errorf("NRPT rule = %+v, want none without routing domains", iface.NRPT)
+	}
+	want := []journal.Kind{journal.KindAddresses, journal.KindRoutes, journal.KindDNS, journal.KindAddresses, journal.KindRoutes, journal.KindDNS}
+	if got := journalKinds(t, backend); !reflect.DeepEqual(got, want) {
+		t.Errorf("journal = %v, want %v", got, want)
+	}
+
+	Deconfigure(nc, testLUID)
+	if len(iface.Addresses) != 0 || len(iface.Routes) != 0 || len(iface.DNS) != 0 {
+		t.Errorf("after Deconfigure: addresses = %v, routes = %v, DNS = %v", iface.Addresses, iface.Routes, iface.DNS)
+	}
+}
+
+func TestConfigureInterfaceSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(*Settings)
+		family     Family
+		wantIP     InterfaceSettings
+		wantRoutes int
+		wantDNS    int
+		wantNRPT   *MemoryNRPTRule
+		wantKinds  []journal.Kind
+	}{
+		{
+			name:       "interface metric",
+			change:     func(settings *Settings) { settings.InterfaceMetric = 50 },
+			family:     IPv4,
+			wantIP:     InterfaceSettings{FixedMetric: true, Metric: 50},
+			wantRoutes: 1,
+			wantDNS:    1,
+			wantKinds:  []journal.Kind{journal.KindAddresses, journal.KindRoutes, journal.KindDNS},
+		},
+		{
+			name:       "automatic metric without default route",
+			change:     func(settings *Settings) { settings.Routes = settings.Routes[1:] },
+			family:     IPv4,
+			wantRoutes: 0,
+			wantDNS:    1,
+			wantKinds:  []journal.Kind{journal.KindAddresses, journal.KindRoutes, journal.KindDNS},
+		},
+		{
+			name:      "table off",
+			change:    func(settings *Settings) { settings.TableOff = true },
+			family:    IPv4,
+			wantIP:    InterfaceSettings{FixedMetric: true},
+			wantDNS:   1,
+			wantKinds: []journal.Kind{journal.KindAddresses, journal.KindDNS},
+		},
+		{
+			name:       "numeric table",
+			change:     func(settings *Settings) { settings.Table = 1234 },
+			family:     IPv4,
+			wantRoutes: 1,
+			wantDNS:    1,
+			wantKinds:  []journal.Kind{journal.KindAddresses, journal.KindRoutes, journal.KindDNS},
+		},
+		{
+			name:       "mtu",
+			change:     func(settings *Settings) { settings.MTU = 1380 },
+			family:     IPv6,
+			wantIP:     InterfaceSettings{MTU: 1380, DisableRouterDiscovery: true},
+			wantRoutes: 1,
+			wantDNS:    1,
+			wantKinds:  []journal.Kind{journal.KindAddresses, journal.KindRoutes, journal.KindDNS},
+		},
+		{
+			name:       "routing domains",
+			change:     func(settings *Settings) { settings.DNSDomains = []string{"corp.example"} },
+			family:     IPv4,
+			wantIP:     InterfaceSettings{FixedMetric: true},
+			wantRoutes: 1,
+			wantNRPT:   &MemoryNRPTRule{Domains: []string{"corp.example"}, Servers: testSettings().DNS},
+			wantKinds:  []journal.Kind{journal.KindAddresses, journal.KindRoutes, journal.KindDNS, journal.KindNRPT},
+		},
+		{
+			name: "routing domains with dns leaks blocked",
+			change: func(settings *Settings) {
+				settings.DNSDomains = []string{"corp.example"}
+				settings.BlockDNSLeaks = true
+			},
+			family:     IPv4,
+			wantIP:     InterfaceSettings{FixedMetric: true},
+			wantRoutes: 1,
+			wantDNS:    1,
+			wantKinds:  []journal.Kind{journal.KindAddresses, journal.KindRoutes, journal.KindDNS},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc := NewMemory()
+			iface := nc.AddInterface(testLUID, "test")
+			backend := &journal.MemoryBackend{}
+			j, _ := journal.Open(backend)
+			settings := testSettings()
+			tt.change(settings)
+			if err := ConfigureInterface(nc, testLUID, tt.family, settings, j); err != nil {
+				t.Fatal(err)
+			}
+			if iface.IP[tt.family] != tt.wantIP {
+				t.Errorf("interface = %+v, want %+v", iface.IP[tt.family], tt.wantIP)
+			}
+			if len(iface.Routes[tt.family]) != tt.wantRoutes {
+				t.Errorf("routes = %v, want %d", iface.Routes[tt.family], tt.wantRoutes)
+			}
+			if len(iface.DNS[tt.family]) != tt.wantDNS {
+				t.Errorf("DNS = %v, want %d servers", iface.DNS[tt.family], tt.wantDNS)
+			}
+			if !reflect.DeepEqual(iface.NRPT, tt.wantNRPT) {
+				t.Errorf("NRPT rule = %+v, want %+v", iface.NRPT, tt.wantNRPT)
+			}
+			if got := journalKinds(t, backend); !reflect.DeepEqual(got, tt.wantKinds) {
+				t.Errorf("journal = %v, want %v", got, tt.wantKinds)
+			}
+		})
+	}
+}
+
+func TestConfigureInterfaceStaleAddresses(t *testing.T) {
+	nc := NewMemory()
+	iface := nc.AddInterface(testLUID, "test")
+	stale := nc.AddInterface(0x200, "stale")
+	stale.Up = false
+	stale.Addresses[IPv4] = testPrefixes("10.64.0.2/32", "192.168.1.2/24")
+	settings := testSettings()
+
+	if err := ConfigureInterface(nc, testLUID, IPv4, settings, nil); err != nil {
+		t.Fatalf("ConfigureInterface = %v, want the stale address cleaned up", err)
+	}
+	if len(iface.Addresses[IPv4]) != 1 {
+		t.Errorf("addresses = %v", iface.Addresses[IPv4])
+	}
+	if got := stale.Addresses[IPv4]; len(got) != 1 || got[0].String() != "192.168.1.2/24" {
+		t.Errorf("stale interface addresses = %v, want only the unrelated one left", got)
+	}
+
+	// Addresses of interfaces that are up are not taken away.
+	other := nc.AddInterface(0x300, "other")
+	other.Addresses[IPv6] = testPrefixes("fd00::2/128")
+	if err := ConfigureInterface(nc, testLUID, IPv6, settings, nil); err != ErrAddressExists {
+		t.Errorf("ConfigureInterface = %v, want %v", err, ErrAddressExists)
+	}
+	if len(other.Addresses[IPv6]) != 1 {
+		t.Errorf("addresses of interface that is up = %v", other.Addresses[IPv6])
+	}
+}
+
+func TestEnableFirewall(t *testing.T) {
+	nc := NewMemory()
+	backend := &journal.MemoryBackend{}
+	j, _ := journal.Open(backend)
+	dns := []net.IP{net.IPv4(10, 64, 0, 1).To4()}
+	if err := EnableFirewall(nc, testLUID, false, true, dns, j); err != nil {
+		t.Fatal(err)
+	}
+	want := &MemoryFirewall{Interface: testLUID, BlockDNSLeaks: true, DNSServers: dns}
+	if got := nc.Firewall(); !reflect.DeepEqual(got, want) {
+		t.Errorf("firewall = %+v, want %+v", got, want)
+	}
+	if got := journalKinds(t, backend); !reflect.DeepEqual(got, []journal.Kind{journal.KindFirewall}) {
+		t.Errorf("journal = %v", got)
+	}
+	if err := EnableFirewall(nc, testLUID, false, true, dns, j); err == nil {
+		t.Error("enabling the firewall twice succeeded")
+	}
+	nc.AddInterface(testLUID, "test")
+	Deconfigure(nc, testLUID)
+	if nc.Firewall() != nil {
+		t.Error("firewall still enabled after Deconfigure")
+	}
+}
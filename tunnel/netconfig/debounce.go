@@ -0,0 +1,38 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"sync"
+	"time"
+)
+
+// Debounce returns a function that coalesces bursts of calls into calls to update, which happen once the calls
+// pause for quiet, or right away when a burst has lasted longer than burst, so that a stream of changes cannot
+// hold off updates forever.
+func Debounce(quiet, burst time.Duration, update func()) func() {
+	firstBurst := time.Time{}
+	burstMutex := sync.Mutex{}
+	burstTimer := time.AfterFunc(time.Hour*200, func() {
+		burstMutex.Lock()
+		firstBurst = time.Time{}
+		update()
+		burstMutex.Unlock()
+	})
+	burstTimer.Stop()
+	return func() {
+		burstMutex.Lock()
+		burstTimer.Reset(quiet)
+		if firstBurst.IsZero() {
+			firstBurst = time.Now()
+		} else if time.Since(firstBurst) > burst {
+			firstBurst = time.Time{}
+			burstTimer.Stop()
+			update()
+		}
+		burstMutex.Unlock()
+	}
+}
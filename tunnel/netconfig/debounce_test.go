@@ -0,0 +1,43 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	var updates atomic.Int32
+	done := make(chan struct{}, 1)
+	bump := Debounce(time.Millisecond*20, time.Hour, func() {
+		updates.Add(1)
+		done <- struct{}{}
+	})
+	for i := 0; i < 3; i++ {
+		bump()
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("no update after the burst")
+	}
+	time.Sleep(time.Millisecond * 50)
+	if n := updates.Load(); n != 1 {
+		t.Errorf("burst caused %d updates, want 1", n)
+	}
+
+	// A burst that has gone on for too long updates right away.
+	updates.Store(0)
+	bump = Debounce(time.Hour, 0, func() { updates.Add(1) })
+	bump()
+	time.Sleep(time.Millisecond)
+	bump()
+	if n := updates.Load(); n != 1 {
+		t.Errorf("long burst caused %d updates, want 1", n)
+	}
+}
@@ -0,0 +1,150 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"log"
+	"time"
+)
+
+// Binder binds the tunnel's sockets to an interface, as conn.BindSocketToInterface does.
+type Binder interface {
+	BindSocketToInterface4(interfaceIndex uint32, blackhole bool) error
+	BindSocketToInterface6(interfaceIndex uint32, blackhole bool) error
+}
+
+// BestDefaultRoute picks the default route with the lowest metric through an interface that is up other than
+// ours. Zeros are returned when there is none, and an index of zero resets the binding of the sockets.
+func BestDefaultRoute(routes []DefaultRoute, ours LUID) (luid LUID, index uint32) {
+	lowestMetric := ^uint32(0)
+	for _, route := range routes {
+		if route.Interface == ours || !route.Up {
+			continue
+		}
+		if route.Metric < lowestMetric {
+			lowestMetric = route.Metric
+			index = route.Index
+			luid = route.Interface
+		}
+	}
+	return
+}
+
+// DefaultRouteMonitor keeps the tunnel's sockets bound to the interface of the best default route of a
+// family, so that the tunnel's own traffic does not loop through it, and optionally has the tunnel interface
+// follow the MTU of that interface.
+type DefaultRouteMonitor struct {
+	NetConfigurator NetConfigurator
+	Family          Family
+	Interface       LUID
+	Binder          Binder
+	AutoMTU         bool
+	// BlackholeWhenLoop drops the tunnel's traffic instead of letting it loop when there is no default route
+	// other than the tunnel's.
+	BlackholeWhenLoop bool
+
+	Bound      func(index uint32)
+	MTUChanged func(mtu uint32)
+
+	bound     bool
+	lastLUID  LUID
+	lastIndex uint32
+	lastMTU   uint32
+}
+
+// Update rebinds the sockets and adjusts the MTU if the best default route changed.
+func (monitor *DefaultRouteMonitor) Update() error {
+	err := monitor.bind()
+	if err != nil {
+		return err
+	}
+	if !monitor.AutoMTU {
+		return nil
+	}
+	mtu := uint32(0)
+	if monitor.lastLUID != 0 {
+		mtu, err = monitor.NetConfigurator.LinkMTU(monitor.lastLUID)
+		if err != nil {
+			return err
+		}
+	}
+	if mtu > 0 && monitor.lastMTU != mtu {
+		var minMTU uint32
+		if monitor.Family == IPv4 {
+			minMTU = 576
+		} else if monitor.Family == IPv6 {
+			minMTU = 1280
+		}
+		ourMTU := mtu - 80
+		if mtu < 80 || ourMTU < minMTU {
+			ourMTU = minMTU
+		}
+		err = monitor.NetConfigurator.SetInterface(monitor.Interface, monitor.Family, InterfaceSettings{MTU: ourMTU})
+		if err != nil {
+			return err
+		}
+		monitor.lastMTU = mtu
+		if monitor.MTUChanged != nil {
+			monitor.MTUChanged(ourMTU)
+		}
+	}
+	return nil
+}
+
+func (monitor *DefaultRouteMonitor) bind() error {
+	routes, err := monitor.NetConfigurator.DefaultRoutes(monitor.Family)
+	if err != nil {
+		return err
+	}
+	luid, index := BestDefaultRoute(routes, monitor.Interface)
+	if monitor.bound && luid == monitor.lastLUID && index == monitor.lastIndex {
+		return nil
+	}
+	monitor.bound = true
+	monitor.lastLUID = luid
+	monitor.lastIndex = index
+	blackhole := monitor.BlackholeWhenLoop && index == 0
+	log.Printf("Binding %s socket to interface %d (blackhole=%v)", monitor.Family, index, blackhole)
+	if monitor.Family == IPv4 {
+		err = monitor.Binder.BindSocketToInterface4(index, blackhole)
+	} else if monitor.Family == IPv6 {
+		err = monitor.Binder.BindSocketToInterface6(index, blackhole)
+	}
+	if err == nil && monitor.Bound != nil {
+		monitor.Bound(index)
+	}
+	return err
+}
+
+// Start updates once and then again whenever a default route or interface parameters change, coalescing
+// bursts of changes.
+func (monitor *DefaultRouteMonitor) Start() ([]ChangeCallback, error) {
+	err := monitor.Update()
+	if err != nil {
+		return nil, err
+	}
+
+	bump := Debounce(time.Millisecond*150, time.Second*2, func() { monitor.Update() })
+
+	cbr, err := monitor.NetConfigurator.RegisterRouteChangeCallback(func(change RouteChange) {
+		if change.PrefixLength == 0 {
+			bump()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	cbi, err := monitor.NetConfigurator.RegisterInterfaceChangeCallback(func(change InterfaceChange) {
+		if change.Kind == ChangeParameters {
+			bump()
+		}
+	})
+	if err != nil {
+		cbr.Unregister()
+		return nil, err
+	}
+	return []ChangeCallback{cbr, cbi}, nil
+}
@@ -0,0 +1,151 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeBinder struct {
+	mu       sync.Mutex
+	bindings []string
+}
+
+func (binder *fakeBinder) BindSocketToInterface4(interfaceIndex uint32, blackhole bool) error {
+	binder.mu.Lock()
+	defer binder.mu.Unlock()
+	binder.bindings = append(binder.bindings, fmt.Sprintf("v4 %d blackhole=%v", interfaceIndex, blackhole))
+	return nil
+}
+
+func (binder *fakeBinder) BindSocketToInterface6(interfaceIndex uint32, blackhole bool) error {
+	binder.mu.Lock()
+	defer binder.mu.Unlock()
+	binder.bindings = append(binder.bindings, fmt.Sprintf("v6 %d blackhole=%v", interfaceIndex, blackhole))
+	return nil
+}
+
+func (binder *fakeBinder) take() []string {
+	binder.mu.Lock()
+	defer binder.mu.Unlock()
+	bindings := binder.bindings
+	binder.bindings = nil
+	return bindings
+}
+
+func TestBestDefaultRoute(t *testing.T) {
+	routes := []DefaultRoute{
+		{Interface: testLUID, Index: 1, Metric: 0, Up: true},
+		{Interface: 0x200, Index: 2, Metric: 50, Up: true},
+		{Interface: 0x300, Index: 3, Metric: 10, Up: false},
+		{Interface: 0x400, Index: 4, Metric: 25, Up: true},
+	}
+	if luid, index := BestDefaultRoute(routes, testLUID); luid != 0x400 || index != 4 {
+		t.Errorf("BestDefaultRoute = %#x/%d, want the lowest metric other than ours that is up", luid, index)
+	}
+	if luid, index := BestDefaultRoute(routes[:1], testLUID); luid != 0 || index != 0 {
+		t.Errorf("BestDefaultRoute = %#x/%d, want none when only ours exists", luid, index)
+	}
+}
+
+func TestDefaultRouteMonitor(t *testing.T) {
+	nc := NewMemory()
+	ours := nc.AddInterface(testLUID, "test")
+	nc.AddInterface(0x200, "ethernet").LinkMTU = 1500
+	nc.AddInterface(0x300, "cellular").LinkMTU = 1280
+	binder := &fakeBinder{}
+	var bound []uint32
+	var mtus []uint32
+	monitor := &DefaultRouteMonitor{
+		NetConfigurator:   nc,
+		Family:            IPv6,
+		Interface:         testLUID,
+		Binder:            binder,
+		AutoMTU:           true,
+		BlackholeWhenLoop: true,
+		Bound:             func(index uint32) { bound = append(bound, index) },
+		MTUChanged:        func(mtu uint32) { mtus = append(mtus, mtu) },
+	}
+
+	// With no default route, the sockets are still bound once, to nothing.
+	if err := monitor.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if got := binder.take(); !reflect.DeepEqual(got, []string{"v6 0 blackhole=true"}) {
+		t.Errorf("bindings = %q", got)
+	}
+
+	nc.SetDefaultRoutes(IPv6, []DefaultRoute{{Interface: testLUID, Index: 1, Up: true}, {Interface: 0x200, Index: 2, Metric: 25, Up: true}})
+	if err := monitor.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitor.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if got := binder.take(); !reflect.DeepEqual(got, []string{"v6 2 blackhole=false"}) {
+		t.Errorf("bindings = %q, want a single rebind", got)
+	}
+	if ours.IP[IPv6].MTU != 1420 {
+		t.Errorf("MTU = %d, want 80 less than that of the default route's interface", ours.IP[IPv6].MTU)
+	}
+
+	nc.SetDefaultRoutes(IPv6, []DefaultRoute{{Interface: 0x200, Index: 2, Metric: 25, Up: false}, {Interface: 0x300, Index: 3, Metric: 100, Up: true}})
+	if err := monitor.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if got := binder.take(); !reflect.DeepEqual(got, []string{"v6 3 blackhole=false"}) {
+		t.Errorf("bindings = %q", got)
+	}
+	if ours.IP[IPv6].MTU != 1280 {
+		t.Errorf("MTU = %d, want the IPv6 minimum", ours.IP[IPv6].MTU)
+	}
+	if !reflect.DeepEqual(bound, []uint32{0, 2, 3}) || !reflect.DeepEqual(mtus, []uint32{1420, 1280}) {
+		t.Errorf("bound = %v, MTUs = %v", bound, mtus)
+	}
+}
+
+func TestDefaultRouteMonitorNotifications(t *testing.T) {
+	nc := NewMemory()
+	nc.AddInterface(testLUID, "test")
+	binder := &fakeBinder{}
+	monitor := &DefaultRouteMonitor{NetConfigurator: nc, Family: IPv4, Interface: testLUID, Binder: binder}
+	callbacks, err := monitor.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binder.take()
+
+	nc.SetDefaultRoutes(IPv4, []DefaultRoute{{Interface: 0x200, Index: 2, Up: true}})
+	nc.NotifyRouteChange(RouteChange{Kind: ChangeAdded, PrefixLength: 24})
+	time.Sleep(300 * time.Millisecond)
+	if got := binder.take(); len(got) != 0 {
+		t.Errorf("bindings = %q after a change to a route that is not a default route", got)
+	}
+	nc.NotifyRouteChange(RouteChange{Kind: ChangeAdded, PrefixLength: 0})
+	deadline := time.Now().Add(5 * time.Second)
+	var got []string
+	for len(got) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		got = binder.take()
+	}
+	if !reflect.DeepEqual(got, []string{"v4 2 blackhole=false"}) {
+		t.Errorf("bindings = %q after a default route change", got)
+	}
+
+	for _, cb := range callbacks {
+		cb.Unregister()
+	}
+	nc.SetDefaultRoutes(IPv4, nil)
+	nc.NotifyRouteChange(RouteChange{Kind: ChangeDeleted, PrefixLength: 0})
+	time.Sleep(300 * time.Millisecond)
+	if got := binder.take(); len(got) != 0 {
+		t.Errorf("bindings = %q after unregistering", got)
+	}
+}
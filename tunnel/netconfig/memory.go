@@ -0,0 +1,374 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Memory is a NetConfigurator that keeps the configuration of fake interfaces in memory. It is meant for tests.
+type Memory struct {
+	mu                 sync.Mutex
+	interfaces         map[LUID]*MemoryInterface
+	defaultRoutes      map[Family][]DefaultRoute
+	firewall           *MemoryFirewall
+	routeCallbacks     map[*memoryCallback]func(RouteChange)
+	interfaceCallbacks map[*memoryCallback]func(InterfaceChange)
+}
+
+// MemoryInterface is the state of a fake interface. It must only be read while the Memory is not in use.
+type MemoryInterface struct {
+	Name      string
+	GUID      string
+	Up        bool
+	LinkMTU   uint32
+	Addresses map[Family][]net.IPNet
+	Routes    map[Family][]Route
+	IP        map[Family]InterfaceSettings
+	DNS       map[Family][]net.IP
+	DNSSearch map[Family][]string
+	NRPT      *MemoryNRPTRule
+}
+
+type MemoryNRPTRule struct {
+	Domains []string
+	Servers []net.IP
+}
+
+type MemoryFirewall struct {
+	Interface     LUID
+	DoNotRestrict bool
+	BlockDNSLeaks bool
+	DNSServers    []net.IP
+}
+
+type memoryCallback struct {
+	memory *Memory
+}
+
+func (callback *memoryCallback) Unregister() error {
+	callback.memory.mu.Lock()
+	defer callback.memory.mu.Unlock()
+	delete(callback.memory.routeCallbacks, callback)
+	delete(callback.memory.interfaceCallbacks, callback)
+	return nil
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		interfaces:         make(map[LUID]*MemoryInterface),
+		defaultRoutes:      make(map[Family][]DefaultRoute),
+		routeCallbacks:     make(map[*memoryCallback]func(RouteChange)),
+		interfaceCallbacks: make(map[*memoryCallback]func(InterfaceChange)),
+	}
+}
+
+// AddInterface adds a fake interface, which is up and has no configuration.
+func (memory *Memory) AddInterface(luid LUID, name string) *MemoryInterface {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface := &MemoryInterface{
+		Name:      name,
+		GUID:      fmt.Sprintf("{00000000-0000-0000-0000-%012x}", uint64(luid)),
+		Up:        true,
+		Addresses: make(map[Family][]net.IPNet),
+		Routes:    make(map[Family][]Route),
+		IP:        make(map[Family]InterfaceSettings),
+		DNS:       make(map[Family][]net.IP),
+		DNSSearch: make(map[Family][]string),
+	}
+	memory.interfaces[luid] = iface
+	return iface
+}
+
+// Interface returns the fake interface, or nil if there is none.
+func (memory *Memory) Interface(luid LUID) *MemoryInterface {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	return memory.interfaces[luid]
+}
+
+// SetDefaultRoutes replaces what DefaultRoutes returns for the family.
+func (memory *Memory) SetDefaultRoutes(family Family, routes []DefaultRoute) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	memory.defaultRoutes[family] = routes
+}
+
+// Firewall returns the firewall that is enabled, or nil if it is not.
+func (memory *Memory) Firewall() *MemoryFirewall {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	return memory.firewall
+}
+
+// NotifyRouteChange calls the registered route change callbacks.
+func (memory *Memory) NotifyRouteChange(change RouteChange) {
+	memory.mu.Lock()
+	callbacks := make([]func(RouteChange), 0, len(memory.routeCallbacks))
+	for _, callback := range memory.routeCallbacks {
+		callbacks = append(callbacks, callback)
+	}
+	memory.mu.Unlock()
+	for _, callback := range callbacks {
+		callback(change)
+	}
+}
+
+// NotifyInterfaceChange calls the registered interface change callbacks.
+func (memory *Memory) NotifyInterfaceChange(change InterfaceChange) {
+	memory.mu.Lock()
+	callbacks := make([]func(InterfaceChange), 0, len(memory.interfaceCallbacks))
+	for _, callback := range memory.interfaceCallbacks {
+		callbacks = append(callbacks, callback)
+	}
+	memory.mu.Unlock()
+	for _, callback := range callbacks {
+		callback(change)
+	}
+}
+
+func (memory *Memory) lookup(luid LUID) (*MemoryInterface, error) {
+	iface := memory.interfaces[luid]
+	if iface == nil {
+		return nil, fmt.Errorf("no interface with LUID %#x", uint64(luid))
+	}
+	return iface, nil
+}
+
+func (memory *Memory) SetAddresses(luid LUID, family Family, addresses []net.IPNet) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	var kept []net.IPNet
+	for _, address := range addresses {
+		if familyOf(address) != family {
+			continue
+		}
+		for otherLUID, other := range memory.interfaces {
+			if otherLUID == luid {
+				continue
+			}
+			for _, otherAddress := range other.Addresses[family] {
+				if otherAddress.IP.Equal(address.IP) {
+					return ErrAddressExists
+				}
+			}
+		}
+		kept = append(kept, address)
+	}
+	iface.Addresses[family] = kept
+	return nil
+}
+
+func (memory *Memory) FlushAddresses(luid LUID, family Family) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	delete(iface.Addresses, family)
+	return nil
+}
+
+func (memory *Memory) DeleteAddress(luid LUID, address net.IPNet) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	family := familyOf(address)
+	addresses := iface.Addresses[family]
+	for i := range addresses {
+		if addresses[i].String() == address.String() {
+			iface.Addresses[family] = append(addresses[:i:i], addresses[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("address %s not found", address.String())
+}
+
+func (memory *Memory) Interfaces(family Family) ([]InterfaceAddresses, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	interfaces := make([]InterfaceAddresses, 0, len(memory.interfaces))
+	for luid, iface := range memory.interfaces {
+		interfaces = append(interfaces, InterfaceAddresses{
+			Interface: luid,
+			Name:      iface.Name,
+			Up:        iface.Up,
+			Addresses: append([]net.IPNet(nil), iface.Addresses[family]...),
+		})
+	}
+	return interfaces, nil
+}
+
+func (memory *Memory) SetRoutes(luid LUID, family Family, routes []Route) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	var kept []Route
+	for _, route := range routes {
+		if familyOf(route.Destination) == family {
+			kept = append(kept, route)
+		}
+	}
+	iface.Routes[family] = kept
+	return nil
+}
+
+func (memory *Memory) FlushRoutes(luid LUID, family Family) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	delete(iface.Routes, family)
+	return nil
+}
+
+func (memory *Memory) DefaultRoutes(family Family) ([]DefaultRoute, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	return append([]DefaultRoute(nil), memory.defaultRoutes[family]...), nil
+}
+
+func (memory *Memory) SetInterface(luid LUID, family Family, settings InterfaceSettings) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	current := iface.IP[family]
+	if settings.MTU > 0 {
+		current.MTU = settings.MTU
+	}
+	if settings.FixedMetric {
+		current.FixedMetric = true
+		current.Metric = settings.Metric
+	}
+	if settings.DisableRouterDiscovery {
+		current.DisableRouterDiscovery = true
+	}
+	iface.IP[family] = current
+	return nil
+}
+
+func (memory *Memory) LinkMTU(luid LUID) (uint32, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return 0, err
+	}
+	return iface.LinkMTU, nil
+}
+
+func (memory *Memory) InterfaceGUID(luid LUID) (string, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return "", err
+	}
+	return iface.GUID, nil
+}
+
+func (memory *Memory) SetDNS(luid LUID, family Family, servers []net.IP, domains []string) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	var kept []net.IP
+	for _, server := range servers {
+		if (server.To4() != nil) == (family == IPv4) {
+			kept = append(kept, server)
+		}
+	}
+	iface.DNS[family] = kept
+	iface.DNSSearch[family] = domains
+	return nil
+}
+
+func (memory *Memory) FlushDNS(luid LUID, family Family) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	delete(iface.DNS, family)
+	delete(iface.DNSSearch, family)
+	return nil
+}
+
+func (memory *Memory) SetNRPTRule(luid LUID, domains []string, servers []net.IP) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	iface.NRPT = &MemoryNRPTRule{Domains: domains, Servers: servers}
+	return nil
+}
+
+func (memory *Memory) FlushNRPTRule(luid LUID) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	iface, err := memory.lookup(luid)
+	if err != nil {
+		return err
+	}
+	iface.NRPT = nil
+	return nil
+}
+
+func (memory *Memory) RegisterRouteChangeCallback(callback func(change RouteChange)) (ChangeCallback, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	registration := &memoryCallback{memory}
+	memory.routeCallbacks[registration] = callback
+	return registration, nil
+}
+
+func (memory *Memory) RegisterInterfaceChangeCallback(callback func(change InterfaceChange)) (ChangeCallback, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	registration := &memoryCallback{memory}
+	memory.interfaceCallbacks[registration] = callback
+	return registration, nil
+}
+
+func (memory *Memory) EnableFirewall(luid LUID, doNotRestrict bool, blockDNSLeaks bool, dnsServers []net.IP) error {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	if memory.firewall != nil {
+		return fmt.Errorf("firewall already enabled for interface %#x", uint64(memory.firewall.Interface))
+	}
+	memory.firewall = &MemoryFirewall{Interface: luid, DoNotRestrict: doNotRestrict, BlockDNSLeaks: blockDNSLeaks, DNSServers: dnsServers}
+	return nil
+}
+
+func (memory *Memory) DisableFirewall() {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	memory.firewall = nil
+}
@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package netconfig configures the network interface of a tunnel, its addresses, routes, DNS and firewall,
+// through a NetConfigurator, which on Windows is System.
+package netconfig
+
+import (
+	"errors"
+	"net"
+)
+
+// Family is an address family, using the values of windows.AF_INET and windows.AF_INET6.
+type Family uint16
+
+const (
+	IPv4 Family = 2
+	IPv6 Family = 23
+)
+
+func (family Family) String() string {
+	switch family {
+	case IPv4:
+		return "v4"
+	case IPv6:
+		return "v6"
+	}
+	return ""
+}
+
+// familyOf returns the family of a prefix, judging by the length of its mask.
+func familyOf(prefix net.IPNet) Family {
+	if _, bits := prefix.Mask.Size(); bits == 32 {
+		return IPv4
+	} else if bits == 128 {
+		return IPv6
+	}
+	return 0
+}
+
+// LUID is the locally unique identifier of an interface.
+type LUID uint64
+
+type Route struct {
+	Destination net.IPNet
+	NextHop     net.IP
+	Metric      uint32
+}
+
+// InterfaceSettings are the IP interface parameters to change, leaving the others as they are.
+type InterfaceSettings struct {
+	MTU                    uint32 // Zero keeps the current MTU.
+	FixedMetric            bool   // Replaces the automatic metric with Metric.
+	Metric                 uint32
+	DisableRouterDiscovery bool // Also disables duplicate address detection.
+}
+
+// DefaultRoute is a default route of some interface, with Metric being that of the route plus that of the
+// interface, as Windows ranks them.
+type DefaultRoute struct {
+	Interface LUID
+	Index     uint32
+	Metric    uint32
+	Up        bool
+}
+
+// InterfaceAddresses are the unicast addresses of an interface for one family.
+type InterfaceAddresses struct {
+	Interface LUID
+	Name      string
+	Up        bool
+	Addresses []net.IPNet
+}
+
+type ChangeKind int
+
+const (
+	ChangeParameters ChangeKind = iota
+	ChangeAdded
+	ChangeDeleted
+)
+
+type InterfaceChange struct {
+	Kind      ChangeKind
+	Interface LUID
+	Family    Family
+}
+
+type RouteChange struct {
+	Kind         ChangeKind
+	PrefixLength uint8
+}
+
+type ChangeCallback interface {
+	Unregister() error
+}
+
+// ErrAddressExists is returned by SetAddresses when an address is already assigned to another interface.
+var ErrAddressExists = errors.New("address already exists on another interface")
+
+// NetConfigurator makes the changes to the system's network configuration that a tunnel needs.
+type NetConfigurator interface {
+	// SetAddresses replaces the addresses of the family of an interface with those of the family among
+	// addresses.
+	SetAddresses(luid LUID, family Family, addresses []net.IPNet) error
+	FlushAddresses(luid LUID, family Family) error
+	DeleteAddress(luid LUID, address net.IPNet) error
+	Interfaces(family Family) ([]InterfaceAddresses, error)
+
+	// SetRoutes replaces the routes of the family of an interface with those of the family among routes.
+	SetRoutes(luid LUID, family Family, routes []Route) error
+	FlushRoutes(luid LUID, family Family) error
+	DefaultRoutes(family Family) ([]DefaultRoute, error)
+
+	SetInterface(luid LUID, family Family, settings InterfaceSettings) error
+	LinkMTU(luid LUID) (uint32, error)
+	InterfaceGUID(luid LUID) (string, error)
+
+	SetDNS(luid LUID, family Family, servers []net.IP, domains []string) error
+	FlushDNS(luid LUID, family Family) error
+	SetNRPTRule(luid LUID, domains []string, servers []net.IP) error
+	FlushNRPTRule(luid LUID) error
+
+	RegisterRouteChangeCallback(callback func(change RouteChange)) (ChangeCallback, error)
+	RegisterInterfaceChangeCallback(callback func(change InterfaceChange)) (ChangeCallback, error)
+
+	EnableFirewall(luid LUID, doNotRestrict bool, blockDNSLeaks bool, dnsServers []net.IP) error
+	DisableFirewall()
+}
@@ -0,0 +1,81 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"sort"
+)
+
+// TableMetric offsets a route metric by the numeric routing table. Windows has no policy routing tables, so
+// Table = <n> instead installs the tunnel's routes n metric points behind those of the main table, letting
+// routes of other interfaces to the same destinations take precedence.
+func TableMetric(table, metric uint32) uint32 {
+	if metric > math.MaxUint32-table {
+		return math.MaxUint32
+	}
+	return table + metric
+}
+
+// BuildRoutes turns the destinations and metrics of candidates into on-link routes, skipping those of a family
+// without an address among addresses. Duplicates differing only by metric keep the lowest metric, as Windows
+// keys routes by destination and next hop alone.
+func BuildRoutes(addresses []net.IPNet, candidates []Route) []Route {
+	haveAddress := make(map[Family]bool, 2)
+	for _, address := range addresses {
+		haveAddress[familyOf(address)] = true
+	}
+
+	allRoutes := make([]Route, 0, len(candidates))
+	for _, candidate := range candidates {
+		family := familyOf(candidate.Destination)
+		if !haveAddress[family] {
+			continue
+		}
+		route := Route{Destination: net.IPNet{IP: candidate.Destination.IP.Mask(candidate.Destination.Mask), Mask: candidate.Destination.Mask}, Metric: candidate.Metric}
+		if family == IPv4 {
+			route.NextHop = net.IPv4zero.To4()
+		} else {
+			route.NextHop = net.IPv6zero
+		}
+		allRoutes = append(allRoutes, route)
+	}
+
+	sort.Slice(allRoutes, func(i, j int) bool {
+		if c := bytes.Compare(allRoutes[i].NextHop, allRoutes[j].NextHop); c != 0 {
+			return c < 0
+		}
+		if c := bytes.Compare(allRoutes[i].Destination.IP, allRoutes[j].Destination.IP); c != 0 {
+			return c < 0
+		}
+		if c := bytes.Compare(allRoutes[i].Destination.Mask, allRoutes[j].Destination.Mask); c != 0 {
+			return c < 0
+		}
+		return allRoutes[i].Metric < allRoutes[j].Metric
+	})
+	routes := make([]Route, 0, len(allRoutes))
+	for i := range allRoutes {
+		if i > 0 && bytes.Equal(allRoutes[i].NextHop, allRoutes[i-1].NextHop) &&
+			bytes.Equal(allRoutes[i].Destination.IP, allRoutes[i-1].Destination.IP) &&
+			bytes.Equal(allRoutes[i].Destination.Mask, allRoutes[i-1].Destination.Mask) {
+			continue
+		}
+		routes = append(routes, allRoutes[i])
+	}
+	return routes
+}
+
+// HasDefaultRoute reports whether routes contain the default route of the family.
+func HasDefaultRoute(routes []Route, family Family) bool {
+	for _, route := range routes {
+		if ones, _ := route.Destination.Mask.Size(); ones == 0 && familyOf(route.Destination) == family {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func testPrefix(s string) net.IPNet {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return net.IPNet{IP: ip, Mask: ipnet.Mask}
+}
+
+func testPrefixes(s ...string) []net.IPNet {
+	prefixes := make([]net.IPNet, len(s))
+	for i := range s {
+		prefixes[i] = testPrefix(s[i])
+	}
+	return prefixes
+}
+
+func TestTableMetric(t *testing.T) {
+	tests := []struct {
+		table, metric, want uint32
+	}{
+		{0, 0, 0},
+		{0, 10, 10},
+		{100, 5, 105},
+		{4294967000, 9999, 4294967295},
+		{4294967295, 0, 4294967295},
+	}
+	for _, tt := range tests {
+		if got := TableMetric(tt.table, tt.metric); got != tt.want {
+			t.Errorf("TableMetric(%d, %d) = %d, want %d", tt.table, tt.metric, got, tt.want)
+		}
+	}
+}
+
+func TestBuildRoutes(t *testing.T) {
+	candidate := func(destination string, metric uint32) Route {
+		return Route{Destination: testPrefix(destination), Metric: metric}
+	}
+	tests := []struct {
+		name         string
+		addresses    []string
+		candidates   []Route
+		want         []string
+		wantDefault4 bool
+		wantDefault6 bool
+	}{
+		{
+			name:       "masks destinations",
+			addresses:  []string{"10.64.0.2/32"},
+			candidates: []Route{candidate("10.64.0.0/16", 0), candidate("10.65.1.1/16", 0)},
+			want:       []string{"10.64.0.0/16 via 0.0.0.0 metric 0", "10.65.0.0/16 via 0.0.0.0 metric 0"},
+		},
+		{
+			name:       "duplicate keeps lowest metric",
+			addresses:  []string{"10.64.0.2/32"},
+			candidates: []Route{candidate("10.64.0.0/16", 30), candidate("10.64.0.0/16", 5), candidate("10.64.0.0/16", 5)},
+			want:       []string{"10.64.0.0/16 via 0.0.0.0 metric 5"},
+		},
+		{
+			name:         "family without address",
+			addresses:    []string{"fd00::2/128"},
+			candidates:   []Route{candidate("0.0.0.0/0", 7), candidate("::/0", 7)},
+			want:         []string{"::/0 via :: metric 7"},
+			wantDefault6: true,
+		},
+		{
+			name:         "default routes",
+			addresses:    []string{"10.64.0.2/32", "fd00::2/128"},
+			candidates:   []Route{candidate("0.0.0.0/0", 0), candidate("::/0", 0), candidate("fd00::/64", 20)},
+			want:         []string{"0.0.0.0/0 via 0.0.0.0 metric 0", "::/0 via :: metric 0", "fd00::/64 via :: metric 20"},
+			wantDefault4: true,
+			wantDefault6: true,
+		},
+		{
+			name:       "default route halves",
+			addresses:  []string{"10.64.0.2/32"},
+			candidates: []Route{candidate("0.0.0.0/1", 0), candidate("128.0.0.0/1", 0)},
+			want:       []string{"0.0.0.0/1 via 0.0.0.0 metric 0", "128.0.0.0/1 via 0.0.0.0 metric 0"},
+		},
+		{
+			name: "no addresses",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := BuildRoutes(testPrefixes(tt.addresses...), tt.candidates)
+			if len(routes) != len(tt.want) {
+				t.Fatalf("got %d routes, want %d", len(routes), len(tt.want))
+			}
+			for i, route := range routes {
+				if got := fmt.Sprintf("%s via %s metric %d", route.Destination.String(), route.NextHop, route.Metric); got != tt.want[i] {
+					t.Errorf("route %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+			if HasDefaultRoute(routes, IPv4) != tt.wantDefault4 || HasDefaultRoute(routes, IPv6) != tt.wantDefault6 {
+				t.Errorf("default routes = %v/%v, want %v/%v", HasDefaultRoute(routes, IPv4), HasDefaultRoute(routes, IPv6), tt.wantDefault4, tt.wantDefault6)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package netconfig
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/firewall"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/winipcfg"
+)
+
+// System is the NetConfigurator that changes the configuration of Windows, through winipcfg and the firewall.
+type System struct{}
+
+func (System) SetAddresses(luid LUID, family Family, addresses []net.IPNet) error {
+	err := winipcfg.LUID(luid).SetIPAddressesForFamily(winipcfg.AddressFamily(family), addresses)
+	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+		return ErrAddressExists
+	}
+	return err
+}
+
+func (System) FlushAddresses(luid LUID, family Family) error {
+	return winipcfg.LUID(luid).FlushIPAddresses(winipcfg.AddressFamily(family))
+}
+
+func (System) DeleteAddress(luid LUID, address net.IPNet) error {
+	return winipcfg.LUID(luid).DeleteIPAddress(address)
+}
+
+func (System) Interfaces(family Family) ([]InterfaceAddresses, error) {
+	adapters, err := winipcfg.GetAdaptersAddresses(winipcfg.AddressFamily(family), winipcfg.GAAFlagDefault)
+	if err != nil {
+		return nil, err
+	}
+	interfaces := make([]InterfaceAddresses, 0, len(adapters))
+	for _, adapter := range adapters {
+		iface := InterfaceAddresses{
+			Interface: LUID(adapter.LUID),
+			Name:      adapter.FriendlyName(),
+			Up:        adapter.OperStatus == winipcfg.IfOperStatusUp,
+		}
+		for address := adapter.FirstUnicastAddress; address != nil; address = address.Next {
+			ip := address.Address.IP()
+			iface.Addresses = append(iface.Addresses, net.IPNet{IP: ip, Mask: net.CIDRMask(int(address.OnLinkPrefixLength), 8*len(ip))})
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces, nil
+}
+
+func (System) SetRoutes(luid LUID, family Family, routes []Route) error {
+	routesData := make([]*winipcfg.RouteData, len(routes))
+	for i := range routes {
+		routesData[i] = &winipcfg.RouteData{Destination: routes[i].Destination, NextHop: routes[i].NextHop, Metric: routes[i].Metric}
+	}
+	return winipcfg.LUID(luid).SetRoutesForFamily(winipcfg.AddressFamily(family), routesData)
+}
+
+func (System) FlushRoutes(luid LUID, family Family) error {
+	return winipcfg.LUID(luid).FlushRoutes(winipcfg.AddressFamily(family))
+}
+
+func (System) DefaultRoutes(family Family) ([]DefaultRoute, error) {
+	r, err := winipcfg.GetIPForwardTable2(winipcfg.AddressFamily(family))
+	if err != nil {
+		return nil, err
+	}
+	var routes []DefaultRoute
+	for i := range r {
+		if r[i].DestinationPrefix.PrefixLength != 0 {
+			continue
+		}
+		ifrow, err := r[i].InterfaceLUID.Interface()
+		if err != nil {
+			continue
+		}
+		iface, err := r[i].InterfaceLUID.IPInterface(winipcfg.AddressFamily(family))
+		if err != nil {
+			continue
+		}
+		routes = append(routes, DefaultRoute{
+			Interface: LUID(r[i].InterfaceLUID),
+			Index:     r[i].InterfaceIndex,
+			Metric:    r[i].Metric + iface.Metric,
+			Up:        ifrow.OperStatus == winipcfg.IfOperStatusUp,
+		})
+	}
+	return routes, nil
+}
+
+func (System) SetInterface(luid LUID, family Family, settings InterfaceSettings) error {
+	ipif, err := winipcfg.LUID(luid).IPInterface(winipcfg.AddressFamily(family))
+	if err != nil {
+		return err
+	}
+	if settings.MTU > 0 {
+		ipif.NLMTU = settings.MTU
+	}
+	if settings.FixedMetric {
+		ipif.UseAutomaticMetric = false
+		ipif.Metric = settings.Metric
+	}
+	if settings.DisableRouterDiscovery {
+		ipif.DadTransmits = 0
+		ipif.RouterDiscoveryBehavior = winipcfg.RouterDiscoveryDisabled
+	}
+	return ipif.Set()
+}
+
+func (System) LinkMTU(luid LUID) (uint32, error) {
+	iface, err := winipcfg.LUID(luid).Interface()
+	if err != nil {
+		return 0, err
+	}
+	return iface.MTU, nil
+}
+
+func (System) InterfaceGUID(luid LUID) (string, error) {
+	guid, err := winipcfg.LUID(luid).GUID()
+	if err != nil {
+		return "", err
+	}
+	return guid.String(), nil
+}
+
+func (System) SetDNS(luid LUID, family Family, servers []net.IP, domains []string) error {
+	return winipcfg.LUID(luid).SetDNS(winipcfg.AddressFamily(family), servers, domains)
+}
+
+func (System) FlushDNS(luid LUID, family Family) error {
+	return winipcfg.LUID(luid).FlushDNS(winipcfg.AddressFamily(family))
+}
+
+func (System) SetNRPTRule(luid LUID, domains []string, servers []net.IP) error {
+	return winipcfg.LUID(luid).SetNRPTRule(&winipcfg.NRPTRule{Domains: domains, Servers: servers})
+}
+
+func (System) FlushNRPTRule(luid LUID) error {
+	return winipcfg.LUID(luid).FlushNRPTRule()
+}
+
+func changeKind(notificationType winipcfg.MibNotificationType) ChangeKind {
+	switch notificationType {
+	case winipcfg.MibAddInstance:
+		return ChangeAdded
+	case winipcfg.MibDeleteInstance:
+		return ChangeDeleted
+	}
+	return ChangeParameters
+}
+
+func (System) RegisterRouteChangeCallback(callback func(change RouteChange)) (ChangeCallback, error) {
+	cb, err := winipcfg.RegisterRouteChangeCallback(func(notificationType winipcfg.MibNotificationType, route *winipcfg.MibIPforwardRow2) {
+		if route != nil {
+			callback(RouteChange{Kind: changeKind(notificationType), PrefixLength: route.DestinationPrefix.PrefixLength})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+func (System) RegisterInterfaceChangeCallback(callback func(change InterfaceChange)) (ChangeCallback, error) {
+	cb, err := winipcfg.RegisterInterfaceChangeCallback(func(notificationType winipcfg.MibNotificationType, iface *winipcfg.MibIPInterfaceRow) {
+		if notificationType == winipcfg.MibInitialNotification {
+			return
+		}
+		change := InterfaceChange{Kind: changeKind(notificationType)}
+		if iface != nil {
+			change.Interface = LUID(iface.InterfaceLUID)
+			change.Family = Family(iface.Family)
+		}
+		callback(change)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+func (System) EnableFirewall(luid LUID, doNotRestrict bool, blockDNSLeaks bool, dnsServers []net.IP) error {
+	return firewall.EnableFirewall(uint64(luid), doNotRestrict, blockDNSLeaks, dnsServers)
+}
+
+func (System) DisableFirewall() {
+	firewall.DisableFirewall()
+}
@@ -0,0 +1,153 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/conn"
+	"github.com/amnezia-vpn/amneziawg-go/v3/device"
+	"github.com/amnezia-vpn/amneziawg-go/v3/tun/netstack"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+)
+
+// DefaultNetstackProxyAddress is where the proxy of a netstack tunnel listens unless told otherwise.
+const DefaultNetstackProxyAddress = "127.0.0.1:1080"
+
+const netstackDefaultMTU = 1420
+
+// NetstackTunnel runs the device of a tunnel over a userspace network stack instead of a Wintun adapter, which
+// needs neither administrator rights nor a driver. The system's network configuration is left alone, so
+// applications reach the tunnel through a local SOCKS5 and HTTP CONNECT proxy instead.
+type NetstackTunnel struct {
+	Device *device.Device
+	Net    *netstack.Net
+
+	name  string
+	proxy *localProxy
+}
+
+// StartNetstack brings the tunnel up and starts its proxy on proxyAddress, which must be a loopback address.
+// If proxyAddress is empty, the proxy listens where the configuration's Proxy key says, or else on
+// DefaultNetstackProxyAddress. Clients must authenticate with the credentials of the Proxy key, if any.
+func StartNetstack(config *conf.Config, proxyAddress string) (*NetstackTunnel, error) {
+	if len(proxyAddress) == 0 {
+		if config.Interface.Proxy.IsEmpty() {
+			proxyAddress = DefaultNetstackProxyAddress
+		} else {
+			proxyAddress = config.Interface.Proxy.Address.String()
+		}
+	}
+	if host, _, err := net.SplitHostPort(proxyAddress); err != nil {
+		return nil, err
+	} else if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return nil, fmt.Errorf("Proxy address %s is not a loopback address", proxyAddress)
+	}
+
+	addresses := make([]netip.Addr, 0, len(config.Interface.Addresses))
+	for _, addr := range config.Interface.Addresses {
+		if ip, ok := netip.AddrFromSlice(addr.IP); ok {
+			addresses = append(addresses, ip.Unmap())
+		}
+	}
+	dnsServers := make([]netip.Addr, 0, len(config.Interface.DNS))
+	for _, dns := range config.Interface.DNS {
+		if ip, ok := netip.AddrFromSlice(dns); ok {
+			dnsServers = append(dnsServers, ip.Unmap())
+		}
+	}
+	mtu := int(config.Interface.MTU)
+	if mtu == 0 {
+		mtu = netstackDefaultMTU
+	}
+
+	// What the tunnel logs is redacted as its configuration asks until Close, or until starting fails.
+	ringlogger.Global.SetRedactor(config.Name, LogRedactor(config))
+	started := false
+	defer func() {
+		if !started {
+			ringlogger.Global.SetRedactor(config.Name, nil)
+		}
+	}()
+
+	log.Println("Resolving DNS names")
+	uapiConf, err := config.ToUAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Creating netstack interface")
+	tun, tnet, err := netstack.CreateNetTUN(addresses, dnsServers, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create netstack interface: %w", err)
+	}
+	logger := DeviceLogger(NewTunnelLogger(config.Name, LogLevelOf(config)))
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	log.Println("Setting interface configuration")
+	err = dev.IpcSet(uapiConf)
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	log.Println("Bringing peers up")
+	err = dev.Up()
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+
+	p, err := listenProxy(proxyAddress, config.Interface.Proxy, tnet.DialContext)
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	started = true
+	return &NetstackTunnel{Device: dev, Net: tnet, name: config.Name, proxy: p}, nil
+}
+
+// ProxyAddr returns the address the proxy listens on, which tells the port when it was chosen by the system.
+func (t *NetstackTunnel) ProxyAddr() net.Addr {
+	return t.proxy.Addr()
+}
+
+func (t *NetstackTunnel) Close() error {
+	err := t.proxy.Close()
+	t.Device.Close()
+	ringlogger.Global.SetRedactor(t.name, nil)
+	return err
+}
+
+// RunNetstack runs the tunnel of the configuration file in netstack mode until interrupted, with its proxy
+// listening as StartNetstack describes.
+func RunNetstack(confPath string, proxyAddress string) error {
+	config, err := conf.LoadFromPath(confPath)
+	if err != nil {
+		return err
+	}
+	config.DeduplicateNetworkEntries()
+	log.SetPrefix(fmt.Sprintf("[%s] ", config.Name))
+
+	t, err := StartNetstack(config, proxyAddress)
+	if err != nil {
+		return err
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	select {
+	case <-interrupt:
+	case <-t.Device.Wait():
+	}
+	log.Println("Shutting down")
+	return t.Close()
+}
@@ -0,0 +1,204 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
+)
+
+// netstackPair starts two netstack tunnels peered over loopback, with the proxy of the client given by
+// clientProxy.
+func netstackPair(t *testing.T, clientProxy conf.Proxy) (client *NetstackTunnel, server *NetstackTunnel) {
+	t.Helper()
+	clientKey, err := conf.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := conf.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &conf.Config{Name: "server"}
+	serverConfig.Interface.PrivateKey = *serverKey
+	serverConfig.Interface.ListenPort = 52871
+	serverConfig.Interface.Addresses = []conf.IPCidr{testCidr("10.77.0.2/32")}
+	serverConfig.Peers = []conf.Peer{{PublicKey: *clientKey.Public(), AllowedIPs: []conf.IPCidr{testCidr("10.77.0.1/32")}}}
+	clientConfig := &conf.Config{Name: "client"}
+	clientConfig.Interface.PrivateKey = *clientKey
+	clientConfig.Interface.Addresses = []conf.IPCidr{testCidr("10.77.0.1/32")}
+	clientConfig.Interface.Proxy = clientProxy
+	clientConfig.Peers = []conf.Peer{{
+		PublicKey:  *serverKey.Public(),
+		AllowedIPs: []conf.IPCidr{testCidr("10.77.0.0/24")},
+		Endpoint:   conf.Endpoint{Host: "127.0.0.1", Port: 52871},
+	}}
+
+	server, err = StartNetstack(serverConfig, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	client, err = StartNetstack(clientConfig, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return
+}
+
+// serveEcho echoes back whatever connections to port 8080 of the server's tunnel address send.
+func serveEcho(t *testing.T, server *NetstackTunnel) {
+	t.Helper()
+	listener, err := server.Net.ListenTCP(&net.TCPAddr{IP: net.IPv4(10, 77, 0, 2), Port: 8080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+}
+
+// connectThroughProxy sends a CONNECT request for the server's echo service to the client's proxy, returning
+// the connection along with the status the proxy answered with.
+func connectThroughProxy(t *testing.T, client *NetstackTunnel, authorization string) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", client.ProxyAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(time.Second * 30))
+	request := "CONNECT 10.77.0.2:8080 HTTP/1.1\r\nHost: 10.77.0.2:8080\r\n"
+	if len(authorization) > 0 {
+		request += "Proxy-Authorization: " + authorization + "\r\n"
+	}
+	_, err = io.WriteString(conn, request+"\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, response.StatusCode
+}
+
+func TestNetstackProxy(t *testing.T) {
+	client, server := netstackPair(t, conf.Proxy{})
+	serveEcho(t, server)
+
+	conn, reader, status := connectThroughProxy(t, client, "")
+	if status != http.StatusOK {
+		t.Fatalf("CONNECT through tunnel = %d", status)
+	}
+	_, err := io.WriteString(conn, "through the tunnel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, len("through the tunnel"))
+	if _, err = io.ReadFull(reader, echo); err != nil {
+		t.Fatal(err)
+	}
+	if string(echo) != "through the tunnel" {
+		t.Errorf("echo = %q", echo)
+	}
+}
+
+func TestNetstackProxyAuthentication(t *testing.T) {
+	client, server := netstackPair(t, conf.Proxy{Username: "alice", Password: "secret"})
+	serveEcho(t, server)
+
+	if _, _, status := connectThroughProxy(t, client, ""); status != http.StatusProxyAuthRequired {
+		t.Errorf("CONNECT without credentials = %d, want %d", status, http.StatusProxyAuthRequired)
+	}
+	wrong := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:guess"))
+	if _, _, status := connectThroughProxy(t, client, wrong); status != http.StatusProxyAuthRequired {
+		t.Errorf("CONNECT with the wrong password = %d, want %d", status, http.StatusProxyAuthRequired)
+	}
+	right := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	conn, reader, status := connectThroughProxy(t, client, right)
+	if status != http.StatusOK {
+		t.Fatalf("CONNECT with credentials = %d", status)
+	}
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(reader, echo); err != nil {
+		t.Fatal(err)
+	}
+	if string(echo) != "ping" {
+		t.Errorf("echo = %q", echo)
+	}
+}
+
+func TestNetstackProxyLoopbackOnly(t *testing.T) {
+	config := &conf.Config{Name: "test"}
+	if _, err := StartNetstack(config, "0.0.0.0:1080"); err == nil {
+		t.Error("StartNetstack accepted a proxy address that is not loopback")
+	}
+}
+
+func TestNetstackLogRedaction(t *testing.T) {
+	rl, err := ringlogger.NewRinglogger(filepath.Join(t.TempDir(), "log.bin"), "TUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	defer func(global *ringlogger.Ringlogger) { ringlogger.Global = global }(ringlogger.Global)
+	ringlogger.Global = rl
+
+	key, err := conf.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &conf.Config{Name: "office"}
+	config.Interface.PrivateKey = *key
+	config.Interface.Addresses = []conf.IPCidr{testCidr("10.78.0.1/32")}
+	config.Interface.LogRedaction = conf.LogRedactAddresses
+	office, err := StartNetstack(config, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl.WriteRecord(slog.LevelInfo, "office", "Endpoint 192.0.2.1")
+	office.Close()
+	rl.WriteRecord(slog.LevelInfo, "office", "Endpoint 192.0.2.1")
+
+	var endpoints []string
+	lines, _ := rl.FollowFromCursor(ringlogger.CursorAll)
+	for _, line := range lines {
+		if line.Record != nil && strings.HasPrefix(line.Record.Message, "Endpoint") {
+			endpoints = append(endpoints, line.Record.Message)
+		}
+	}
+	if want := []string{"Endpoint (redacted)", "Endpoint 192.0.2.1"}; !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("lines = %q, want %q", endpoints, want)
+	}
+}
@@ -0,0 +1,76 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ondemand
+
+import (
+	"sync"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
+)
+
+// Engine evaluates its rules whenever the networks change and reports decisions that differ from the one
+// before, so that a tunnel the user connected by hand stays connected until the network changes.
+type Engine struct {
+	Rules []Rule
+	// Networks lists the networks the computer is connected to, which SystemNetworks does on Windows.
+	Networks func() ([]Network, error)
+	// NetConfigurator notifies the engine of interface and default route changes.
+	NetConfigurator netconfig.NetConfigurator
+	// Decided is called with each decision to connect or disconnect that differs from the one before.
+	Decided func(decision Decision)
+
+	mu   sync.Mutex
+	last Action
+}
+
+// Update evaluates the rules against the current networks, reporting the decision to Decided if it calls
+// for something other than the previous one did.
+func (engine *Engine) Update() (Decision, error) {
+	networks, err := engine.Networks()
+	if err != nil {
+		return Decision{}, err
+	}
+	decision := Evaluate(engine.Rules, networks)
+
+	engine.mu.Lock()
+	changed := decision.Action != engine.last
+	engine.last = decision.Action
+	engine.mu.Unlock()
+
+	if changed && decision.Action != ActionNone && engine.Decided != nil {
+		engine.Decided(decision)
+	}
+	return decision, nil
+}
+
+// Start makes a first decision and then updates after interface and default route changes, waiting for
+// bursts of them, such as those of joining a Wi-Fi network, to settle.
+func (engine *Engine) Start() ([]netconfig.ChangeCallback, error) {
+	_, err := engine.Update()
+	if err != nil {
+		return nil, err
+	}
+
+	bump := netconfig.Debounce(time.Millisecond*500, time.Second*5, func() { engine.Update() })
+
+	cbr, err := engine.NetConfigurator.RegisterRouteChangeCallback(func(change netconfig.RouteChange) {
+		if change.PrefixLength == 0 {
+			bump()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	cbi, err := engine.NetConfigurator.RegisterInterfaceChangeCallback(func(change netconfig.InterfaceChange) {
+		bump()
+	})
+	if err != nil {
+		cbr.Unregister()
+		return nil, err
+	}
+	return []netconfig.ChangeCallback{cbr, cbi}, nil
+}
@@ -0,0 +1,139 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ondemand
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
+)
+
+type fakeNetworks struct {
+	mu       sync.Mutex
+	networks []Network
+	err      error
+	calls    int
+}
+
+func (fake *fakeNetworks) set(networks ...Network) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.networks = networks
+}
+
+func (fake *fakeNetworks) Networks() ([]Network, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.calls++
+	return fake.networks, fake.err
+}
+
+type recordedDecisions struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+func (recorded *recordedDecisions) record(decision Decision) {
+	recorded.mu.Lock()
+	defer recorded.mu.Unlock()
+	recorded.actions = append(recorded.actions, decision.Action)
+}
+
+func (recorded *recordedDecisions) take() []Action {
+	recorded.mu.Lock()
+	defer recorded.mu.Unlock()
+	actions := recorded.actions
+	recorded.actions = nil
+	return actions
+}
+
+func TestEngineUpdate(t *testing.T) {
+	networks := &fakeNetworks{}
+	decisions := &recordedDecisions{}
+	engine := &Engine{Rules: mustParseRules(t, laptopRules), Networks: networks.Networks, Decided: decisions.record}
+
+	steps := []struct {
+		networks []Network
+		want     []Action
+	}{
+		{[]Network{cafeWiFi}, []Action{ActionConnect}},
+		{[]Network{cafeWiFi}, nil},
+		{[]Network{phone}, nil},
+		{[]Network{officeLAN}, []Action{ActionDisconnect}},
+		{nil, nil},
+		// Back at the office after a time without a network, the user may have connected by hand.
+		{[]Network{officeLAN}, []Action{ActionDisconnect}},
+		{[]Network{homeWiFi}, nil},
+		{[]Network{cafeWiFi}, []Action{ActionConnect}},
+	}
+	for i, step := range steps {
+		networks.set(step.networks...)
+		if _, err := engine.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if got := decisions.take(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("step %d: decisions = %v, want %v", i, got, step.want)
+		}
+	}
+
+	networks.err = errors.New("unavailable")
+	if _, err := engine.Update(); err == nil {
+		t.Error("Update succeeded without networks")
+	}
+}
+
+func TestEngineNotifications(t *testing.T) {
+	nc := netconfig.NewMemory()
+	networks := &fakeNetworks{}
+	networks.set(cafeWiFi)
+	decisions := &recordedDecisions{}
+	engine := &Engine{Rules: mustParseRules(t, laptopRules), Networks: networks.Networks, NetConfigurator: nc, Decided: decisions.record}
+	callbacks, err := engine.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decisions.take(); !reflect.DeepEqual(got, []Action{ActionConnect}) {
+		t.Errorf("decisions at start = %v", got)
+	}
+
+	// Joining the office network makes a burst of changes, which make for a single update.
+	networks.set(officeLAN)
+	networks.mu.Lock()
+	before := networks.calls
+	networks.mu.Unlock()
+	nc.NotifyInterfaceChange(netconfig.InterfaceChange{Kind: netconfig.ChangeAdded, Interface: 0x200, Family: netconfig.IPv4})
+	nc.NotifyRouteChange(netconfig.RouteChange{Kind: netconfig.ChangeAdded, PrefixLength: 24})
+	nc.NotifyRouteChange(netconfig.RouteChange{Kind: netconfig.ChangeAdded, PrefixLength: 0})
+	nc.NotifyInterfaceChange(netconfig.InterfaceChange{Kind: netconfig.ChangeParameters, Interface: 0x200, Family: netconfig.IPv6})
+	deadline := time.Now().Add(5 * time.Second)
+	var got []Action
+	for len(got) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		got = decisions.take()
+	}
+	if !reflect.DeepEqual(got, []Action{ActionDisconnect}) {
+		t.Errorf("decisions after joining the office network = %v", got)
+	}
+	networks.mu.Lock()
+	if calls := networks.calls - before; calls != 1 {
+		t.Errorf("networks listed %d times for one burst of changes", calls)
+	}
+	networks.mu.Unlock()
+
+	for _, cb := range callbacks {
+		cb.Unregister()
+	}
+	networks.set(cafeWiFi)
+	nc.NotifyRouteChange(netconfig.RouteChange{Kind: netconfig.ChangeAdded, PrefixLength: 0})
+	time.Sleep(time.Second)
+	if got := decisions.take(); len(got) != 0 {
+		t.Errorf("decisions = %v after unregistering", got)
+	}
+}
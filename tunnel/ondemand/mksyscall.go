@@ -0,0 +1,8 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ondemand
+
+//go:generate go run golang.org/x/sys/windows/mkwinsyscall -output zondemand_windows.go networks_windows.go
@@ -0,0 +1,137 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ondemand
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/winipcfg"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-mib_ipnet_row2
+type mibIPNetRow2 struct {
+	address               winipcfg.RawSockaddrInet
+	interfaceIndex        uint32
+	interfaceLUID         winipcfg.LUID
+	physicalAddress       [32]byte
+	physicalAddressLength uint32
+	state                 uint32
+	flags                 uint8
+	reachabilityTime      uint32
+}
+
+const (
+	wlanClientVersion            = 2
+	wlanIntfOpcodeCurrentConnect = 7
+)
+
+// wlanConnectionAttributes is the beginning of WLAN_CONNECTION_ATTRIBUTES, up to the SSID.
+// https://learn.microsoft.com/en-us/windows/win32/api/wlanapi/ns-wlanapi-wlan_connection_attributes
+type wlanConnectionAttributes struct {
+	state          uint32
+	connectionMode uint32
+	profileName    [256]uint16
+	ssidLength     uint32
+	ssid           [32]byte
+}
+
+//sys	getIPNetEntry2(row *mibIPNetRow2) (ret error) = iphlpapi.GetIpNetEntry2
+//sys	wlanOpenHandle(clientVersion uint32, reserved uintptr, negotiatedVersion *uint32, handle *windows.Handle) (ret error) = wlanapi.WlanOpenHandle?
+//sys	wlanCloseHandle(handle windows.Handle, reserved uintptr) (ret error) = wlanapi.WlanCloseHandle?
+//sys	wlanQueryInterface(handle windows.Handle, interfaceGUID *windows.GUID, opCode uint32, reserved uintptr, dataSize *uint32, data **wlanConnectionAttributes, valueType *uint32) (ret error) = wlanapi.WlanQueryInterface?
+//sys	wlanFreeMemory(memory unsafe.Pointer) = wlanapi.WlanFreeMemory
+
+func interfaceType(ifType winipcfg.IfType) InterfaceType {
+	switch ifType {
+	case winipcfg.IfTypeEthernetCSMACD:
+		return InterfaceEthernet
+	case winipcfg.IfTypeIEEE80211:
+		return InterfaceWiFi
+	case winipcfg.IfTypeWwanpp, winipcfg.IfTypeWwanpp2:
+		return InterfaceCellular
+	}
+	return InterfaceOther
+}
+
+// gatewayMAC looks the gateway up in the neighbor cache, which has it as long as the interface has been used.
+func gatewayMAC(luid winipcfg.LUID, gateway net.IP) net.HardwareAddr {
+	row := mibIPNetRow2{interfaceLUID: luid}
+	if row.address.SetIP(gateway, 0) != nil || getIPNetEntry2(&row) != nil {
+		return nil
+	}
+	if row.physicalAddressLength == 0 || row.physicalAddressLength > uint32(len(row.physicalAddress)) {
+		return nil
+	}
+	return append(net.HardwareAddr(nil), row.physicalAddress[:row.physicalAddressLength]...)
+}
+
+// currentSSID returns the SSID of the network the wireless interface with the given GUID is connected to, or
+// an empty string if it is not or the WLAN service is not running.
+func currentSSID(wlan windows.Handle, interfaceGUID *windows.GUID) string {
+	var attributes *wlanConnectionAttributes
+	var size, valueType uint32
+	if wlanQueryInterface(wlan, interfaceGUID, wlanIntfOpcodeCurrentConnect, 0, &size, &attributes, &valueType) != nil {
+		return ""
+	}
+	defer wlanFreeMemory(unsafe.Pointer(attributes))
+	if size < uint32(unsafe.Sizeof(*attributes)) || attributes.ssidLength > uint32(len(attributes.ssid)) {
+		return ""
+	}
+	return string(attributes.ssid[:attributes.ssidLength])
+}
+
+// SystemNetworks lists the networks of the interfaces that are up and have a default gateway, leaving out
+// loopback and tunnel interfaces, including Wintun ones.
+func SystemNetworks() ([]Network, error) {
+	adapters, err := winipcfg.GetAdaptersAddresses(windows.AF_UNSPEC, winipcfg.GAAFlagIncludeGateways|winipcfg.GAAFlagSkipAnycast|winipcfg.GAAFlagSkipMulticast)
+	if err != nil {
+		return nil, err
+	}
+	wlan := windows.Handle(0)
+	var negotiatedVersion uint32
+	if wlanOpenHandle(wlanClientVersion, 0, &negotiatedVersion, &wlan) == nil {
+		defer wlanCloseHandle(wlan, 0)
+	}
+
+	var networks []Network
+	for _, adapter := range adapters {
+		if adapter.OperStatus != winipcfg.IfOperStatusUp || adapter.FirstGatewayAddress == nil {
+			continue
+		}
+		if adapter.IfType == winipcfg.IfTypeSoftwareLoopback || adapter.IfType == winipcfg.IfTypePropVirtual || adapter.IfType == winipcfg.IfTypeTunnel {
+			continue
+		}
+		network := Network{
+			Name:   adapter.FriendlyName(),
+			Type:   interfaceType(adapter.IfType),
+			Metric: adapter.Ipv4Metric,
+		}
+		if adapter.Ipv4Metric == 0 || (adapter.Ipv6Metric != 0 && adapter.Ipv6Metric < adapter.Ipv4Metric) {
+			network.Metric = adapter.Ipv6Metric
+		}
+		for gateway := adapter.FirstGatewayAddress; gateway != nil; gateway = gateway.Next {
+			if mac := gatewayMAC(adapter.LUID, gateway.Address.IP()); mac != nil {
+				network.GatewayMACs = append(network.GatewayMACs, mac)
+			}
+		}
+		if suffix := adapter.DNSSuffix(); len(suffix) > 0 {
+			network.DNSSuffixes = append(network.DNSSuffixes, suffix)
+		}
+		for suffix := adapter.FirstDNSSuffix; suffix != nil; suffix = suffix.Next {
+			network.DNSSuffixes = append(network.DNSSuffixes, suffix.String())
+		}
+		if network.Type == InterfaceWiFi && wlan != 0 {
+			if guid, err := windows.GUIDFromString(adapter.AdapterName()); err == nil {
+				network.SSID = currentSSID(wlan, &guid)
+			}
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,319 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package ondemand decides whether a tunnel should be up based on the network the computer is on, such as
+// connecting on untrusted Wi-Fi and disconnecting on the office LAN.
+//
+// Rules are evaluated in order against the preferred network, which is the one with the lowest metric among
+// those with a default gateway, and the first that matches decides. A rule matches when every criterion it
+// has matches, and a criterion matches when any of its values does. In text, a rule is an action followed by
+// criteria, where values containing spaces are quoted and criteria with several values are repeated:
+//
+//	disconnect dns-suffix=corp.example.com
+//	disconnect type=ethernet gateway-mac=00:11:22:33:44:55
+//	ignore ssid="Home Network" ssid=Home-5G
+//	connect type=wifi
+//	connect type=cellular
+package ondemand
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type Action int
+
+const (
+	ActionNone Action = iota // leave the tunnel as it is
+	ActionConnect
+	ActionDisconnect
+)
+
+var actionNames = [...]string{
+	ActionNone:       "ignore",
+	ActionConnect:    "connect",
+	ActionDisconnect: "disconnect",
+}
+
+func (action Action) String() string {
+	if action >= 0 && int(action) < len(actionNames) {
+		return actionNames[action]
+	}
+	return fmt.Sprintf("action-%d", int(action))
+}
+
+type InterfaceType int
+
+const (
+	InterfaceAny InterfaceType = iota // only meaningful in rules
+	InterfaceEthernet
+	InterfaceWiFi
+	InterfaceCellular
+	InterfaceOther
+)
+
+var interfaceTypeNames = [...]string{
+	InterfaceAny:      "any",
+	InterfaceEthernet: "ethernet",
+	InterfaceWiFi:     "wifi",
+	InterfaceCellular: "cellular",
+	InterfaceOther:    "other",
+}
+
+func (t InterfaceType) String() string {
+	if t >= 0 && int(t) < len(interfaceTypeNames) {
+		return interfaceTypeNames[t]
+	}
+	return fmt.Sprintf("type-%d", int(t))
+}
+
+// Network is a network the computer is connected to through one of its interfaces.
+type Network struct {
+	Name        string // of the interface, for logging
+	Type        InterfaceType
+	SSID        string // for Wi-Fi
+	GatewayMACs []net.HardwareAddr
+	DNSSuffixes []string
+	Metric      uint32
+}
+
+// Rule is an action along with the criteria for taking it. A rule without criteria matches any network.
+type Rule struct {
+	Action      Action
+	Type        InterfaceType
+	SSIDs       []string
+	GatewayMACs []net.HardwareAddr
+	DNSSuffixes []string
+}
+
+// Decision is the outcome of evaluating rules, with the rule that matched and the network it matched, either
+// of which is nil if there was none.
+type Decision struct {
+	Action  Action
+	Rule    *Rule
+	Network *Network
+}
+
+func (decision Decision) String() string {
+	if decision.Network == nil {
+		return fmt.Sprintf("%s, as there is no network", decision.Action)
+	}
+	if decision.Rule == nil {
+		return fmt.Sprintf("%s, as no rule matches %s", decision.Action, decision.Network.Name)
+	}
+	return fmt.Sprintf("%s, as %s matches rule %q", decision.Action, decision.Network.Name, decision.Rule.String())
+}
+
+func normalizeDNSSuffix(suffix string) string {
+	return strings.ToLower(strings.TrimSuffix(suffix, "."))
+}
+
+// Matches reports whether the network meets all of the rule's criteria. DNS suffixes of the network match
+// those of the rule that they equal or are subdomains of.
+func (rule *Rule) Matches(network *Network) bool {
+	if rule.Type != InterfaceAny && rule.Type != network.Type {
+		return false
+	}
+	if len(rule.SSIDs) > 0 {
+		found := false
+		for _, ssid := range rule.SSIDs {
+			if ssid == network.SSID {
+				found = true
+				break
+			}
+		}
+		if !found || len(network.SSID) == 0 {
+			return false
+		}
+	}
+	if len(rule.GatewayMACs) > 0 {
+		found := false
+		for _, want := range rule.GatewayMACs {
+			for _, mac := range network.GatewayMACs {
+				if bytes.Equal(want, mac) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(rule.DNSSuffixes) > 0 {
+		found := false
+		for _, want := range rule.DNSSuffixes {
+			want = normalizeDNSSuffix(want)
+			for _, suffix := range network.DNSSuffixes {
+				suffix = normalizeDNSSuffix(suffix)
+				if suffix == want || strings.HasSuffix(suffix, "."+want) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// PreferredNetwork returns the network with the lowest metric, the first of them if several have it, or nil
+// if there are none.
+func PreferredNetwork(networks []Network) *Network {
+	var preferred *Network
+	for i := range networks {
+		if preferred == nil || networks[i].Metric < preferred.Metric {
+			preferred = &networks[i]
+		}
+	}
+	return preferred
+}
+
+// Evaluate decides what to do about the tunnel on the given networks. It is ActionNone when there is no
+// network or no rule matches.
+func Evaluate(rules []Rule, networks []Network) Decision {
+	network := PreferredNetwork(networks)
+	if network == nil {
+		return Decision{Action: ActionNone}
+	}
+	for i := range rules {
+		if rules[i].Matches(network) {
+			return Decision{Action: rules[i].Action, Rule: &rules[i], Network: network}
+		}
+	}
+	return Decision{Action: ActionNone, Network: network}
+}
+
+func quoteIfNeeded(s string) string {
+	if len(s) == 0 || strings.ContainsAny(s, " \t\"\\") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (rule *Rule) String() string {
+	var output strings.Builder
+	output.WriteString(rule.Action.String())
+	if rule.Type != InterfaceAny {
+		output.WriteString(" type=" + rule.Type.String())
+	}
+	for _, ssid := range rule.SSIDs {
+		output.WriteString(" ssid=" + quoteIfNeeded(ssid))
+	}
+	for _, mac := range rule.GatewayMACs {
+		output.WriteString(" gateway-mac=" + mac.String())
+	}
+	for _, suffix := range rule.DNSSuffixes {
+		output.WriteString(" dns-suffix=" + quoteIfNeeded(suffix))
+	}
+	return output.String()
+}
+
+// splitRule splits s into space separated words, where a value after '=' may be a quoted string.
+func splitRule(s string) ([]string, error) {
+	var words []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if len(s) == 0 {
+			return words, nil
+		}
+		end := strings.IndexAny(s, " \t\"")
+		if end < 0 {
+			return append(words, s), nil
+		}
+		if s[end] != '"' {
+			words = append(words, s[:end])
+			s = s[end:]
+			continue
+		}
+		if end == 0 || s[end-1] != '=' {
+			return nil, fmt.Errorf("Unexpected quote in %q", s)
+		}
+		quoted, err := strconv.QuotedPrefix(s[end:])
+		if err != nil {
+			return nil, fmt.Errorf("Unterminated quoted value in %q", s)
+		}
+		value, _ := strconv.Unquote(quoted)
+		words = append(words, s[:end]+value)
+		s = s[end+len(quoted):]
+		if len(s) > 0 && s[0] != ' ' && s[0] != '\t' {
+			return nil, fmt.Errorf("Missing space after quoted value %s", quoted)
+		}
+	}
+}
+
+// ParseRule parses a rule in the text form described in the package documentation.
+func ParseRule(s string) (*Rule, error) {
+	words, err := splitRule(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, errors.New("Empty rule")
+	}
+	rule := &Rule{}
+	switch strings.ToLower(words[0]) {
+	case "connect":
+		rule.Action = ActionConnect
+	case "disconnect":
+		rule.Action = ActionDisconnect
+	case "ignore":
+		rule.Action = ActionNone
+	default:
+		return nil, fmt.Errorf("Invalid action %q", words[0])
+	}
+	for _, word := range words[1:] {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok || len(value) == 0 {
+			return nil, fmt.Errorf("Invalid criterion %q", word)
+		}
+		switch strings.ToLower(key) {
+		case "type":
+			found := false
+			for t, name := range interfaceTypeNames {
+				if strings.EqualFold(name, value) {
+					rule.Type, found = InterfaceType(t), true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("Invalid interface type %q", value)
+			}
+		case "ssid":
+			rule.SSIDs = append(rule.SSIDs, value)
+		case "gateway-mac":
+			mac, err := net.ParseMAC(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid gateway MAC %q", value)
+			}
+			rule.GatewayMACs = append(rule.GatewayMACs, mac)
+		case "dns-suffix":
+			rule.DNSSuffixes = append(rule.DNSSuffixes, value)
+		default:
+			return nil, fmt.Errorf("Invalid criterion %q", key)
+		}
+	}
+	return rule, nil
+}
+
+// ParseRules parses one rule per line, skipping blank lines and those starting with '#'.
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %w", i+1, err)
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, nil
+}
@@ -0,0 +1,166 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package ondemand
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func mustParseMAC(s string) net.HardwareAddr {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		panic(err)
+	}
+	return mac
+}
+
+func mustParseRules(t *testing.T, s string) []Rule {
+	t.Helper()
+	rules, err := ParseRules(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+var (
+	officeLAN = Network{
+		Name:        "Ethernet",
+		Type:        InterfaceEthernet,
+		GatewayMACs: []net.HardwareAddr{mustParseMAC("00:11:22:33:44:55")},
+		DNSSuffixes: []string{"Berlin.Corp.Example.com."},
+		Metric:      25,
+	}
+	homeWiFi = Network{Name: "Wi-Fi", Type: InterfaceWiFi, SSID: "Home Network", Metric: 35}
+	cafeWiFi = Network{Name: "Wi-Fi", Type: InterfaceWiFi, SSID: "Free Cafe WiFi", Metric: 35}
+	phone    = Network{Name: "Cellular", Type: InterfaceCellular, Metric: 50}
+)
+
+const laptopRules = `
+# Trusted networks
+disconnect dns-suffix=corp.example.com
+ignore ssid="Home Network" ssid=Home-5G
+
+connect type=wifi
+connect type=cellular
+`
+
+func TestEvaluate(t *testing.T) {
+	rules := mustParseRules(t, laptopRules)
+	tests := []struct {
+		name     string
+		networks []Network
+		want     Action
+		wantRule int
+	}{
+		{"no network", nil, ActionNone, -1},
+		{"office", []Network{officeLAN}, ActionDisconnect, 0},
+		{"home", []Network{homeWiFi}, ActionNone, 1},
+		{"cafe", []Network{cafeWiFi}, ActionConnect, 2},
+		{"phone", []Network{phone}, ActionConnect, 3},
+		{"docked at the office with Wi-Fi on", []Network{cafeWiFi, officeLAN}, ActionDisconnect, 0},
+		{"cafe with phone as backup", []Network{phone, cafeWiFi}, ActionConnect, 2},
+		{"unknown ethernet", []Network{{Name: "Ethernet 2", Type: InterfaceEthernet}}, ActionNone, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := Evaluate(rules, tt.networks)
+			if decision.Action != tt.want {
+				t.Errorf("action = %v, want %v (%v)", decision.Action, tt.want, decision)
+			}
+			if tt.wantRule < 0 && decision.Rule != nil {
+				t.Errorf("matched rule %q, want none", decision.Rule.String())
+			} else if tt.wantRule >= 0 && decision.Rule != &rules[tt.wantRule] {
+				t.Errorf("decision = %v, want rule %d", decision, tt.wantRule)
+			}
+			if (len(tt.networks) == 0) != (decision.Network == nil) {
+				t.Errorf("network = %v", decision.Network)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule    string
+		network Network
+		want    bool
+	}{
+		{"connect", phone, true},
+		{"connect type=ethernet", officeLAN, true},
+		{"connect type=wifi", officeLAN, false},
+		{"connect gateway-mac=00-11-22-33-44-55", officeLAN, true},
+		{"connect gateway-mac=00:11:22:33:44:66", officeLAN, false},
+		{"connect gateway-mac=00:11:22:33:44:55", homeWiFi, false},
+		{"connect dns-suffix=example.com", officeLAN, true},
+		{"connect dns-suffix=berlin.corp.example.com.", officeLAN, true},
+		{"connect dns-suffix=ample.com", officeLAN, false},
+		{"connect dns-suffix=paris.corp.example.com", officeLAN, false},
+		{"connect ssid=\"Home Network\"", homeWiFi, true},
+		{"connect ssid=\"home network\"", homeWiFi, false},
+		{"connect ssid=\"Home Network\"", officeLAN, false},
+		{"connect type=ethernet dns-suffix=example.com gateway-mac=00:11:22:33:44:55", officeLAN, true},
+		{"connect type=ethernet dns-suffix=example.org gateway-mac=00:11:22:33:44:55", officeLAN, false},
+		{"connect dns-suffix=example.org dns-suffix=example.com", officeLAN, true},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Errorf("ParseRule(%q) = %v", tt.rule, err)
+			continue
+		}
+		if got := rule.Matches(&tt.network); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.rule, tt.network.Name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule(`  Disconnect  TYPE=WiFi ssid="Café \"Guest\"" ssid=Office gateway-mac=aa:bb:cc:dd:ee:ff dns-suffix=corp.example.com `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Rule{
+		Action:      ActionDisconnect,
+		Type:        InterfaceWiFi,
+		SSIDs:       []string{`Café "Guest"`, "Office"},
+		GatewayMACs: []net.HardwareAddr{mustParseMAC("aa:bb:cc:dd:ee:ff")},
+		DNSSuffixes: []string{"corp.example.com"},
+	}
+	if !reflect.DeepEqual(rule, want) {
+		t.Errorf("rule = %+v, want %+v", rule, want)
+	}
+	text := rule.String()
+	if text != `disconnect type=wifi ssid="Café \"Guest\"" ssid=Office gateway-mac=aa:bb:cc:dd:ee:ff dns-suffix=corp.example.com` {
+		t.Errorf("String = %s", text)
+	}
+	again, err := ParseRule(text)
+	if err != nil || !reflect.DeepEqual(again, rule) {
+		t.Errorf("ParseRule(String()) = %+v, %v", again, err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"reconnect",
+		"connect type=satellite",
+		"connect ssid",
+		"connect ssid=",
+		"connect colour=blue",
+		"connect gateway-mac=router",
+		`connect ssid="unterminated`,
+		`connect ssid="a"b`,
+		`connect "ssid"=a`,
+	} {
+		if _, err := ParseRule(invalid); err == nil {
+			t.Errorf("ParseRule(%q) succeeded", invalid)
+		}
+	}
+	if _, err := ParseRules("connect\nconnect type=satellite\n"); err == nil || err.Error() != `Line 2: Invalid interface type "satellite"` {
+		t.Errorf("ParseRules = %v, want the line of the error", err)
+	}
+}
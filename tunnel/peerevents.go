@@ -0,0 +1,130 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-go/v3/device"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+)
+
+// handshakeStaleAfter matches the device's RejectAfterTime, after which a session can no longer carry data.
+const handshakeStaleAfter = 180 * time.Second
+
+type peerObservation struct {
+	lastHandshake conf.HandshakeTime
+	endpoint      string
+	stale         bool
+}
+
+// peerTracker turns successive UAPI snapshots into handshake and endpoint events.
+type peerTracker struct {
+	peers map[conf.Key]*peerObservation
+}
+
+func newPeerTracker() *peerTracker {
+	return &peerTracker{peers: make(map[conf.Key]*peerObservation)}
+}
+
+func (tracker *peerTracker) observe(now time.Time, config *conf.Config) []Event {
+	var events []Event
+	seen := make(map[conf.Key]bool, len(config.Peers))
+	for i := range config.Peers {
+		peer := &config.Peers[i]
+		seen[peer.PublicKey] = true
+		endpoint := ""
+		if !peer.Endpoint.IsEmpty() {
+			endpoint = peer.Endpoint.String()
+		}
+		event := Event{Peer: peer.PublicKey.String(), Endpoint: endpoint}
+		last, ok := tracker.peers[peer.PublicKey]
+		if !ok {
+			last = &peerObservation{endpoint: endpoint}
+			tracker.peers[peer.PublicKey] = last
+		}
+		if endpoint != last.endpoint {
+			last.endpoint = endpoint
+			event.Kind = EventEndpointChanged
+			events = append(events, event)
+		}
+		if peer.LastHandshakeTime.IsEmpty() {
+			continue
+		}
+		if peer.LastHandshakeTime != last.lastHandshake {
+			last.lastHandshake = peer.LastHandshakeTime
+			last.stale = false
+			event.Kind = EventHandshakeCompleted
+			events = append(events, event)
+		}
+		if !last.stale && now.Sub(time.Unix(0, 0).Add(time.Duration(peer.LastHandshakeTime))) > handshakeStaleAfter {
+			last.stale = true
+			event.Kind = EventHandshakeStale
+			events = append(events, event)
+		}
+	}
+	for key := range tracker.peers {
+		if !seen[key] {
+			delete(tracker.peers, key)
+		}
+	}
+	return events
+}
+
+// handshakeInitiationFormat is how the device logs each handshake initiation it sends, with the *device.Peer it
+// sends it to. UAPI only tells of the latest handshake that completed, so this is the only sign of attempts.
+const handshakeInitiationFormat = "%v - Sending handshake initiation"
+
+// handshakeInitiations publishes an event for each handshake initiation a device sends, whether or not verbose
+// lines are logged. Peers are told by the *device.Peer the device logs rather than by its abbreviated name,
+// which two peers can share.
+type handshakeInitiations struct {
+	bus        *EventBus
+	mu         sync.RWMutex
+	publicKeys map[*device.Peer]string
+}
+
+func newHandshakeInitiations(bus *EventBus) *handshakeInitiations {
+	return &handshakeInitiations{bus: bus}
+}
+
+// wrap returns a logger that publishes the handshake initiations logged to it before passing lines on to
+// logger.
+func (h *handshakeInitiations) wrap(logger *device.Logger) *device.Logger {
+	verbosef := logger.Verbosef
+	return &device.Logger{
+		Verbosef: func(format string, args ...any) {
+			if format == handshakeInitiationFormat && len(args) == 1 {
+				if peer, ok := args[0].(*device.Peer); ok {
+					h.mu.RLock()
+					publicKey, ok := h.publicKeys[peer]
+					h.mu.RUnlock()
+					if ok {
+						h.bus.Publish(Event{Kind: EventHandshakeInitiated, Peer: publicKey})
+					}
+				}
+			}
+			verbosef(format, args...)
+		},
+		Errorf: logger.Errorf,
+	}
+}
+
+// lookupPeers maps the peers of the device back to the public keys of the configuration, once it has been set
+// on the device and before the device is brought up.
+func (h *handshakeInitiations) lookupPeers(dev *device.Device, config *conf.Config) {
+	publicKeys := make(map[*device.Peer]string, len(config.Peers))
+	for i := range config.Peers {
+		if peer := dev.LookupPeer(device.NoisePublicKey(config.Peers[i].PublicKey)); peer != nil {
+			publicKeys[peer] = config.Peers[i].PublicKey.String()
+		}
+	}
+	h.mu.Lock()
+	h.publicKeys = publicKeys
+	h.mu.Unlock()
+}
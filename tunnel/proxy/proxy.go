@@ -0,0 +1,407 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package proxy serves SOCKS5 and HTTP CONNECT on the same listener, telling them apart by the first byte a
+// client sends, and connects to the requested destinations with a caller-supplied dialer, such as that of a
+// tunnel's userspace network stack.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DialFunc connects to address, which is a host and port, over TCP.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+const handshakeTimeout = time.Second * 30
+
+// Server is safe for concurrent use, and serves any number of listeners until closed. When Username is set,
+// clients must authenticate with it and Password, using username/password authentication for SOCKS5 and basic
+// authentication for HTTP.
+type Server struct {
+	Dial     DialFunc
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	wg        sync.WaitGroup
+}
+
+var ErrServerClosed = errors.New("proxy: server closed")
+
+// Serve accepts connections from listener until either fails or the server is closed, returning
+// ErrServerClosed in the latter case. The listener is closed when Serve returns.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[listener] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		s.mu.Unlock()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(time.Millisecond * 50)
+				continue
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.handle(conn)
+		}()
+	}
+}
+
+// Close stops all listeners, closes all connections and waits for them to be done.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = true
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *Server) handle(client net.Conn) {
+	client.SetDeadline(time.Now().Add(handshakeTimeout))
+	reader := bufio.NewReader(client)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	var remote net.Conn
+	if first[0] == socksVersion {
+		remote, err = s.handshakeSOCKS(client, reader)
+	} else {
+		remote, err = s.handshakeHTTP(client, reader)
+	}
+	if err != nil {
+		return
+	}
+	if !s.track(remote) {
+		remote.Close()
+		return
+	}
+	defer s.untrack(remote)
+	client.SetDeadline(time.Time{})
+	relay(client, reader, remote)
+}
+
+func (s *Server) authenticated(username, password string) bool {
+	usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(s.Username))
+	passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(s.Password))
+	return usernameMatches&passwordMatches == 1
+}
+
+func (s *Server) dial(address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	conn, err := s.Dial(ctx, "tcp", address)
+	if err != nil {
+		log.Printf("Proxy: unable to connect to %s: %v", address, err)
+	}
+	return conn, err
+}
+
+// relay copies in both directions until both are done, passing on half-closes where the connections support
+// them.
+func relay(client net.Conn, clientReader io.Reader, remote net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, clientReader)
+		closeWrite(remote)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, remote)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+const (
+	socksVersion = 5
+
+	socksMethodNoAuth       = 0x00
+	socksMethodPassword     = 0x02
+	socksMethodNoAcceptable = 0xff
+
+	socksPasswordVersion = 1
+
+	socksCommandConnect = 1
+
+	socksAddressIPv4   = 1
+	socksAddressDomain = 3
+	socksAddressIPv6   = 4
+
+	socksReplySucceeded           = 0
+	socksReplyGeneralFailure      = 1
+	socksReplyHostUnreachable     = 4
+	socksReplyCommandNotSupported = 7
+	socksReplyAddressNotSupported = 8
+)
+
+var errSOCKSRejected = errors.New("proxy: SOCKS request rejected")
+
+func (s *Server) handshakeSOCKS(client net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return nil, err
+	}
+	wanted := byte(socksMethodNoAuth)
+	if len(s.Username) > 0 {
+		wanted = socksMethodPassword
+	}
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == wanted {
+			method = m
+		}
+	}
+	if _, err := client.Write([]byte{socksVersion, method}); err != nil {
+		return nil, err
+	}
+	if method == socksMethodNoAcceptable {
+		return nil, errSOCKSRejected
+	}
+	if method == socksMethodPassword {
+		if err := s.authenticateSOCKS(client, reader); err != nil {
+			return nil, err
+		}
+	}
+
+	var request [4]byte
+	if _, err := io.ReadFull(reader, request[:]); err != nil {
+		return nil, err
+	}
+	if request[0] != socksVersion {
+		return nil, errSOCKSRejected
+	}
+	var host string
+	switch request[3] {
+	case socksAddressIPv4, socksAddressIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAddressIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	case socksAddressDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return nil, err
+		}
+		host = string(domain)
+	default:
+		writeSOCKSReply(client, socksReplyAddressNotSupported, nil)
+		return nil, errSOCKSRejected
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(reader, port[:]); err != nil {
+		return nil, err
+	}
+	if request[1] != socksCommandConnect {
+		writeSOCKSReply(client, socksReplyCommandNotSupported, nil)
+		return nil, errSOCKSRejected
+	}
+
+	remote, err := s.dial(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))))
+	if err != nil {
+		writeSOCKSReply(client, socksReplyHostUnreachable, nil)
+		return nil, err
+	}
+	if err = writeSOCKSReply(client, socksReplySucceeded, remote.LocalAddr()); err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return remote, nil
+}
+
+// authenticateSOCKS performs the username/password subnegotiation of RFC 1929.
+func (s *Server) authenticateSOCKS(client net.Conn, reader *bufio.Reader) error {
+	readField := func() (string, error) {
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		field := make([]byte, length)
+		_, err = io.ReadFull(reader, field)
+		return string(field), err
+	}
+	version, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != socksPasswordVersion {
+		return errSOCKSRejected
+	}
+	username, err := readField()
+	if err != nil {
+		return err
+	}
+	password, err := readField()
+	if err != nil {
+		return err
+	}
+	if !s.authenticated(username, password) {
+		client.Write([]byte{socksPasswordVersion, 1})
+		return errSOCKSRejected
+	}
+	_, err = client.Write([]byte{socksPasswordVersion, 0})
+	return err
+}
+
+func writeSOCKSReply(client net.Conn, reply byte, bound net.Addr) error {
+	ip, port := net.IP(net.IPv4zero.To4()), 0
+	if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+		port = tcpAddr.Port
+		if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+			ip = ip4
+		} else if len(tcpAddr.IP) == net.IPv6len {
+			ip = tcpAddr.IP
+		}
+	}
+	message := []byte{socksVersion, reply, 0, socksAddressIPv4}
+	if len(ip) == net.IPv6len {
+		message[3] = socksAddressIPv6
+	}
+	message = append(message, ip...)
+	message = binary.BigEndian.AppendUint16(message, uint16(port))
+	_, err := client.Write(message)
+	return err
+}
+
+func (s *Server) handshakeHTTP(client net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+	if request.Method != http.MethodConnect {
+		writeHTTPStatus(client, http.StatusMethodNotAllowed, "")
+		return nil, errors.New("proxy: only CONNECT is supported")
+	}
+	if len(s.Username) > 0 {
+		username, password, ok := parseBasicAuth(request.Header.Get("Proxy-Authorization"))
+		if !ok || !s.authenticated(username, password) {
+			writeHTTPStatus(client, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"AmneziaWG\"\r\n")
+			return nil, errors.New("proxy: authentication failed")
+		}
+	}
+	remote, err := s.dial(request.Host)
+	if err != nil {
+		writeHTTPStatus(client, http.StatusBadGateway, "")
+		return nil, err
+	}
+	if _, err = io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return remote, nil
+}
+
+func parseBasicAuth(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return
+}
+
+func writeHTTPStatus(client net.Conn, status int, headers string) {
+	io.WriteString(client, "HTTP/1.1 "+strconv.Itoa(status)+" "+http.StatusText(status)+"\r\n"+headers+"Connection: close\r\nContent-Length: 0\r\n\r\n")
+}
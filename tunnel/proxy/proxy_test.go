@@ -0,0 +1,338 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startEcho listens on loopback and echoes back whatever each connection sends.
+func startEcho(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// startProxy serves a proxy that resolves "echo.test" to echo and dials everything else as is.
+func startProxy(t *testing.T, echo net.Listener) (*Server, string) {
+	return startProxyWithCredentials(t, echo, "", "")
+}
+
+func startProxyWithCredentials(t *testing.T, echo net.Listener, username, password string) (*Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if host == "echo.test" {
+			_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+			if port != echoPort {
+				return nil, errors.New("connection refused")
+			}
+			address = echo.Addr().String()
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}, Username: username, Password: password}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return server, listener.Addr().String()
+}
+
+func dialProxy(t *testing.T, address string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func socksConnect(t *testing.T, conn net.Conn, command byte, addressType byte, address []byte, port uint16) byte {
+	t.Helper()
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	var method [2]byte
+	if _, err := io.ReadFull(conn, method[:]); err != nil {
+		t.Fatal(err)
+	}
+	if method != [2]byte{5, 0} {
+		t.Fatalf("method selection = %v", method)
+	}
+	request := append([]byte{5, command, 0, addressType}, address...)
+	request = binary.BigEndian.AppendUint16(request, port)
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		t.Fatal(err)
+	}
+	bound := net.IPv4len
+	if reply[3] == 4 {
+		bound = net.IPv6len
+	}
+	if _, err := io.ReadFull(conn, make([]byte, bound+2)); err != nil {
+		t.Fatal(err)
+	}
+	return reply[1]
+}
+
+func assertEcho(t *testing.T, conn io.ReadWriter) {
+	t.Helper()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	var pong [4]byte
+	if _, err := io.ReadFull(conn, pong[:]); err != nil {
+		t.Fatal(err)
+	}
+	if string(pong[:]) != "ping" {
+		t.Errorf("echo = %q", pong)
+	}
+}
+
+func echoPort(echo net.Listener) uint16 {
+	return uint16(echo.Addr().(*net.TCPAddr).Port)
+}
+
+func TestSOCKS(t *testing.T) {
+	echo := startEcho(t)
+	_, address := startProxy(t, echo)
+
+	t.Run("ipv4", func(t *testing.T) {
+		conn := dialProxy(t, address)
+		if reply := socksConnect(t, conn, 1, 1, net.IPv4(127, 0, 0, 1).To4(), echoPort(echo)); reply != 0 {
+			t.Fatalf("reply = %d", reply)
+		}
+		assertEcho(t, conn)
+	})
+	t.Run("domain", func(t *testing.T) {
+		conn := dialProxy(t, address)
+		domain := append([]byte{byte(len("echo.test"))}, "echo.test"...)
+		if reply := socksConnect(t, conn, 1, 3, domain, echoPort(echo)); reply != 0 {
+			t.Fatalf("reply = %d", reply)
+		}
+		assertEcho(t, conn)
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		conn := dialProxy(t, address)
+		domain := append([]byte{byte(len("echo.test"))}, "echo.test"...)
+		if reply := socksConnect(t, conn, 1, 3, domain, echoPort(echo)+1); reply != 4 {
+			t.Errorf("reply = %d, want host unreachable", reply)
+		}
+	})
+	t.Run("bind", func(t *testing.T) {
+		conn := dialProxy(t, address)
+		if reply := socksConnect(t, conn, 2, 1, net.IPv4(127, 0, 0, 1).To4(), echoPort(echo)); reply != 7 {
+			t.Errorf("reply = %d, want command not supported", reply)
+		}
+	})
+	t.Run("no acceptable method", func(t *testing.T) {
+		conn := dialProxy(t, address)
+		conn.Write([]byte{5, 1, 2})
+		var method [2]byte
+		if _, err := io.ReadFull(conn, method[:]); err != nil {
+			t.Fatal(err)
+		}
+		if method != [2]byte{5, 0xff} {
+			t.Errorf("method selection = %v", method)
+		}
+	})
+}
+
+func TestHTTPConnect(t *testing.T) {
+	echo := startEcho(t)
+	_, address := startProxy(t, echo)
+	connect := func(t *testing.T, method, target string) (*bufio.Reader, net.Conn, int) {
+		conn := dialProxy(t, address)
+		if _, err := conn.Write([]byte(method + " " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reader, conn, response.StatusCode
+	}
+
+	t.Run("connect", func(t *testing.T) {
+		reader, conn, status := connect(t, "CONNECT", net.JoinHostPort("echo.test", strconv.Itoa(int(echoPort(echo)))))
+		if status != http.StatusOK {
+			t.Fatalf("status = %d", status)
+		}
+		assertEcho(t, struct {
+			io.Reader
+			io.Writer
+		}{reader, conn})
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		if _, _, status := connect(t, "CONNECT", "echo.test:1"); status != http.StatusBadGateway {
+			t.Errorf("status = %d, want %d", status, http.StatusBadGateway)
+		}
+	})
+	t.Run("get", func(t *testing.T) {
+		if _, _, status := connect(t, "GET", "http://echo.test/"); status != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want %d", status, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestClose(t *testing.T) {
+	echo := startEcho(t)
+	server, address := startProxy(t, echo)
+	conn := dialProxy(t, address)
+	if reply := socksConnect(t, conn, 1, 1, net.IPv4(127, 0, 0, 1).To4(), echoPort(echo)); reply != 0 {
+		t.Fatalf("reply = %d", reply)
+	}
+	assertEcho(t, conn)
+	server.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("relayed connection still open after Close")
+	}
+	if err := server.Serve(startEcho(t)); err != ErrServerClosed {
+		t.Errorf("Serve after Close = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestSOCKSAuthentication(t *testing.T) {
+	echo := startEcho(t)
+	_, address := startProxyWithCredentials(t, echo, "alice", "secret")
+	authenticate := func(t *testing.T, username, password string) (net.Conn, byte) {
+		conn := dialProxy(t, address)
+		conn.Write([]byte{5, 2, 0, 2})
+		var method [2]byte
+		if _, err := io.ReadFull(conn, method[:]); err != nil {
+			t.Fatal(err)
+		}
+		if method != [2]byte{5, 2} {
+			t.Fatalf("method selection = %v, want username/password", method)
+		}
+		request := append([]byte{1, byte(len(username))}, username...)
+		request = append(append(request, byte(len(password))), password...)
+		conn.Write(request)
+		var status [2]byte
+		if _, err := io.ReadFull(conn, status[:]); err != nil {
+			t.Fatal(err)
+		}
+		return conn, status[1]
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		conn, status := authenticate(t, "alice", "secret")
+		if status != 0 {
+			t.Fatalf("authentication status = %d", status)
+		}
+		request := append([]byte{5, 1, 0, 1}, net.IPv4(127, 0, 0, 1).To4()...)
+		conn.Write(binary.BigEndian.AppendUint16(request, echoPort(echo)))
+		reply := make([]byte, 10)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply[1] != 0 {
+			t.Fatalf("reply = %d", reply[1])
+		}
+		assertEcho(t, conn)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		conn, status := authenticate(t, "alice", "guess")
+		if status == 0 {
+			t.Fatal("authenticated with the wrong password")
+		}
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("connection still open after failed authentication")
+		}
+	})
+	t.Run("no authentication offered", func(t *testing.T) {
+		conn := dialProxy(t, address)
+		conn.Write([]byte{5, 1, 0})
+		var method [2]byte
+		if _, err := io.ReadFull(conn, method[:]); err != nil {
+			t.Fatal(err)
+		}
+		if method != [2]byte{5, 0xff} {
+			t.Errorf("method selection = %v, want no acceptable methods", method)
+		}
+	})
+}
+
+func TestHTTPAuthentication(t *testing.T) {
+	echo := startEcho(t)
+	_, address := startProxyWithCredentials(t, echo, "alice", "secret")
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(echoPort(echo))))
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"missing", "", http.StatusProxyAuthRequired},
+		{"wrong password", "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:guess")), http.StatusProxyAuthRequired},
+		{"not basic", "Bearer secret", http.StatusProxyAuthRequired},
+		{"valid", "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret")), http.StatusOK},
+		{"valid lowercase scheme", "basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret")), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialProxy(t, address)
+			request := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+			if len(tt.authorization) > 0 {
+				request += "Proxy-Authorization: " + tt.authorization + "\r\n"
+			}
+			conn.Write([]byte(request + "\r\n"))
+			reader := bufio.NewReader(conn)
+			response, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", response.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusProxyAuthRequired && len(response.Header.Get("Proxy-Authenticate")) == 0 {
+				t.Error("no Proxy-Authenticate header")
+			}
+			if tt.wantStatus == http.StatusOK {
+				assertEcho(t, struct {
+					io.Reader
+					io.Writer
+				}{reader, conn})
+			}
+		})
+	}
+}
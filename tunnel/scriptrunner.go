@@ -8,7 +8,6 @@ package tunnel
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -18,15 +17,16 @@ import (
 	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
 )
 
-func runScriptCommand(command, interfaceName string) error {
+// runScriptCommand runs the command with cmd, writing what it outputs and how it went with logf.
+func runScriptCommand(command, interfaceName string, logf func(format string, args ...any)) error {
 	if len(command) == 0 {
 		return nil
 	}
 	if !conf.AdminBool("DangerousScriptExecution") {
-		log.Printf("Skipping execution of script, because dangerous script execution is safely disabled: %#q", command)
+		logf("Skipping execution of script, because dangerous script execution is safely disabled: %#q", command)
 		return nil
 	}
-	log.Printf("Executing: %#q", command)
+	logf("Executing: %#q", command)
 	comspec, _ := os.LookupEnv("COMSPEC")
 	if len(comspec) == 0 {
 		system32, err := windows.GetSystemDirectory()
@@ -61,7 +61,7 @@ func runScriptCommand(command, interfaceName string) error {
 	go func() {
 		scanner := bufio.NewScanner(reader)
 		for scanner.Scan() {
-			log.Printf("cmd> %s", scanner.Text())
+			logf("cmd> %s", scanner.Text())
 		}
 	}()
 	state, err := process.Wait()
@@ -72,6 +72,6 @@ func runScriptCommand(command, interfaceName string) error {
 	if state.ExitCode() == 0 {
 		return nil
 	}
-	log.Printf("Command error exit status: %d", state.ExitCode())
+	logf("Command error exit status: %d", state.ExitCode())
 	return windows.ERROR_GENERIC_COMMAND_FAILED
 }
@@ -9,9 +9,11 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/amnezia-vpn/amneziawg-go/v3/conn"
@@ -26,6 +28,8 @@ import (
 	"github.com/amnezia-vpn/amneziawg-windows/v3/elevate"
 	"github.com/amnezia-vpn/amneziawg-windows/v3/ringlogger"
 	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/journal"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/netconfig"
 	"github.com/amnezia-vpn/amneziawg-windows/v3/version"
 )
 
@@ -41,8 +45,23 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 	var watcher *interfaceWatcher
 	var nativeTun *tun.NativeTun
 	var config *conf.Config
+	var archiver *ringlogger.Archiver
+	var events *eventServer
+	var interfaceProxy *localProxy
+	var exporter *metricsServer
+	var mutations *journal.Journal
 	var err error
 	serviceError := services.ErrorSuccess
+	startup := NewStartupTrace(service.Path)
+	var stage string
+	enterStage := func(name string) {
+		stage = name
+		startup.Stage(name)
+	}
+	enterStage("open-log")
+	stopPeerWatch := make(chan struct{}, 1)
+	stopWatchdog := make(chan struct{}, 1)
+	watchdogFailed := make(chan error, 1)
 
 	defer func() {
 		svcSpecificEC, exitCode = services.DetermineErrorCode(err, serviceError)
@@ -50,6 +69,11 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		if logErr != nil {
 			log.Println(logErr)
 		}
+		startup.Finish(logErr)
+		failure := services.NewFailure(stage, serviceError, err)
+		if saveErr := services.SaveFailure(service.Path, failure); saveErr != nil {
+			log.Printf("Unable to save failure: %v", saveErr)
+		}
 		changes <- svc.Status{State: svc.StopPending}
 
 		stopIt := make(chan bool, 1)
@@ -85,10 +109,17 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		}()
 
 		if logErr == nil && dev != nil && config != nil {
-			logErr = runScriptCommand(config.Interface.PreDown, config.Name)
+			logErr = runScriptCommand(config.Interface.PreDown, config.Name, log.Printf)
 		}
 		if watcher != nil {
 			watcher.Destroy()
+			// Destroy undid everything, and an unconfigured device had nothing to undo.
+			mutations.Clear()
+		}
+		stopPeerWatch <- struct{}{}
+		stopWatchdog <- struct{}{}
+		if interfaceProxy != nil {
+			interfaceProxy.Close()
 		}
 		if uapi != nil {
 			uapi.Close()
@@ -97,10 +128,24 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 			dev.Close()
 		}
 		if logErr == nil && dev != nil && config != nil {
-			_ = runScriptCommand(config.Interface.PostDown, config.Name)
+			_ = runScriptCommand(config.Interface.PostDown, config.Name, log.Printf)
+		}
+		shutdown := Event{Kind: EventShutdown, Error: serviceError}
+		if logErr != nil {
+			shutdown.Message = logErr.Error()
+		}
+		Events.Publish(shutdown)
+		if exporter != nil {
+			exporter.Close()
+		}
+		if events != nil {
+			events.Close()
 		}
 		stopIt <- true
 		log.Println("Shutting down")
+		if archiver != nil {
+			archiver.Close()
+		}
 	}()
 
 	err = ringlogger.InitGlobalLogger("TUN")
@@ -108,7 +153,13 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		serviceError = services.ErrorRingloggerOpen
 		return
 	}
+	archiver, err = ringlogger.StartGlobalArchiver()
+	if err != nil {
+		log.Printf("Warning: unable to archive log: %v", err)
+		err = nil
+	}
 
+	enterStage("load-configuration")
 	config, err = conf.LoadFromPath(service.Path)
 	if err != nil {
 		serviceError = services.ErrorLoadConfiguration
@@ -122,8 +173,18 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 	}
 
 	log.SetPrefix(fmt.Sprintf("[%s] ", config.Name))
+	ringlogger.Global.SetRedactor(config.Name, LogRedactor(config))
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(LogLevelOf(config))
+	logger := NewTunnelLogger(config.Name, logLevel)
 
 	log.Println("Starting", version.UserAgent())
+	Events.Publish(Event{Kind: EventServiceStarting, Message: version.UserAgent()})
+	events, err = listenEvents(config.Name, Events)
+	if err != nil {
+		logger.Warn("Unable to listen for event subscribers", "error", err)
+		err = nil
+	}
 
 	if m, err := mgr.Connect(); err == nil {
 		if lockStatus, err := m.LockStatus(); err == nil && lockStatus.IsLocked {
@@ -138,20 +199,29 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		m.Disconnect()
 	}
 
+	mutations = openJournal(config.Name)
+
+	enterStage("watch-interfaces")
 	log.Println("Watching network interfaces")
-	watcher, err = watchInterface()
+	watcher, err = watchInterface(netconfig.System{}, mutations)
 	if err != nil {
 		serviceError = services.ErrorSetNetConfig
 		return
 	}
 
+	enterStage("resolve-dns")
 	log.Println("Resolving DNS names")
+	conf.ResolveAttempted = func(name string, start time.Time, err error) {
+		startup.Attempt(start, err, "host", name)
+	}
 	uapiConf, err := config.ToUAPI()
+	conf.ResolveAttempted = nil
 	if err != nil {
 		serviceError = services.ErrorDNSLookup
 		return
 	}
 
+	enterStage("create-wintun")
 	log.Println("Creating Wintun interface")
 	var wintun tun.Device
 	for i := 0; i < 5; i++ {
@@ -159,7 +229,9 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 			time.Sleep(time.Second)
 			log.Printf("Retrying Wintun creation after failure because system just booted (T+%v): %v", windows.DurationSinceBoot(), err)
 		}
+		start := time.Now()
 		wintun, err = tun.CreateTUNWithRequestedGUID(config.Name, deterministicGUID(config), 0)
+		startup.Attempt(start, err)
 		if err == nil || windows.DurationSinceBoot() > time.Minute*4 {
 			break
 		}
@@ -175,19 +247,24 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 	} else {
 		log.Printf("Using Wintun/%d.%d", (wintunVersion>>16)&0xffff, wintunVersion&0xffff)
 	}
+	Events.Publish(Event{Kind: EventWintunCreated})
 
-	err = runScriptCommand(config.Interface.PreUp, config.Name)
+	enterStage("pre-up")
+	err = runScriptCommand(config.Interface.PreUp, config.Name, log.Printf)
 	if err != nil {
 		serviceError = services.ErrorRunScript
 		return
 	}
 
-	err = enableFirewall(config, nativeTun)
+	enterStage("enable-firewall")
+	err = enableFirewall(watcher.nc, config, nativeTun, mutations)
 	if err != nil {
 		serviceError = services.ErrorFirewall
 		return
 	}
+	Events.Publish(Event{Kind: EventFirewallEnabled})
 
+	enterStage("drop-privileges")
 	log.Println("Dropping privileges")
 	err = elevate.DropAllPrivileges(true)
 	if err != nil {
@@ -195,10 +272,13 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		return
 	}
 
+	enterStage("create-device")
 	log.Println("Creating interface instance")
 	bind := conn.NewDefaultBind()
-	dev = device.NewDevice(wintun, bind, &device.Logger{log.Printf, log.Printf})
+	handshakes := newHandshakeInitiations(Events)
+	dev = device.NewDevice(wintun, bind, handshakes.wrap(DeviceLogger(logger)))
 
+	enterStage("configure-device")
 	log.Println("Setting interface configuration")
 	uapi, err = ipc.UAPIListen(config.Name)
 	if err != nil {
@@ -210,12 +290,42 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		serviceError = services.ErrorDeviceSetConfig
 		return
 	}
+	handshakes.lookupPeers(dev, config)
 
+	enterStage("bring-up-peers")
 	log.Println("Bringing peers up")
 	dev.Up()
+	Events.Publish(Event{Kind: EventPeersUp})
+	go watchPeers(dev, config, stopPeerWatch)
+	if len(config.Interface.HandshakeWatchdog) > 0 {
+		log.Printf("Watching handshakes, escalating after %v", config.Interface.HandshakeWatchdog)
+		watchdog := newHandshakeWatchdog(dev, config, time.Now)
+		go func() {
+			if err := watchdog.run(time.Second*5, stopWatchdog); err != nil {
+				watchdogFailed <- err
+			}
+		}()
+	}
 
 	watcher.Configure(bind.(conn.BindSocketToInterface), config, nativeTun)
 
+	enterStage("start-proxy")
+	if !config.Interface.Proxy.IsEmpty() {
+		interfaceProxy, err = startInterfaceProxy(config, nativeTun)
+		if err != nil {
+			serviceError = services.ErrorProxyListen
+			return
+		}
+	}
+
+	if !config.Interface.Metrics.IsEmpty() {
+		exporter, err = startMetrics(config, dev, Events)
+		if err != nil {
+			logger.Warn("Unable to serve metrics", "error", err)
+			err = nil
+		}
+	}
+
 	log.Println("Listening for UAPI requests")
 	go func() {
 		for {
@@ -223,11 +333,12 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 			if err != nil {
 				continue
 			}
-			go dev.IpcHandle(conn)
+			go HandleUAPI(dev, conn, logLevel)
 		}
 	}()
 
-	err = runScriptCommand(config.Interface.PostUp, config.Name)
+	enterStage("post-up")
+	err = runScriptCommand(config.Interface.PostUp, config.Name, log.Printf)
 	if err != nil {
 		serviceError = services.ErrorRunScript
 		return
@@ -235,6 +346,8 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 
 	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
 	log.Println("Startup complete")
+	startup.Finish(nil)
+	stage = "running"
 
 	for {
 		select {
@@ -252,6 +365,9 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		case e := <-watcher.errors:
 			serviceError, err = e.serviceError, e.err
 			return
+		case <-watchdogFailed:
+			serviceError = services.ErrorHandshakeTimeout
+			return
 		}
 	}
 }
@@ -267,3 +383,28 @@ func Run(confPath string) error {
 	}
 	return svc.Run(serviceName, &tunnelService{confPath})
 }
+
+// watchPeers publishes handshake and endpoint events by polling the device until stopped.
+func watchPeers(dev *device.Device, config *conf.Config, stop <-chan struct{}) {
+	tracker := newPeerTracker()
+	ticker := time.NewTicker(time.Second * 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			uapi, err := dev.IpcGet()
+			if err != nil {
+				continue
+			}
+			current, err := conf.FromUAPI(strings.NewReader(uapi+"\n"), config)
+			if err != nil {
+				continue
+			}
+			for _, event := range tracker.observe(now, current) {
+				Events.Publish(event)
+			}
+		}
+	}
+}
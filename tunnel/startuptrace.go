@@ -0,0 +1,61 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/trace"
+)
+
+// StartupTrace times the stages of starting a tunnel service, keeping the trace next to the configuration as it
+// goes, so that the status API can tell a startup that hangs from one that failed.
+type StartupTrace struct {
+	recorder   *trace.Recorder
+	configPath string
+	finished   bool
+	saveFailed bool
+}
+
+func NewStartupTrace(configPath string) *StartupTrace {
+	return &StartupTrace{recorder: trace.NewRecorder(), configPath: configPath}
+}
+
+func (t *StartupTrace) Stage(name string) {
+	t.recorder.Stage(name)
+	t.save()
+}
+
+func (t *StartupTrace) Attempt(start time.Time, err error, attributes ...string) {
+	t.recorder.Attempt(start, err, attributes...)
+	t.save()
+}
+
+// Finish ends the startup, failed if err is not nil, and logs how long each stage took.
+func (t *StartupTrace) Finish(err error) {
+	if t.finished {
+		return
+	}
+	t.finished = true
+	t.recorder.Finish(err)
+	log.Printf("Startup stages: %s", t.recorder.Trace().Summary())
+	t.save()
+}
+
+func (t *StartupTrace) save() {
+	data, err := json.Marshal(t.recorder.Trace())
+	if err == nil {
+		err = conf.SaveStartupTrace(t.configPath, data)
+	}
+	if err != nil && !t.saveFailed {
+		// Once is enough, as it would fail for the same reason at every stage.
+		t.saveFailed = true
+		log.Printf("Unable to save startup trace: %v", err)
+	}
+}
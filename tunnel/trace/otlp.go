@@ -0,0 +1,135 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package trace
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// These mirror the messages of OTLP that a trace needs, in the JSON encoding of protocol buffers, in which
+// identifiers are hex and 64-bit integers are strings.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+// OTLP encodes the trace of the startup of the tunnel as an OTLP ExportTraceServiceRequest in JSON, with a span
+// for the startup, a child span for each stage, and grandchildren for the attempts within stages. Identifiers
+// are derived from the tunnel and the start of the trace, so exporting the same trace twice yields the same
+// spans. Spans that are still running end now.
+func (trace *Trace) OTLP(tunnelName string) ([]byte, error) {
+	now := time.Now()
+	seed := sha256.Sum256([]byte(tunnelName + "\x00" + trace.Start.Format(time.RFC3339Nano)))
+	traceID := hex.EncodeToString(seed[:16])
+	var spans []otlpSpan
+	addSpan := func(parent string, name string, start, end time.Time, failure string, attributes map[string]string) string {
+		var index [8]byte
+		binary.LittleEndian.PutUint64(index[:], uint64(len(spans)))
+		sum := sha256.Sum256(append(seed[:], index[:]...))
+		span := otlpSpan{
+			TraceID:           traceID,
+			SpanID:            hex.EncodeToString(sum[:8]),
+			ParentSpanID:      parent,
+			Name:              name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if end.IsZero() {
+			end = now
+		}
+		span.EndTimeUnixNano = strconv.FormatInt(end.UnixNano(), 10)
+		if len(failure) > 0 {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: failure}
+		}
+		keys := make([]string, 0, len(attributes))
+		for key := range attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			span.Attributes = append(span.Attributes, otlpAttribute{key, otlpValue{attributes[key]}})
+		}
+		spans = append(spans, span)
+		return span.SpanID
+	}
+	root := addSpan("", "startup", trace.Start, trace.End, trace.Error, nil)
+	for _, stage := range trace.Stages {
+		parent := addSpan(root, stage.Name, stage.Start, stage.End, stage.Error, stage.Attributes)
+		for i, attempt := range stage.Attempts {
+			attributes := map[string]string{"attempt": strconv.Itoa(i + 1)}
+			for key, value := range attempt.Attributes {
+				attributes[key] = value
+			}
+			addSpan(parent, attempt.Name, attempt.Start, attempt.End, attempt.Error, attributes)
+		}
+	}
+	return json.Marshal(&otlpTraces{[]otlpResourceSpans{{
+		Resource: otlpResource{[]otlpAttribute{
+			{"service.name", otlpValue{"amneziawg"}},
+			{"amneziawg.tunnel", otlpValue{tunnelName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{"github.com/amnezia-vpn/amneziawg-windows/v3/tunnel/trace"},
+			Spans: spans,
+		}},
+	}}})
+}
@@ -0,0 +1,158 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package trace times the stages of starting a tunnel, and the attempts within stages that are retried, so
+// that a slow startup can be pinned on the stage that is slow. A trace is kept as JSON for the status API, and
+// can be exported in the JSON encoding of OTLP, the OpenTelemetry protocol.
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span is a stage of the startup, or an attempt within a stage. A span that is still running has no end.
+type Span struct {
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end,omitzero"`
+	DurationMs float64           `json:"durationMs"`
+	Error      string            `json:"error,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Attempts   []Span            `json:"attempts,omitempty"`
+}
+
+func (span *Span) end(end time.Time, err error) {
+	span.End = end
+	span.DurationMs = float64(end.Sub(span.Start)) / float64(time.Millisecond)
+	if err != nil {
+		span.Error = err.Error()
+	}
+}
+
+// Trace is the startup of a tunnel, made of its stages in order.
+type Trace struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end,omitzero"`
+	DurationMs float64   `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+	Stages     []Span    `json:"stages"`
+}
+
+// Recorder builds the trace of a startup as it happens. It is safe for concurrent use.
+type Recorder struct {
+	// This is replaced by tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	trace    Trace
+	finished bool
+}
+
+// NewRecorder starts the trace of a startup.
+func NewRecorder() *Recorder {
+	r := &Recorder{now: time.Now}
+	r.trace.Start = r.now().UTC()
+	return r
+}
+
+func (r *Recorder) current() *Span {
+	if r.finished || len(r.trace.Stages) == 0 {
+		return nil
+	}
+	return &r.trace.Stages[len(r.trace.Stages)-1]
+}
+
+// Stage ends the current stage and begins the next one.
+func (r *Recorder) Stage(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return
+	}
+	now := r.now().UTC()
+	if stage := r.current(); stage != nil {
+		stage.end(now, nil)
+	}
+	r.trace.Stages = append(r.trace.Stages, Span{Name: name, Start: now})
+}
+
+// Attempt records an attempt within the current stage that began at start and has just ended with err. The
+// attributes are pairs of keys and values.
+func (r *Recorder) Attempt(start time.Time, err error, attributes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stage := r.current()
+	if stage == nil {
+		return
+	}
+	attempt := Span{Name: stage.Name, Start: start.UTC()}
+	if len(attributes) > 1 {
+		attempt.Attributes = make(map[string]string, len(attributes)/2)
+		for i := 0; i+1 < len(attributes); i += 2 {
+			attempt.Attributes[attributes[i]] = attributes[i+1]
+		}
+	}
+	attempt.end(r.now().UTC(), err)
+	stage.Attempts = append(stage.Attempts, attempt)
+}
+
+// Finish ends the current stage and the startup, both failed if err is not nil. Later stages and attempts are
+// not recorded.
+func (r *Recorder) Finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return
+	}
+	now := r.now().UTC()
+	if stage := r.current(); stage != nil {
+		stage.end(now, err)
+	}
+	r.finished = true
+	r.trace.End = now
+	r.trace.DurationMs = float64(now.Sub(r.trace.Start)) / float64(time.Millisecond)
+	if err != nil {
+		r.trace.Error = err.Error()
+	}
+}
+
+// Trace returns a copy of the trace so far.
+func (r *Recorder) Trace() *Trace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	trace := r.trace
+	trace.Stages = make([]Span, len(r.trace.Stages))
+	for i, stage := range r.trace.Stages {
+		stage.Attempts = append([]Span(nil), stage.Attempts...)
+		trace.Stages[i] = stage
+	}
+	return &trace
+}
+
+// Summary lists the stages with their durations, on one line for the log.
+func (trace *Trace) Summary() string {
+	var summary strings.Builder
+	for i, stage := range trace.Stages {
+		if i > 0 {
+			summary.WriteString(", ")
+		}
+		summary.WriteString(stage.Name)
+		if stage.End.IsZero() {
+			summary.WriteString(" unfinished")
+		} else {
+			fmt.Fprintf(&summary, " %v", stage.End.Sub(stage.Start).Round(time.Millisecond))
+		}
+		if len(stage.Attempts) > 1 {
+			fmt.Fprintf(&summary, " (%d attempts)", len(stage.Attempts))
+		}
+	}
+	if !trace.End.IsZero() {
+		fmt.Fprintf(&summary, "; %v in total", trace.End.Sub(trace.Start).Round(time.Millisecond))
+	}
+	return summary.String()
+}
@@ -0,0 +1,114 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testRecorder() (*Recorder, *time.Time) {
+	now := testEpoch
+	r := &Recorder{now: func() time.Time { return now }}
+	r.trace.Start = now
+	return r, &now
+}
+
+func TestRecorder(t *testing.T) {
+	r, now := testRecorder()
+	r.Stage("load-configuration")
+	*now = now.Add(5 * time.Millisecond)
+	r.Stage("create-wintun")
+	for i := 0; i < 3; i++ {
+		start := *now
+		*now = now.Add(time.Second)
+		var err error
+		if i < 2 {
+			err = errors.New("Element not found.")
+		}
+		r.Attempt(start, err)
+	}
+	r.Stage("resolve-dns")
+	running := r.Trace()
+	if summary := running.Summary(); summary != "load-configuration 5ms, create-wintun 3s (3 attempts), resolve-dns unfinished" {
+		t.Errorf("summary of running trace = %q", summary)
+	}
+
+	start := *now
+	*now = now.Add(250 * time.Millisecond)
+	r.Attempt(start, errors.New("No such host is known."), "host", "vpn.example.com")
+	r.Finish(errors.New("Unable to resolve one or more DNS hostname endpoints"))
+	r.Stage("too-late")
+	trace := r.Trace()
+	if len(trace.Stages) != 3 || trace.DurationMs != 3255 || trace.Error == "" {
+		t.Fatalf("trace = %+v", trace)
+	}
+	if stage := trace.Stages[1]; stage.DurationMs != 3000 || len(stage.Attempts) != 3 || stage.Attempts[0].Error != "Element not found." || stage.Attempts[2].Error != "" {
+		t.Errorf("create-wintun = %+v", stage)
+	}
+	if stage := trace.Stages[2]; stage.Error == "" || stage.Attempts[0].Attributes["host"] != "vpn.example.com" {
+		t.Errorf("resolve-dns = %+v", stage)
+	}
+	if len(running.Stages[1].Attempts) != 3 || !running.Stages[2].End.IsZero() {
+		t.Error("earlier copy of trace changed")
+	}
+
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Trace
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Summary() != trace.Summary() {
+		t.Errorf("loaded summary %q, want %q", loaded.Summary(), trace.Summary())
+	}
+}
+
+func TestOTLP(t *testing.T) {
+	r, now := testRecorder()
+	r.Stage("create-wintun")
+	start := *now
+	*now = now.Add(time.Second)
+	r.Attempt(start, errors.New("Element not found."))
+	r.Finish(nil)
+	trace := r.Trace()
+
+	data, err := trace.OTLP("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := trace.OTLP("office")
+	if string(data) != string(again) {
+		t.Error("exporting the same trace twice differs")
+	}
+	var export otlpTraces
+	if err = json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+	spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("%d spans, want 3", len(spans))
+	}
+	root, stage, attempt := spans[0], spans[1], spans[2]
+	if len(root.TraceID) != 32 || len(root.SpanID) != 16 || root.ParentSpanID != "" || root.Name != "startup" {
+		t.Errorf("root span = %+v", root)
+	}
+	if stage.ParentSpanID != root.SpanID || attempt.ParentSpanID != stage.SpanID || stage.SpanID == attempt.SpanID {
+		t.Errorf("spans are not nested: %+v", spans)
+	}
+	if attempt.Status.Code != otlpStatusError || attempt.Attributes[0] != (otlpAttribute{"attempt", otlpValue{"1"}}) {
+		t.Errorf("attempt span = %+v", attempt)
+	}
+	if stage.StartTimeUnixNano != "1614600000000000000" || stage.EndTimeUnixNano != "1614600001000000000" {
+		t.Errorf("stage span times = %s-%s", stage.StartTimeUnixNano, stage.EndTimeUnixNano)
+	}
+}
@@ -0,0 +1,177 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+// watchdogDevice is the part of *device.Device the watchdog acts on.
+type watchdogDevice interface {
+	IpcGet() (string, error)
+	IpcSet(uapiConf string) error
+	BindUpdate() error
+}
+
+type watchdogAction int
+
+const (
+	watchdogResolveEndpoints watchdogAction = iota
+	watchdogRebindSockets
+	watchdogRotateJunkPackets
+	watchdogFail
+)
+
+func (action watchdogAction) String() string {
+	switch action {
+	case watchdogResolveEndpoints:
+		return "re-resolving endpoints"
+	case watchdogRebindSockets:
+		return "rebinding sockets"
+	case watchdogRotateJunkPackets:
+		return "rotating junk packet parameters"
+	case watchdogFail:
+		return "giving up"
+	}
+	return "unknown action"
+}
+
+// The device retries a handshake every five seconds for as long as it has packets for a peer, so sending
+// nothing for longer than this means that no traffic is waiting for one.
+const watchdogIdleTime = time.Second * 15
+
+// handshakeWatchdog escalates through one action per threshold of conf.Interface.HandshakeWatchdog, measured
+// from the latest handshake of any peer, or from when the tunnel last went idle if that is later. A tunnel is
+// idle when it has sent nothing for watchdogIdleTime, as one without traffic or keepalives stops handshaking
+// once its session expires, and only pending traffic failing to get a handshake is escalated. A new handshake
+// or the tunnel going idle starts over from the first action.
+type handshakeWatchdog struct {
+	dev    watchdogDevice
+	config *conf.Config
+	now    func() time.Time
+
+	since         time.Time
+	lastHandshake conf.HandshakeTime
+	txBytes       conf.Bytes
+	lastSent      time.Time
+	stage         int
+	nextJunk      int
+}
+
+func newHandshakeWatchdog(dev watchdogDevice, config *conf.Config, now func() time.Time) *handshakeWatchdog {
+	return &handshakeWatchdog{dev: dev, config: config, now: now, since: now()}
+}
+
+// check polls the device once, performing at most one due action. It returns an error when the last action
+// was reached, which ends the tunnel.
+func (w *handshakeWatchdog) check() error {
+	uapi, err := w.dev.IpcGet()
+	if err != nil {
+		return nil
+	}
+	current, err := conf.FromUAPI(strings.NewReader(uapi+"\n"), w.config)
+	if err != nil {
+		return nil
+	}
+	now := w.now()
+	latest := w.lastHandshake
+	var txBytes conf.Bytes
+	for i := range current.Peers {
+		if current.Peers[i].LastHandshakeTime > latest {
+			latest = current.Peers[i].LastHandshakeTime
+		}
+		txBytes += current.Peers[i].TxBytes
+	}
+	if txBytes != w.txBytes {
+		w.txBytes = txBytes
+		w.lastSent = now
+	}
+	if latest != w.lastHandshake {
+		if w.stage > 0 {
+			log.Printf("Watchdog: handshake completed, standing down")
+		}
+		w.lastHandshake = latest
+		w.since = time.Unix(0, 0).Add(time.Duration(latest))
+		w.stage = 0
+	}
+	if now.Sub(w.lastSent) > watchdogIdleTime {
+		if w.stage > 0 {
+			log.Printf("Watchdog: no traffic pending, standing down")
+		}
+		w.since = now
+		w.stage = 0
+		return nil
+	}
+	thresholds := w.config.Interface.HandshakeWatchdog
+	if w.stage >= len(thresholds) {
+		return nil
+	}
+	age := now.Sub(w.since)
+	if age < thresholds[w.stage] {
+		return nil
+	}
+	action := watchdogAction(w.stage)
+	w.stage++
+	log.Printf("Watchdog: no handshake for %v, %v", age.Round(time.Second), action)
+	switch action {
+	case watchdogResolveEndpoints:
+		uapi, err := w.config.ToUAPIEndpoints()
+		if err != nil {
+			log.Printf("Watchdog: unable to resolve endpoints: %v", err)
+			Events.Publish(Event{Kind: EventResolveFailed, Message: err.Error()})
+			return nil
+		}
+		if len(uapi) == 0 {
+			return nil
+		}
+		err = w.dev.IpcSet(uapi)
+		if err != nil {
+			log.Printf("Watchdog: unable to update endpoints: %v", err)
+		}
+	case watchdogRebindSockets:
+		err = w.dev.BindUpdate()
+		if err != nil {
+			log.Printf("Watchdog: unable to rebind sockets: %v", err)
+		}
+	case watchdogRotateJunkPackets:
+		alternates := w.config.Interface.AlternateJunkPackets
+		if len(alternates) == 0 {
+			log.Printf("Watchdog: no alternate junk packet parameters configured")
+			return nil
+		}
+		junk := alternates[w.nextJunk%len(alternates)]
+		w.nextJunk++
+		log.Printf("Watchdog: switching to junk packets %v", junk)
+		err = w.dev.IpcSet(junk.ToUAPI())
+		if err != nil {
+			log.Printf("Watchdog: unable to set junk packet parameters: %v", err)
+		}
+	case watchdogFail:
+		return services.ErrorHandshakeTimeout
+	}
+	return nil
+}
+
+// run checks the device periodically until stopped, returning the failure that ended the tunnel, if any.
+func (w *handshakeWatchdog) run(interval time.Duration, stop <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := w.check(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows/v3/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/v3/services"
+)
+
+// fakeWatchdogDevice sends a handshake initiation at every poll while it is sending, as a device that has
+// packets for a peer it has no session with does.
+type fakeWatchdogDevice struct {
+	peer          conf.Key
+	lastHandshake time.Time
+	sending       bool
+	txBytes       uint64
+	actions       []string
+}
+
+func (dev *fakeWatchdogDevice) IpcGet() (string, error) {
+	if dev.sending {
+		dev.txBytes += 148
+	}
+	uapi := fmt.Sprintf("public_key=%s\ntx_bytes=%d\n", dev.peer.HexString(), dev.txBytes)
+	if !dev.lastHandshake.IsZero() {
+		uapi += fmt.Sprintf("last_handshake_time_sec=%d\nlast_handshake_time_nsec=0\n", dev.lastHandshake.Unix())
+	}
+	return uapi, nil
+}
+
+func (dev *fakeWatchdogDevice) IpcSet(uapiConf string) error {
+	dev.actions = append(dev.actions, "set "+uapiConf)
+	return nil
+}
+
+func (dev *fakeWatchdogDevice) BindUpdate() error {
+	dev.actions = append(dev.actions, "rebind")
+	return nil
+}
+
+func TestHandshakeWatchdog(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	config := &conf.Config{Name: "test", Peers: []conf.Peer{{PublicKey: conf.Key{1}, Endpoint: conf.Endpoint{Host: "192.0.2.1", Port: 51820}}}}
+	config.Interface.HandshakeWatchdog = []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute}
+	config.Interface.AlternateJunkPackets = []conf.JunkPackets{{Count: 4, MinSize: 40, MaxSize: 70}, {Count: 8, MinSize: 100, MaxSize: 500}}
+	dev := &fakeWatchdogDevice{peer: conf.Key{1}, sending: true}
+	watchdog := newHandshakeWatchdog(dev, config, clock)
+	endpointUpdate := fmt.Sprintf("set public_key=%s\nupdate_only=true\nendpoint=192.0.2.1:51820\n", dev.peer.HexString())
+
+	step := func(after time.Duration, wantErr error, wantActions ...string) {
+		t.Helper()
+		now = now.Add(after)
+		dev.actions = nil
+		err := watchdog.check()
+		if err != wantErr {
+			t.Errorf("check() after %v = %v, want %v", after, err, wantErr)
+		}
+		if !reflect.DeepEqual(dev.actions, wantActions) {
+			t.Errorf("actions after %v = %q, want %q", after, dev.actions, wantActions)
+		}
+	}
+
+	step(59*time.Second, nil)
+	step(time.Second, nil, endpointUpdate)
+	step(time.Second, nil)
+	step(time.Minute, nil, "rebind")
+	step(time.Minute, nil, "set jc=4\njmin=40\njmax=70\n")
+
+	// A handshake resets the escalation, measured from the handshake itself.
+	dev.lastHandshake = now.Add(-10 * time.Second)
+	step(0, nil)
+	step(50*time.Second, nil, endpointUpdate)
+	step(time.Minute, nil, "rebind")
+	step(time.Minute, nil, "set jc=8\njmin=100\njmax=500\n")
+	step(time.Minute, services.ErrorHandshakeTimeout)
+	step(time.Hour, nil)
+}
+
+func TestHandshakeWatchdogPartialStages(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	config := &conf.Config{Name: "test", Peers: []conf.Peer{{PublicKey: conf.Key{1}}}}
+	config.Interface.HandshakeWatchdog = []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	dev := &fakeWatchdogDevice{peer: conf.Key{1}, sending: true}
+	watchdog := newHandshakeWatchdog(dev, config, func() time.Time { return now })
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Minute)
+		if err := watchdog.check(); err != nil {
+			t.Fatalf("check() = %v, but failing was not configured", err)
+		}
+	}
+	if !reflect.DeepEqual(dev.actions, []string{"rebind"}) {
+		t.Errorf("actions = %q, want only a rebind, as there are no endpoints or alternate junk packets", dev.actions)
+	}
+}
+
+func TestHandshakeWatchdogIdle(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	config := &conf.Config{Name: "test", Peers: []conf.Peer{{PublicKey: conf.Key{1}, Endpoint: conf.Endpoint{Host: "192.0.2.1", Port: 51820}}}}
+	config.Interface.HandshakeWatchdog = []time.Duration{2 * time.Minute, 4 * time.Minute, 6 * time.Minute, 8 * time.Minute}
+	dev := &fakeWatchdogDevice{peer: conf.Key{1}, lastHandshake: now, txBytes: 4096}
+	watchdog := newHandshakeWatchdog(dev, config, func() time.Time { return now })
+
+	// Without traffic, the session expires and no handshake follows, which is healthy.
+	for i := 0; i < 720; i++ {
+		now = now.Add(5 * time.Second)
+		if err := watchdog.check(); err != nil {
+			t.Fatalf("check() after %v idle = %v", time.Duration(i+1)*5*time.Second, err)
+		}
+	}
+	if len(dev.actions) != 0 {
+		t.Fatalf("idle tunnel escalated: %q", dev.actions)
+	}
+
+	// Traffic that gets no handshake escalates from when it started, and going idle again stands down.
+	dev.sending = true
+	for i := 0; i < 24; i++ {
+		now = now.Add(5 * time.Second)
+		watchdog.check()
+	}
+	if len(dev.actions) != 1 {
+		t.Fatalf("actions after two minutes of traffic = %q, want the endpoints re-resolved", dev.actions)
+	}
+	dev.sending = false
+	for i := 0; i < 720; i++ {
+		now = now.Add(5 * time.Second)
+		if err := watchdog.check(); err != nil {
+			t.Fatalf("check() after traffic stopped = %v", err)
+		}
+	}
+	if len(dev.actions) != 1 {
+		t.Errorf("tunnel escalated after going idle: %q", dev.actions)
+	}
+}
@@ -0,0 +1,160 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package winipcfg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// Rules in the group policy key take precedence over, and entirely disable, rules in the local key.
+const (
+	nrptLocalKey       = `SYSTEM\CurrentControlSet\Services\Dnscache\Parameters\DnsPolicyConfig`
+	nrptGroupPolicyKey = `SOFTWARE\Policies\Microsoft\Windows NT\DNSClient\DnsPolicyConfig`
+
+	nrptRuleVersion          = 2
+	nrptConfigGenericServers = 0x8
+)
+
+// NRPTRule is a Name Resolution Policy Table rule, which sends queries for names within Domains to Servers,
+// regardless of which DNS servers are configured on the adapters.
+type NRPTRule struct {
+	Domains []string
+	Servers []net.IP
+}
+
+// nrptValues are the registry values making up a single rule.
+type nrptValues struct {
+	version            uint32
+	names              []string
+	genericDNSServers  string
+	configOptions      uint32
+	ipsecCARestriction string
+}
+
+// NRPTNamespace converts a domain, optionally written as a ‘~domain’ routing domain, into the namespace matched
+// by an NRPT rule. The namespace ‘.’ matches all names. Domains are validated where they are configured, so this
+// only normalizes them.
+func NRPTNamespace(domain string) string {
+	return "." + strings.ToLower(strings.Trim(strings.TrimPrefix(domain, "~"), "."))
+}
+
+func (rule *NRPTRule) values() (*nrptValues, error) {
+	if len(rule.Domains) == 0 || len(rule.Servers) == 0 {
+		return nil, windows.ERROR_INVALID_PARAMETER
+	}
+	names := make([]string, 0, len(rule.Domains))
+	seen := make(map[string]bool, len(rule.Domains))
+	for _, domain := range rule.Domains {
+		name := NRPTNamespace(domain)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	servers := make([]string, 0, len(rule.Servers))
+	for _, server := range rule.Servers {
+		if server.To16() == nil {
+			return nil, windows.ERROR_INVALID_PARAMETER
+		}
+		servers = append(servers, server.String())
+	}
+	return &nrptValues{
+		version:           nrptRuleVersion,
+		names:             names,
+		genericDNSServers: strings.Join(servers, "; "),
+		configOptions:     nrptConfigGenericServers,
+	}, nil
+}
+
+// nrptRuleKeyName returns the name of the registry key holding the rule owned by the adapter with the given GUID.
+func nrptRuleKeyName(guid *windows.GUID) string {
+	return guid.String()
+}
+
+func nrptGroupPolicyActive() bool {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, nrptGroupPolicyKey, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return false
+	}
+	defer key.Close()
+	names, err := key.ReadSubKeyNames(1)
+	return err == nil && len(names) > 0
+}
+
+// SetNRPTRule method replaces the NRPT rule owned by the adapter. Passing nil or a rule without domains removes it.
+func (luid LUID) SetNRPTRule(rule *NRPTRule) error {
+	if rule == nil || len(rule.Domains) == 0 {
+		return luid.FlushNRPTRule()
+	}
+	values, err := rule.values()
+	if err != nil {
+		return err
+	}
+	guid, err := luid.GUID()
+	if err != nil {
+		return err
+	}
+	if nrptGroupPolicyActive() {
+		return errors.New("NRPT rules are managed by group policy")
+	}
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, nrptLocalKey+`\`+nrptRuleKeyName(guid), registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("Unable to create NRPT rule registry key: %w", err)
+	}
+	defer key.Close()
+	err = key.SetDWordValue("Version", values.version)
+	if err != nil {
+		return err
+	}
+	err = key.SetStringsValue("Name", values.names)
+	if err != nil {
+		return err
+	}
+	err = key.SetStringValue("GenericDNSServers", values.genericDNSServers)
+	if err != nil {
+		return err
+	}
+	err = key.SetDWordValue("ConfigOptions", values.configOptions)
+	if err != nil {
+		return err
+	}
+	err = key.SetStringValue("IPSECCARestriction", values.ipsecCARestriction)
+	if err != nil {
+		return err
+	}
+	dnsFlushResolverCache()
+	return nil
+}
+
+// FlushNRPTRule method removes the NRPT rule owned by the adapter, if there is one.
+func (luid LUID) FlushNRPTRule() error {
+	guid, err := luid.GUID()
+	if err != nil {
+		return err
+	}
+	return DeleteNRPTRule(guid)
+}
+
+// DeleteNRPTRule function removes the NRPT rule owned by the adapter with the given GUID, if there is one,
+// which works even after the adapter itself is gone.
+func DeleteNRPTRule(guid *windows.GUID) error {
+	err := registry.DeleteKey(registry.LOCAL_MACHINE, nrptLocalKey+`\`+nrptRuleKeyName(guid))
+	if err == windows.ERROR_FILE_NOT_FOUND {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dnsFlushResolverCache()
+	return nil
+}
@@ -0,0 +1,71 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package winipcfg
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestNRPTNamespace(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"corp.example", ".corp.example"},
+		{"~corp.example", ".corp.example"},
+		{"~Corp.Example.", ".corp.example"},
+		{".corp.example", ".corp.example"},
+		{"~.", "."},
+		{".", "."},
+		{"_ldap.corp-1.example", "._ldap.corp-1.example"},
+	}
+	for _, tt := range tests {
+		if got := NRPTNamespace(tt.domain); got != tt.want {
+			t.Errorf("NRPTNamespace(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestNRPTRuleValues(t *testing.T) {
+	rule := NRPTRule{
+		Domains: []string{"~corp.example", "lab.corp.example", "~CORP.example"},
+		Servers: []net.IP{net.IPv4(10, 64, 0, 1), net.ParseIP("fd00::1")},
+	}
+	values, err := rule.values()
+	if err != nil {
+		t.Fatalf("values() returned error: %v", err)
+	}
+	want := &nrptValues{
+		version:           nrptRuleVersion,
+		names:             []string{".corp.example", ".lab.corp.example"},
+		genericDNSServers: "10.64.0.1; fd00::1",
+		configOptions:     nrptConfigGenericServers,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values() = %#v, want %#v", values, want)
+	}
+
+	for _, rule := range []NRPTRule{
+		{Domains: []string{"corp.example"}},
+		{Servers: []net.IP{net.IPv4(10, 64, 0, 1)}},
+		{Domains: []string{"corp.example"}, Servers: []net.IP{{1, 2, 3}}},
+	} {
+		if _, err := rule.values(); err == nil {
+			t.Errorf("values() of %#v should have failed", rule)
+		}
+	}
+}
+
+func TestNRPTRuleKeyName(t *testing.T) {
+	guid := windows.GUID{Data1: 0xdeadbeef, Data2: 0x1234, Data3: 0x5678, Data4: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	if name := nrptRuleKeyName(&guid); name != "{DEADBEEF-1234-5678-0102-030405060708}" {
+		t.Errorf("nrptRuleKeyName() = %q", name)
+	}
+}
@@ -173,6 +173,8 @@ func GetIPForwardTable2(family AddressFamily) ([]MibIPforwardRow2, error) {
 // Undocumented DNS API
 //
 
+//sys	dnsFlushResolverCache() (err error) = dnsapi.DnsFlushResolverCache
+
 //sys	setInterfaceDnsSettingsByPtr(guid *windows.GUID, settings *dnsInterfaceSettings) (ret error) = iphlpapi.SetInterfaceDnsSettings?
 //sys	setInterfaceDnsSettingsByQwords(guid1 uintptr, guid2 uintptr, settings *dnsInterfaceSettings) (ret error) = iphlpapi.SetInterfaceDnsSettings?
 //sys	setInterfaceDnsSettingsByDwords(guid1 uintptr, guid2 uintptr, guid3 uintptr, guid4 uintptr, settings *dnsInterfaceSettings) (ret error) = iphlpapi.SetInterfaceDnsSettings?
@@ -38,8 +38,10 @@ func errnoErr(e syscall.Errno) error {
 }
 
 var (
+	moddnsapi   = windows.NewLazySystemDLL("dnsapi.dll")
 	modiphlpapi = windows.NewLazySystemDLL("iphlpapi.dll")
 
+	procDnsFlushResolverCache           = moddnsapi.NewProc("DnsFlushResolverCache")
 	procCancelMibChangeNotify2          = modiphlpapi.NewProc("CancelMibChangeNotify2")
 	procConvertInterfaceGuidToLuid      = modiphlpapi.NewProc("ConvertInterfaceGuidToLuid")
 	procConvertInterfaceIndexToLuid     = modiphlpapi.NewProc("ConvertInterfaceIndexToLuid")
@@ -81,6 +83,14 @@ func cancelMibChangeNotify2(notificationHandle windows.Handle) (ret error) {
 	return
 }
 
+func dnsFlushResolverCache() (err error) {
+	r1, _, e1 := syscall.Syscall(procDnsFlushResolverCache.Addr(), 0, 0, 0, 0)
+	if r1 == 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
 func convertInterfaceGUIDToLUID(interfaceGUID *windows.GUID, interfaceLUID *LUID) (ret error) {
 	r0, _, _ := syscall.Syscall(procConvertInterfaceGuidToLuid.Addr(), 2, uintptr(unsafe.Pointer(interfaceGUID)), uintptr(unsafe.Pointer(interfaceLUID)), 0)
 	if r0 != 0 {